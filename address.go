@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+// labels a postal address can carry
+var addressLabels = []string{"home", "work", "other"}
+
+// Address is one labeled postal address. Country is an ISO 3166 code such
+// as MY where known, so the address can be laid out the way that country
+// writes it
+type Address struct {
+	Label      string
+	Street     []string `json:",omitempty"` // street lines, building and unit included
+	City       string   `json:",omitempty"`
+	Region     string   `json:",omitempty"` // state, province or county
+	PostalCode string   `json:",omitempty"`
+	Country    string   `json:",omitempty"`
+}
+
+// country names by ISO 3166 code, for display and for reading names typed
+// in place of a code
+var countryNames = map[string]string{
+	"AU": "Australia",
+	"BR": "Brazil",
+	"CA": "Canada",
+	"CN": "China",
+	"DE": "Germany",
+	"ES": "Spain",
+	"FR": "France",
+	"GB": "United Kingdom",
+	"HK": "Hong Kong",
+	"ID": "Indonesia",
+	"IN": "India",
+	"IT": "Italy",
+	"JP": "Japan",
+	"KR": "South Korea",
+	"MY": "Malaysia",
+	"NL": "Netherlands",
+	"NZ": "New Zealand",
+	"PH": "Philippines",
+	"SG": "Singapore",
+	"TH": "Thailand",
+	"US": "United States",
+	"VN": "Vietnam",
+}
+
+// addressFormats lays out addresses per country, in the notation of
+// Google's libaddressinput: %A street lines, %C city, %S region, %Z postal
+// code and %n a line break. the country name is added as the last line
+var addressFormats = map[string]string{
+	"AU": "%A%n%C %S %Z",
+	"BR": "%A%n%C-%S%n%Z",
+	"CA": "%A%n%C %S %Z",
+	"CN": "%Z%n%S%C%n%A",
+	"DE": "%A%n%Z %C",
+	"ES": "%A%n%Z %C %S",
+	"FR": "%A%n%Z %C",
+	"GB": "%A%n%C%n%Z",
+	"HK": "%A%n%C%n%S",
+	"ID": "%A%n%C%n%S %Z",
+	"IN": "%A%n%C %Z%n%S",
+	"IT": "%A%n%Z %C %S",
+	"JP": "〒%Z%n%S%C%n%A",
+	"KR": "%S %C%n%A%n%Z",
+	"MY": "%A%n%Z %C%n%S",
+	"NL": "%A%n%Z %C",
+	"NZ": "%A%n%C %Z",
+	"PH": "%A%n%C%n%Z %S",
+	"SG": "%A%nSINGAPORE %Z",
+	"TH": "%A%n%C%n%S %Z",
+	"US": "%A%n%C, %S %Z",
+	"VN": "%A%n%C%n%S %Z",
+}
+
+// used for countries without a format of their own
+const defaultAddressFormat = "%A%n%C %S %Z"
+
+// countryCode returns the ISO code for a code or country name, or the
+// input as typed when it is neither
+func countryCode(country string) string {
+	country = strings.TrimSpace(country)
+	if _, ok := countryNames[strings.ToUpper(country)]; ok {
+		return strings.ToUpper(country)
+	}
+	for code, name := range countryNames {
+		if strings.EqualFold(name, country) {
+			return code
+		}
+	}
+	return country
+}
+
+// CountryName returns the name of the address's country for display
+func (a Address) CountryName() string {
+	if name, ok := countryNames[a.Country]; ok {
+		return name
+	}
+	return a.Country
+}
+
+// Lines lays the address out the way its country writes it
+func (a Address) Lines() []string {
+	format, ok := addressFormats[a.Country]
+	if !ok {
+		format = defaultAddressFormat
+	}
+	var lines []string
+	for _, line := range strings.Split(format, "%n") {
+		if line == "%A" {
+			lines = append(lines, a.Street...)
+			continue
+		}
+		line = strings.NewReplacer("%C", a.City, "%S", a.Region, "%Z", a.PostalCode).Replace(line)
+		//drop separators left over from empty parts
+		line = strings.Join(strings.Fields(line), " ")
+		line = strings.Trim(line, " ,-")
+		//a postal mark with no code after it
+		if line != "" && line != "〒" {
+			lines = append(lines, line)
+		}
+	}
+	if country := a.CountryName(); country != "" {
+		lines = append(lines, country)
+	}
+	return lines
+}
+
+func (a Address) String() string {
+	return a.Label + ": " + strings.Join(a.Lines(), ", ")
+}
+
+// Empty reports whether the address has no parts filled in
+func (a Address) Empty() bool {
+	return len(a.Street) == 0 && a.City == "" && a.Region == "" && a.PostalCode == "" && a.Country == ""
+}
+
+// contains reports whether any part of the address holds the lower case keyword
+func (a Address) contains(keyword string) bool {
+	parts := append([]string{a.City, a.Region, a.PostalCode, a.Country, a.CountryName()}, a.Street...)
+	for _, part := range parts {
+		if strings.Contains(strings.ToLower(part), keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeAddresses trims every part, drops empty addresses, maps unknown
+// labels to "other" and countries to their codes
+func normalizeAddresses(addresses []Address) []Address {
+	var out []Address
+	for _, a := range addresses {
+		var street []string
+		for _, line := range a.Street {
+			if line = strings.TrimSpace(line); line != "" {
+				street = append(street, line)
+			}
+		}
+		a.Street = street
+		a.City = strings.TrimSpace(a.City)
+		a.Region = strings.TrimSpace(a.Region)
+		a.PostalCode = strings.TrimSpace(a.PostalCode)
+		a.Country = countryCode(a.Country)
+		a.Label = strings.ToLower(strings.TrimSpace(a.Label))
+		if !containsString(addressLabels, a.Label) {
+			a.Label = "other"
+		}
+		if !a.Empty() {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func formatAddresses(addresses []Address) string {
+	parts := make([]string, len(addresses))
+	for i, a := range addresses {
+		parts[i] = a.String()
+	}
+	return strings.Join(parts, "; ")
+}
+
+// encodeAddresses writes addresses in the form the merge modal submits them
+func encodeAddresses(addresses []Address) string {
+	if len(addresses) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(addresses)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func decodeAddresses(text string) ([]Address, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+	var addresses []Address
+	if err := json.Unmarshal([]byte(text), &addresses); err != nil {
+		return nil, fmt.Errorf("invalid addresses: %w", err)
+	}
+	return normalizeAddresses(addresses), nil
+}
+
+// addressesFromForm reads the address rows of the add and edit modals.
+// every row sends each part, so the lists line up by index. Street is a
+// textarea with one line per street line. an encoded Addresses field, as
+// sent by the merge modal, is read instead when present
+func addressesFromForm(r *http.Request) ([]Address, error) {
+	if text, ok := r.Form["Addresses"]; ok {
+		return decodeAddresses(strings.Join(text, ""))
+	}
+	labels := r.Form["AddressLabel"]
+	var addresses []Address
+	for i, street := range r.Form["AddressStreet"] {
+		part := func(name string) string {
+			if values := r.Form[name]; i < len(values) {
+				return values[i]
+			}
+			return ""
+		}
+		a := Address{
+			Street:     strings.Split(strings.ReplaceAll(street, "\r\n", "\n"), "\n"),
+			City:       part("AddressCity"),
+			Region:     part("AddressRegion"),
+			PostalCode: part("AddressPostalCode"),
+			Country:    part("AddressCountry"),
+		}
+		if i < len(labels) {
+			a.Label = labels[i]
+		}
+		addresses = append(addresses, a)
+	}
+	return normalizeAddresses(addresses), nil
+}
+
+var addressRowHTML = `{{define "address-row"}}
+<div class="address-row border rounded-lg p-2 mb-2">
+    <div class="flex items-center justify-between mb-2">
+        <select name="AddressLabel" class="shadow border rounded py-1 px-2 text-gray-700 text-sm focus:outline-none focus:shadow-outline">
+            {{range addressLabels}}<option value="{{.}}" {{if eq . $.Label}}selected{{end}}>{{.}}</option>{{end}}
+        </select>
+        <button type="button" hx-get="/modal/close" hx-target="closest .address-row" hx-swap="outerHTML" class="text-gray-400 hover:text-red-600" title="Remove">&times;</button>
+    </div>
+    <textarea name="AddressStreet" rows="2" placeholder="Street" class="shadow appearance-none border rounded w-full py-2 px-3 mb-2 text-gray-700 leading-tight focus:outline-none focus:shadow-outline">{{join .Street "\n"}}</textarea>
+    <div class="grid grid-cols-2 gap-2">
+        <input name="AddressCity" value="{{.City}}" placeholder="City" class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline">
+        <input name="AddressRegion" value="{{.Region}}" placeholder="State / region" class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline">
+        <input name="AddressPostalCode" value="{{.PostalCode}}" placeholder="Postal code" class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline">
+        <input name="AddressCountry" value="{{.Country}}" placeholder="Country" list="country-codes" class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline">
+    </div>
+</div>
+{{end}}
+{{define "address-rows"}}
+<datalist id="country-codes">
+    {{range $code, $name := countryNames}}<option value="{{$code}}">{{$name}}</option>{{end}}
+</datalist>
+<div id="address-rows">
+    {{range .}}{{template "address-row" .}}{{end}}
+</div>
+<button type="button" class="text-sm text-blue-600 hover:underline"
+    hx-get="/modal/row/address"
+    hx-target="#address-rows"
+    hx-swap="beforeend">
+    + Add address
+</button>
+{{end}}`
+
+var addressFuncs = template.FuncMap{
+	"addressLabels": func() []string { return addressLabels },
+	"countryNames":  func() map[string]string { return countryNames },
+	"join":          strings.Join,
+}
+
+// addressRowView returns an empty address row for the add and edit modals
+func addressRowView(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html")
+	if err := valueRowTemplate.ExecuteTemplate(w, "address-row", Address{Label: "home"}); err != nil {
+		fmt.Printf("Error rendering address row: %v\n", err)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestAddressLines(t *testing.T) {
+	tests := []struct {
+		name    string
+		address Address
+		want    []string
+	}{
+		{
+			name:    "united states",
+			address: Address{Street: []string{"1600 Amphitheatre Pkwy"}, City: "Mountain View", Region: "CA", PostalCode: "94043", Country: "US"},
+			want:    []string{"1600 Amphitheatre Pkwy", "Mountain View, CA 94043", "United States"},
+		},
+		{
+			name:    "postal code before the city",
+			address: Address{Street: []string{"Unter den Linden 1"}, City: "Berlin", PostalCode: "10117", Country: "DE"},
+			want:    []string{"Unter den Linden 1", "10117 Berlin", "Germany"},
+		},
+		{
+			name:    "region on its own line",
+			address: Address{Street: []string{"12 Jalan Ampang", "Level 3"}, City: "Kuala Lumpur", Region: "Wilayah Persekutuan", PostalCode: "50450", Country: "MY"},
+			want:    []string{"12 Jalan Ampang", "Level 3", "50450 Kuala Lumpur", "Wilayah Persekutuan", "Malaysia"},
+		},
+		{
+			name:    "postal code last",
+			address: Address{Street: []string{"10 Downing Street"}, City: "London", PostalCode: "SW1A 2AA", Country: "GB"},
+			want:    []string{"10 Downing Street", "London", "SW1A 2AA", "United Kingdom"},
+		},
+		{
+			name:    "postal mark kept before the code",
+			address: Address{Street: []string{"1-1 Chiyoda"}, City: "Chiyoda-ku", Region: "Tokyo", PostalCode: "100-0001", Country: "JP"},
+			want:    []string{"〒100-0001", "TokyoChiyoda-ku", "1-1 Chiyoda", "Japan"},
+		},
+		{
+			name:    "postal mark dropped without a code",
+			address: Address{City: "Chiyoda-ku", Region: "Tokyo", Country: "JP"},
+			want:    []string{"TokyoChiyoda-ku", "Japan"},
+		},
+		{
+			name:    "separators of missing parts dropped",
+			address: Address{City: "Springfield", Country: "US"},
+			want:    []string{"Springfield", "United States"},
+		},
+		{
+			name:    "unknown country",
+			address: Address{Street: []string{"1 Lamp Post"}, City: "Cair Paravel", Country: "Narnia"},
+			want:    []string{"1 Lamp Post", "Cair Paravel", "Narnia"},
+		},
+		{
+			name:    "no country",
+			address: Address{Street: []string{"1 Main St"}, City: "Town", PostalCode: "123"},
+			want:    []string{"1 Main St", "Town 123"},
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.address.Lines(); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: laid out as %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeAddresses(t *testing.T) {
+	got := normalizeAddresses([]Address{
+		{Label: " Work ", Street: []string{" 1 Main St ", " "}, Country: "malaysia"},
+		{Label: "holiday", City: "Ipoh", Country: "my"},
+		{Label: "home", Street: []string{" "}},
+	})
+	want := []Address{
+		{Label: "work", Street: []string{"1 Main St"}, Country: "MY"},
+		{Label: "other", City: "Ipoh", Country: "MY"},
+	}
+	if !slices.EqualFunc(got, want, func(a, b Address) bool {
+		return a.Label == b.Label && slices.Equal(a.Street, b.Street) && a.City == b.City && a.Country == b.Country
+	}) {
+		t.Errorf("normalized %+v, want %+v", got, want)
+	}
+}
+
+func TestAddressesRoundTrip(t *testing.T) {
+	addresses := []Address{{Label: "work", Street: []string{"Unit 3; Level 2", "1 Main St"}, City: "Springfield", Country: "US"}}
+	got, err := decodeAddresses(encodeAddresses(addresses))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || !slices.Equal(got[0].Street, addresses[0].Street) || got[0].City != "Springfield" {
+		t.Errorf("round trip gave %+v, want %+v", got, addresses)
+	}
+	if _, err := decodeAddresses("work: 1 Main St"); err == nil {
+		t.Error("decodeAddresses read addresses that are not JSON")
+	}
+}
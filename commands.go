@@ -0,0 +1,484 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runCommand runs a command line subcommand such as "migrate" instead of
+// starting the server
+func runCommand(name string, args []string) error {
+	switch name {
+	case "migrate":
+		return migrateCommand(args)
+	case "upgrade":
+		return upgradeCommand(args)
+	case "keygen":
+		return keygenCommand(args)
+	case "encrypt":
+		return encryptCommand(args)
+	case "decrypt":
+		return decryptCommand(args)
+	case "rotate-key":
+		return rotateKeyCommand(args)
+	case "snapshot":
+		return snapshotCommand(args)
+	default:
+		return fmt.Errorf("unknown command %q, available commands: migrate, upgrade, keygen, encrypt, decrypt, rotate-key, snapshot", name)
+	}
+}
+
+// migrate copies every contact from one storage backend to another,
+// e.g. migrate -from json:AFcb.json -to dir:contacts
+func migrateCommand(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	from := fs.String("from", "json:"+dataFile, "source storage backend")
+	to := fs.String("to", "", "destination storage backend")
+	force := fs.Bool("force", false, "copy into a destination that already has contacts")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *to == "" {
+		return fmt.Errorf("migrate needs a destination, e.g. -to dir:contacts")
+	}
+
+	src, err := openStorage(*from)
+	if err != nil {
+		return err
+	}
+	dst, err := openStorage(*to)
+	if err != nil {
+		return err
+	}
+
+	contacts, err := src.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load %s: %w", *from, err)
+	}
+	existing, err := dst.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load %s: %w", *to, err)
+	}
+	if len(existing) > 0 && !*force {
+		return fmt.Errorf("%s already holds %d contacts, use -force to copy into it anyway", *to, len(existing))
+	}
+	//check the history, organizations and photos can follow before copying anything
+	companions, err := companionFiles(*from, *to)
+	if err != nil {
+		return err
+	}
+	for _, files := range companions {
+		if _, err := os.Stat(files[1]); err == nil && !*force {
+			return fmt.Errorf("%s already exists, use -force to copy over it anyway", files[1])
+		}
+	}
+
+	//copy everything in one transaction so a failure leaves dst untouched where the backend allows
+	err = dst.Transaction(func(tx StorageTx) error {
+		for _, contact := range contacts {
+			if err := tx.Put(contact); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", *to, err)
+	}
+
+	for _, files := range companions {
+		data, err := os.ReadFile(files[0])
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(files[1]), 0700); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(files[1], data, 0600); err != nil {
+			return fmt.Errorf("failed to copy %s: %w", files[0], err)
+		}
+	}
+
+	fmt.Printf("Migrated %d contacts and %d companion files from %s to %s\n", len(contacts), len(companions), *from, *to)
+	return nil
+}
+
+// companionFiles pairs each companion file of from that exists, the sidecar
+// stores with their journals and the photos, with where it belongs for to.
+// the files are copied as they are, still sealed when the data is encrypted
+func companionFiles(from, to string) ([][2]string, error) {
+	var files [][2]string
+	add := func(src, dst string) {
+		if src == "" || filepath.Clean(src) == filepath.Clean(dst) {
+			return
+		}
+		if _, err := os.Stat(src); err == nil {
+			files = append(files, [2]string{src, dst})
+		}
+	}
+	for _, name := range sidecarNames {
+		src, dst := sidecarPath(from, name), sidecarPath(to, name)
+		add(src, dst)
+		add(src+".journal", dst+".journal")
+	}
+	if dir := photoDir(from); dir != "" {
+		entries, err := os.ReadDir(dir)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				add(filepath.Join(dir, entry.Name()), filepath.Join(photoDir(to), entry.Name()))
+			}
+		}
+	}
+	if len(files) > 0 && sidecarPath(to, "history") == "" {
+		return nil, fmt.Errorf("%s cannot keep the history, organizations and photos of %s", to, from)
+	}
+	return files, nil
+}
+
+// upgradeCommand upgrades the data file of a backend to the current
+// schema, or with -dry-run only reports what would change
+func upgradeCommand(args []string) error {
+	fs := flag.NewFlagSet("upgrade", flag.ContinueOnError)
+	spec := fs.String("storage", "json:"+dataFile, "storage backend to upgrade")
+	dryRun := fs.Bool("dry-run", false, "report what would change without writing anything")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if !*dryRun {
+		storage, err := openStorage(*spec)
+		if err != nil {
+			return err
+		}
+		//loading upgrades the data in place
+		_, err = storage.Load()
+		return err
+	}
+
+	report, err := schemaReport(*spec)
+	if err != nil {
+		return err
+	}
+	if !report.needed() {
+		fmt.Printf("%s is already at schema v%d, nothing to do\n", *spec, schemaVersion)
+		return nil
+	}
+	fmt.Printf("Would upgrade %s from schema v%d to v%d:\n", *spec, report.From, report.To)
+	for _, step := range report.Steps {
+		fmt.Println("  " + step)
+	}
+	return nil
+}
+
+// keyFlags adds -key-file and -passphrase-env flags, with an optional
+// prefix such as "new-", and returns a func that reads the chosen key
+func keyFlags(fs *flag.FlagSet, prefix string) func() (keySource, bool, error) {
+	keyFile := fs.String(prefix+"key-file", "", "file holding a 32 byte key, see keygen")
+	env := fs.String(prefix+"passphrase-env", "AFCB_"+strings.ToUpper(strings.ReplaceAll(prefix, "-", "_"))+"PASSPHRASE", "environment variable holding a passphrase")
+	return func() (keySource, bool, error) {
+		return loadKeySource(*keyFile, *env)
+	}
+}
+
+// keygen writes a new random key file for -key-file
+func keygenCommand(args []string) error {
+	fs := flag.NewFlagSet("keygen", flag.ContinueOnError)
+	out := fs.String("out", "afcb.key", "file to write the key to")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if _, err := os.Stat(*out); err == nil {
+		return fmt.Errorf("%s already exists, refusing to overwrite a key", *out)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(*out, []byte(hex.EncodeToString(key)+"\n"), dataFilePerm); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote new key to %s. Keep a copy somewhere safe, without it the data cannot be read.\n", *out)
+	return nil
+}
+
+// encrypt rewrites plain text contact data encrypted under the given key
+func encryptCommand(args []string) error {
+	fs := flag.NewFlagSet("encrypt", flag.ContinueOnError)
+	spec := fs.String("storage", "json:"+dataFile, "storage backend to encrypt")
+	key := keyFlags(fs, "")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	source, ok, err := key()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("encrypt needs -key-file or a passphrase in AFCB_PASSPHRASE")
+	}
+
+	dataKeys = newKeyring(source)
+	if err := rewriteStorage(*spec); err != nil {
+		return err
+	}
+	fmt.Printf("Encrypted %s\n", *spec)
+	return nil
+}
+
+// decrypt writes the contacts out in plain text for export, leaving the
+// encrypted data as it is
+func decryptCommand(args []string) error {
+	fs := flag.NewFlagSet("decrypt", flag.ContinueOnError)
+	spec := fs.String("storage", "json:"+dataFile, "storage backend to read")
+	out := fs.String("out", "AFcb.export.json", "file to write plain text contacts to")
+	key := keyFlags(fs, "")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	source, ok, err := key()
+	if err != nil {
+		return err
+	}
+	if ok {
+		dataKeys = newKeyring(source)
+	}
+
+	storage, err := openStorage(*spec)
+	if err != nil {
+		return err
+	}
+	contacts, err := storage.Load()
+	if err != nil {
+		return err
+	}
+	data, err := encodeContactsFile(contacts)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(*out, data, dataFilePerm); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %d contacts in plain text to %s\n", len(contacts), *out)
+	return nil
+}
+
+// rotate-key rewrites encrypted data under a new key. files are replaced
+// one at a time and both keys are accepted while reading, so running it
+// again after an interruption finishes the job
+func rotateKeyCommand(args []string) error {
+	fs := flag.NewFlagSet("rotate-key", flag.ContinueOnError)
+	spec := fs.String("storage", "json:"+dataFile, "storage backend to re-key")
+	oldKey := keyFlags(fs, "")
+	newKey := keyFlags(fs, "new-")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	oldSource, ok, err := oldKey()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("rotate-key needs the current key via -key-file or AFCB_PASSPHRASE")
+	}
+	newSource, ok, err := newKey()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("rotate-key needs the new key via -new-key-file or AFCB_NEW_PASSPHRASE")
+	}
+
+	dataKeys = newKeyring(newSource, oldSource)
+	if err := rewriteStorage(*spec); err != nil {
+		return err
+	}
+	fmt.Printf("Rotated key of %s\n", *spec)
+	return nil
+}
+
+// rewriteStorage loads a backend and its history and writes both back out
+// under the current data keys
+func rewriteStorage(spec string) error {
+	storage, err := openStorage(spec)
+	if err != nil {
+		return err
+	}
+	rw, ok := storage.(rewriter)
+	if !ok {
+		return fmt.Errorf("%s keeps nothing on disk", spec)
+	}
+	if _, err := storage.Load(); err != nil {
+		return err
+	}
+	if err := rw.Rewrite(); err != nil {
+		return err
+	}
+
+	sidecars := []sidecarStore{
+		NewHistoryStore(sidecarPath(spec, "history")),
+		NewOrgStore(sidecarPath(spec, "organizations")),
+		NewTypeStore(sidecarPath(spec, "types")),
+		NewFieldStore(sidecarPath(spec, "fields")),
+		NewGroupStore(sidecarPath(spec, "groups")),
+		NewUsageStore(sidecarPath(spec, "usage")),
+		NewActionStore(sidecarPath(spec, "actions")),
+	}
+	for _, sidecar := range sidecars {
+		if err := sidecar.Load(); err != nil {
+			return err
+		}
+		if err := sidecar.Save(); err != nil {
+			return err
+		}
+	}
+
+	if err := NewPhotoStore(photoDir(spec)).Reseal(); err != nil {
+		return err
+	}
+
+	if err := resealSnapshots(snapshotDir); err != nil {
+		return err
+	}
+
+	for _, path := range staleCopies(spec) {
+		fmt.Printf("WARNING: %s was not rewritten and may hold data under the old key or in plain text, delete it once it is no longer needed\n", path)
+	}
+	return nil
+}
+
+// staleCopies lists backups left by upgrades and recovery, which are kept
+// as they were written
+func staleCopies(spec string) []string {
+	kind, path, _ := strings.Cut(spec, ":")
+	var patterns []string
+	switch kind {
+	case "json", "journal":
+		if path == "" {
+			path = dataFile
+		}
+		patterns = []string{path + ".v*.bak", path + ".corrupt-*"}
+	case "dir":
+		if path == "" {
+			path = "contacts"
+		}
+		patterns = []string{filepath.Join(path, ".backup-v*")}
+	}
+	var found []string
+	for _, pattern := range patterns {
+		matches, _ := filepath.Glob(pattern)
+		found = append(found, matches...)
+	}
+	return found
+}
+
+// snapshot lists, takes, previews or restores point in time snapshots:
+// snapshot list|create, snapshot diff NAME or snapshot restore NAME
+func snapshotCommand(args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return fmt.Errorf("snapshot needs an action: list, create, diff NAME or restore NAME")
+	}
+	action := args[0]
+	fs := flag.NewFlagSet("snapshot "+action, flag.ContinueOnError)
+	spec := fs.String("storage", "json:"+dataFile, "storage backend to snapshot or restore into")
+	fs.StringVar(&snapshotDir, "dir", snapshotDir, "directory snapshots are kept in")
+	fs.StringVar(&snapshotKeep, "keep", snapshotKeep, "retention applied after create, e.g. 24h,14d,12m")
+	key := keyFlags(fs, "")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	source, ok, err := key()
+	if err != nil {
+		return err
+	}
+	if ok {
+		dataKeys = newKeyring(source)
+	}
+
+	name := fs.Arg(0)
+	if (action == "diff" || action == "restore") && name == "" {
+		return fmt.Errorf("snapshot %s needs a snapshot name, see snapshot list", action)
+	}
+	if action == "list" {
+		snapshots, err := listSnapshots(snapshotDir)
+		if err != nil {
+			return err
+		}
+		if len(snapshots) == 0 {
+			fmt.Printf("No snapshots in %s\n", snapshotDir)
+		}
+		for _, snapshot := range snapshots {
+			fmt.Printf("%s  %s  %d bytes\n", snapshot.Name, snapshot.At.Format("2 Jan 2006 15:04:05"), snapshot.Size)
+		}
+		return nil
+	}
+
+	storage, err := openStorage(*spec)
+	if err != nil {
+		return err
+	}
+	//the other stores too, so photos survive a restore and organizations show by name
+	store = NewContactStore(storage, NewHistoryStore(sidecarPath(*spec, "history")))
+	sidecars := openSidecars(*spec)
+	if err := store.Load(); err != nil {
+		return err
+	}
+	for _, sidecar := range sidecars {
+		if err := sidecar.store.Load(); err != nil {
+			return fmt.Errorf("failed to load %s: %w", sidecar.what, err)
+		}
+	}
+
+	switch action {
+	case "create":
+		snapshot, _, err := takeSnapshot(true)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Saved snapshot %s\n", filepath.Join(snapshotDir, snapshot.Name))
+		return pruneOldSnapshots()
+	case "diff":
+		contacts, err := readSnapshot(snapshotDir, name)
+		if err != nil {
+			return err
+		}
+		diff := diffSnapshot(store.All(), contacts)
+		if diff.Empty() {
+			fmt.Printf("%s matches %s\n", name, *spec)
+		}
+		for _, contact := range diff.Added {
+			fmt.Printf("+ %s %s (%s)\n", contact.FirstName, contact.LastName, contact.ID)
+		}
+		for _, contact := range diff.Removed {
+			fmt.Printf("- %s %s (%s)\n", contact.FirstName, contact.LastName, contact.ID)
+		}
+		for _, change := range diff.Changed {
+			fmt.Printf("~ %s %s (%s)\n", change.Contact.FirstName, change.Contact.LastName, change.Contact.ID)
+			for _, field := range change.Changes {
+				fmt.Printf("    %s: %q -> %q\n", field.Field, field.Old, field.New)
+			}
+		}
+	case "restore":
+		contacts, err := readSnapshot(snapshotDir, name)
+		if err != nil {
+			return err
+		}
+		if _, _, err := takeSnapshot(false); err != nil {
+			return fmt.Errorf("not restoring, failed to snapshot the current data first: %w", err)
+		}
+		if err := store.ReplaceAll(contacts, "", "snapshot"); err != nil {
+			return err
+		}
+		fmt.Printf("Restored %s from %s, %d contacts\n", *spec, name, len(contacts))
+	default:
+		return fmt.Errorf("unknown snapshot action %q, expected list, create, diff or restore", action)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMigrateCopiesCompanionFiles(t *testing.T) {
+	dir := t.TempDir()
+	from := "json:" + filepath.Join(dir, "AFcb.json")
+	if err := NewJSONFileStorage(filepath.Join(dir, "AFcb.json")).Put(Contact{ID: "ann", Version: 1, FirstName: "Ann"}); err != nil {
+		t.Fatal(err)
+	}
+	companions := map[string]string{
+		"AFcb.history.json":       "history",
+		"AFcb.organizations.json": "organizations",
+		"AFcb.groups.json":        "groups",
+		"AFcb.photos/abc-96.jpg":  "photo",
+	}
+	for name, data := range companions {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	to := filepath.Join(dir, "contacts")
+	if err := migrateCommand([]string{"-from", from, "-to", "dir:" + to}); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		".history.json":       "history",
+		".organizations.json": "organizations",
+		".groups.json":        "groups",
+		".photos/abc-96.jpg":  "photo",
+	} {
+		data, err := os.ReadFile(filepath.Join(to, name))
+		if err != nil || string(data) != want {
+			t.Errorf("%s holds %q, %v, want %q", name, data, err, want)
+		}
+	}
+	contacts, err := NewDirStorage(to).Load()
+	if err != nil || len(contacts) != 1 {
+		t.Errorf("migrated %d contacts, %v, want 1", len(contacts), err)
+	}
+
+	//companion files already at the destination are not overwritten
+	taken := filepath.Join(dir, "taken")
+	if err := os.MkdirAll(taken, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(taken, ".groups.json"), []byte("mine"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateCommand([]string{"-from", from, "-to", "dir:" + taken}); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("migrating over companion files returned %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(taken, ".groups.json")); string(data) != "mine" {
+		t.Errorf("existing groups file now holds %q", data)
+	}
+
+	//a backend with nowhere to keep them is refused
+	if err := migrateCommand([]string{"-from", from, "-to", "memory:"}); err == nil {
+		t.Error("migrated to memory, dropping the companion files")
+	}
+}
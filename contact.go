@@ -1,28 +1,65 @@
 package main
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	gonanoid "github.com/matoous/go-nanoid"
 )
 
 // struct for contact details
 type Contact struct {
-	ID          string
-	ContactType string
-	FirstName   string
-	LastName    string
-	Email       string
-	Phone       string
+	ID           string
+	ContactType  string
+	FirstName    string
+	LastName     string
+	Emails       []ContactValue
+	Phones       []ContactValue
+	Addresses    []Address         `json:",omitempty"`
+	Organization OrgLink           `json:",omitzero"`
+	Birthday     PartialDate       `json:",omitzero"` // year optional
+	Dates        []SignificantDate `json:",omitempty"`
+	Handles      []Handle          `json:",omitempty"` // messaging and social accounts
+	Notes        string            `json:",omitempty"`
+	Tags         []string          `json:",omitempty"` // free-form, matched ignoring case
+	Groups       []string          `json:",omitempty"` // IDs of the groups the contact is in
+	Custom       map[string]string `json:",omitempty"` // custom field values by field ID
+	Photo        string            `json:",omitempty"` // ID of the photo in the photo store
+	Favorite     bool              `json:",omitempty"` // pinned to the top of the list
+	Relations    []Relation        `json:",omitempty"` // kept on both contacts, see Relate
+	Interactions []Interaction     `json:",omitempty"` // timeline, see Timeline for display order
+	DeletedAt    time.Time         `json:",omitzero"`  // set while the contact is in the trash
+	Version      int               // bumped on every change, so stale edits can be turned away
+	CreatedAt    time.Time         `json:",omitzero"`
+	CreatedBy    string            `json:",omitempty"`
+	UpdatedAt    time.Time         `json:",omitzero"`
+	UpdatedBy    string            `json:",omitempty"`
+}
+
+// touch records who changed the contact and when
+func (c *Contact) touch(by string) {
+	c.UpdatedAt = time.Now()
+	c.UpdatedBy = by
+}
+
+// contact has been moved to the trash
+func (c Contact) InTrash() bool {
+	return !c.DeletedAt.IsZero()
 }
 
 // slice of Contact structs
 type Contacts []Contact
 
+// clone returns a copy of the slice that shares no backing array with c
+func (c Contacts) clone() Contacts {
+	out := make(Contacts, len(c))
+	copy(out, c)
+	return out
+}
+
 // generate unique 6-character ID using custom alphabet & numbers
 func genID() (string, error) {
 	id, err := gonanoid.Generate("drofylla12301993", 6)
@@ -32,8 +69,8 @@ func genID() (string, error) {
 	return id, nil
 }
 
-// create new Contact
-func (c *Contacts) New(contactType, firstName, lastName, email, phone string) (Contact, error) {
+// create new Contact from a draft holding the fields entered by the user
+func (c *Contacts) New(draft Contact, by string) (Contact, error) {
 	//gen new ID for new Contact
 	id, err := genID()
 	if err != nil {
@@ -41,14 +78,7 @@ func (c *Contacts) New(contactType, firstName, lastName, email, phone string) (C
 	}
 
 	//create new contact
-	contact := Contact{
-		ID:          id,
-		ContactType: contactType,
-		FirstName:   firstName,
-		LastName:    lastName,
-		Email:       email,
-		Phone:       phone,
-	}
+	contact := newContact(id, draft, by)
 
 	//append contacts slice
 	*c = append(*c, contact)
@@ -57,72 +87,138 @@ func (c *Contacts) New(contactType, firstName, lastName, email, phone string) (C
 	return contact, nil
 }
 
-func (c *Contacts) Save(id, contactType, firstName, lastName, email, phone string) error {
-	if id == "" {
+// newContact fills in the bookkeeping fields of a draft and tidies its lists
+func newContact(id string, draft Contact, by string) Contact {
+	contact := draft
+	contact.ID = id
+	contact.Emails = normalizeValues(draft.Emails)
+	contact.Phones = normalizeValues(draft.Phones)
+	contact.Addresses = normalizeAddresses(draft.Addresses)
+	contact.Dates = normalizeDates(draft.Dates)
+	contact.Handles = normalizeHandles(draft.Handles)
+	contact.Tags = normalizeTags(draft.Tags)
+	contact.Groups = normalizeGroups(draft.Groups)
+	contact.Custom = normalizeCustom(draft.Custom)
+	contact.DeletedAt = time.Time{}
+	contact.Version = 1
+	contact.CreatedAt = time.Now()
+	contact.CreatedBy = by
+	contact.UpdatedAt, contact.UpdatedBy = contact.CreatedAt, by
+	return contact
+}
+
+// Save adds a draft without an ID as a new contact, or replaces the user
+// entered fields of the contact with the draft's ID
+func (c *Contacts) Save(draft Contact, by string) error {
+	if draft.ID == "" {
 		//gen new id
 		newID, err := genID()
 		if err != nil {
 			return errors.New("fail to generate ID for new contact")
 		}
-
-		contact := Contact{
-			ID:          newID,
-			ContactType: contactType,
-			FirstName:   firstName,
-			LastName:    lastName,
-			Email:       email,
-			Phone:       phone,
-		}
-		*c = append(*c, contact)
+		*c = append(*c, newContact(newID, draft, by))
 		return nil
 	}
 
 	//iterate through slice to find existing contact
 	for i := range *c {
-		if (*c)[i].ID == id {
-			//update field of existing contact
-			(*c)[i].ContactType = contactType
-			(*c)[i].FirstName = firstName
-			(*c)[i].LastName = lastName
-			(*c)[i].Email = email
-			(*c)[i].Phone = phone
+		if (*c)[i].ID == draft.ID {
+			//update user entered fields, keeping the bookkeeping ones
+			old := (*c)[i]
+			(*c)[i] = newContact(draft.ID, draft, by)
+			(*c)[i].DeletedAt = old.DeletedAt
+			(*c)[i].Version = old.Version
+			(*c)[i].CreatedAt, (*c)[i].CreatedBy = old.CreatedAt, old.CreatedBy
 			return nil
 		}
 	}
 	return errors.New("contact not found")
 }
 
-func (c *Contacts) Update(id string, updates map[string]string) error {
-	fmt.Printf("Searching for contact with ID: %s\n", id)
-	fmt.Printf("Available contacts: %+v\n", *c)
+// ContactUpdate is an edit of some of a contact's user entered fields: the
+// new values in Contact and the fields to take from it in Fields, named as
+// in Contact.fields without spaces, such as "FirstName" or "CustomFields".
+// Upload is a new photo, saved only once the edit is accepted
+type ContactUpdate struct {
+	Contact
+	Fields []string
+	Upload *PhotoUpload
+}
+
+// Has reports whether the update sets the named field
+func (u ContactUpdate) Has(field string) bool {
+	for _, f := range u.Fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
 
+func (c *Contacts) Update(id string, update ContactUpdate, by string) error {
+	draft := update.Contact
 	for i := range *c {
 		if (*c)[i].ID == id {
-			fmt.Printf("Found contact %+v\n", (*c)[i])
-			for field, value := range updates {
+			for _, field := range update.Fields {
 				key := strings.ToLower(strings.ReplaceAll(field, " ", ""))
 				switch key {
 				case "contacttype":
-					(*c)[i].ContactType = value
+					(*c)[i].ContactType = draft.ContactType
 				case "firstname":
-					(*c)[i].FirstName = value
+					(*c)[i].FirstName = draft.FirstName
 				case "lastname":
-					(*c)[i].LastName = value
-				case "email":
-					(*c)[i].Email = value
-				case "phone":
-					(*c)[i].Phone = value
+					(*c)[i].LastName = draft.LastName
+				case "emails":
+					(*c)[i].Emails = normalizeValues(draft.Emails)
+				case "phones":
+					(*c)[i].Phones = normalizeValues(draft.Phones)
+				case "addresses":
+					(*c)[i].Addresses = normalizeAddresses(draft.Addresses)
+				case "organization":
+					(*c)[i].Organization.ID = draft.Organization.ID
+				case "jobtitle":
+					(*c)[i].Organization.JobTitle = draft.Organization.JobTitle
+				case "department":
+					(*c)[i].Organization.Department = draft.Organization.Department
+				case "birthday":
+					(*c)[i].Birthday = draft.Birthday
+				case "notes":
+					(*c)[i].Notes = strings.TrimSpace(draft.Notes)
+				case "dates":
+					(*c)[i].Dates = normalizeDates(draft.Dates)
+				case "handles":
+					(*c)[i].Handles = normalizeHandles(draft.Handles)
+				case "tags":
+					(*c)[i].Tags = normalizeTags(draft.Tags)
+				case "groups":
+					(*c)[i].Groups = normalizeGroups(draft.Groups)
+				case "photo":
+					(*c)[i].Photo = draft.Photo
+				case "customfields":
+					(*c)[i].Custom = normalizeCustom(draft.Custom)
 				default:
 					return fmt.Errorf("Invalid field: %s\n", field)
 				}
 			}
-			fmt.Printf("Contact info updated: %+v\n", (*c)[i])
+			(*c)[i].touch(by)
+			fmt.Printf("Contact info updated: %s\n", id)
 			return nil
 		}
 	}
 	return errors.New("Unable to update contact info due to no ID found")
 }
 
+// put replaces the contact with the same ID, or appends it if none exists
+func (c *Contacts) put(contact Contact) {
+	for i := range *c {
+		if (*c)[i].ID == contact.ID {
+			(*c)[i] = contact
+			return
+		}
+	}
+	*c = append(*c, contact)
+}
+
 func (c *Contacts) Delete(id string) error {
 	for i, contact := range *c {
 		if contact.ID == id {
@@ -133,13 +229,23 @@ func (c *Contacts) Delete(id string) error {
 	return fmt.Errorf("contact id %s not found", id)
 }
 
+// path of the last copy of the data file that was known to parse
+func backupPath(filename string) string {
+	return filename + ".bak"
+}
+
 func (c *Contacts) SaveToFile(filename string) error {
-	data, err := json.MarshalIndent(c, "", " ")
+	data, err := encodeContactsFile(*c)
 	if err != nil {
 		return fmt.Errorf("Failed to marshal contacts: %w", err)
 	}
 
-	err = os.WriteFile(filename, data, 0644)
+	//keep current file as last good copy before replacing it
+	if err := backupFile(filename); err != nil {
+		return fmt.Errorf("Failed to back up %s: %w", filename, err)
+	}
+
+	err = writeDataFile(filename, data)
 	if err != nil {
 		return fmt.Errorf("Failed to write file %s: %w", filename, err)
 	}
@@ -148,8 +254,29 @@ func (c *Contacts) SaveToFile(filename string) error {
 	return nil
 }
 
+// copy data file to its backup path, skipping files that do not parse so a
+// broken file never replaces a good backup
+func backupFile(filename string) error {
+	data, err := readDataFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if _, _, err := parseContacts(data); err != nil {
+		return nil
+	}
+	return writeDataFile(backupPath(filename), data)
+}
+
+// parse raw file data of any schema version into contacts
+func parseContacts(data []byte) (Contacts, migrationReport, error) {
+	return decodeContactsFile(data)
+}
+
 func (c *Contacts) LoadContacts(filename string) error {
-	data, err := os.ReadFile(filename)
+	data, err := readDataFile(filename)
 	if err != nil {
 		if os.IsNotExist(err) {
 			*c = Contacts{}
@@ -159,34 +286,99 @@ func (c *Contacts) LoadContacts(filename string) error {
 		return fmt.Errorf("failed to read file %s: %w", filename, err)
 	}
 
-	//check if file is empty
-	if len(data) == 0 {
+	loaded, report, err := parseContacts(data)
+	if err == nil {
+		*c = loaded
+		fmt.Printf("Successfully loaded %d contacts from %s\n", len(*c), filename)
+		//older schema versions are upgraded in place
+		if report.needed() {
+			return upgradeFile(filename, data, loaded, report)
+		}
+		return nil
+	}
+
+	//an empty file with nothing to recover is a fresh contact book
+	backup, backupErr := readDataFile(backupPath(filename))
+	if len(data) == 0 && os.IsNotExist(backupErr) {
 		*c = Contacts{}
 		fmt.Printf("Contacts file is empty. Starting with empty contacts.\n")
 		return nil
 	}
 
-	if err := json.Unmarshal(data, c); err != nil {
-		return fmt.Errorf("Failed to unmarshal contacts data: %w", err)
+	fmt.Printf("WARNING: %s is unreadable: %v\n", filename, err)
+	if backupErr != nil {
+		return fmt.Errorf("%s is unreadable (%v) and no backup could be read (%v)", filename, err, backupErr)
+	}
+	recovered, report, backupErr := parseContacts(backup)
+	if backupErr != nil {
+		return fmt.Errorf("%s is unreadable (%v) and backup %s is unreadable too (%v)", filename, err, backupPath(filename), backupErr)
+	}
+
+	//keep broken file for inspection, then put last good copy back in place
+	corrupt := filename + ".corrupt-" + time.Now().Format("20060102-150405")
+	if err := os.Rename(filename, corrupt); err != nil {
+		return fmt.Errorf("failed to move unreadable %s aside: %w", filename, err)
+	}
+	if err := writeDataFile(filename, backup); err != nil {
+		return fmt.Errorf("failed to restore %s from backup: %w", filename, err)
 	}
 
-	fmt.Printf("Successfully loaded %d contacts from %s\n", len(*c), filename)
+	*c = recovered
+	fmt.Printf("WARNING: recovered %d contacts from %s, unreadable file kept as %s\n", len(*c), backupPath(filename), corrupt)
+	if report.needed() {
+		return upgradeFile(filename, backup, recovered, report)
+	}
 	return nil
 }
 
+// active returns the contacts that are not in the trash
+func (c Contacts) active() Contacts {
+	var out Contacts
+	for _, contact := range c {
+		if !contact.InTrash() {
+			out = append(out, contact)
+		}
+	}
+	return out
+}
+
+// trashed returns the contacts that are in the trash
+func (c Contacts) trashed() Contacts {
+	var out Contacts
+	for _, contact := range c {
+		if contact.InTrash() {
+			out = append(out, contact)
+		}
+	}
+	return out
+}
+
+// search contacts outside the trash
 func (c *Contacts) Search(keyword string) Contacts {
 	if keyword == "" {
-		return *c
+		return c.active()
 	}
 
 	keyword = strings.ToLower(keyword)
 	var results Contacts
 
 	for _, c := range *c {
+		if c.InTrash() {
+			continue
+		}
 		if strings.Contains(strings.ToLower(c.FirstName), keyword) ||
 			strings.Contains(strings.ToLower(c.LastName), keyword) ||
-			strings.Contains(strings.ToLower(c.Email), keyword) ||
-			strings.Contains(strings.ToLower(c.Phone), keyword) ||
+			containsValue(c.Emails, keyword) ||
+			containsValue(c.Phones, keyword) ||
+			containsAddress(c.Addresses, keyword) ||
+			containsHandle(c.Handles, keyword) ||
+			strings.Contains(strings.ToLower(orgName(c.Organization.ID)), keyword) ||
+			strings.Contains(strings.ToLower(c.Organization.Role()), keyword) ||
+			strings.Contains(strings.ToLower(c.Notes), keyword) ||
+			containsTimeline(c.Interactions, keyword) ||
+			strings.Contains(strings.ToLower(formatTags(c.Tags)), keyword) ||
+			strings.Contains(strings.ToLower(formatGroups(c.Groups)), keyword) ||
+			containsCustom(c, keyword) ||
 			strings.Contains(strings.ToLower(c.ContactType), keyword) {
 			results = append(results, c)
 		}
@@ -194,6 +386,26 @@ func (c *Contacts) Search(keyword string) Contacts {
 	return results
 }
 
+// containsValue reports whether any value holds the lower case keyword
+func containsValue(values []ContactValue, keyword string) bool {
+	for _, v := range values {
+		if strings.Contains(strings.ToLower(v.Value), keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsAddress reports whether any part of an address holds the lower case keyword
+func containsAddress(addresses []Address, keyword string) bool {
+	for _, a := range addresses {
+		if a.contains(keyword) {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *Contacts) Find(id string) (Contact, error) {
 	for _, contact := range *c {
 		if contact.ID == id {
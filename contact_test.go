@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadContactsRecoversBackup(t *testing.T) {
+	tests := []struct {
+		name    string
+		damaged string
+	}{
+		{name: "truncated", damaged: `[{"ID": "al`},
+		{name: "empty", damaged: ""},
+		{name: "not json", damaged: "\x00\x01garbage"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "AFcb.json")
+			first := Contacts{{ID: "alice", FirstName: "Alice"}}
+			second := append(first.clone(), Contact{ID: "bob", FirstName: "Bob"})
+			if err := first.SaveToFile(filename); err != nil {
+				t.Fatal(err)
+			}
+			//the second save keeps the first as the last good copy
+			if err := second.SaveToFile(filename); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filename, []byte(tt.damaged), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			var loaded Contacts
+			if err := loaded.LoadContacts(filename); err != nil {
+				t.Fatal(err)
+			}
+			assertContacts(t, loaded, first)
+			corrupt, _ := filepath.Glob(filename + ".corrupt-*")
+			if len(corrupt) != 1 {
+				t.Errorf("found %d unreadable copies kept aside, want 1", len(corrupt))
+			}
+			var reloaded Contacts
+			if err := reloaded.LoadContacts(filename); err != nil {
+				t.Fatal(err)
+			}
+			assertContacts(t, reloaded, first)
+		})
+	}
+}
+
+// assertContacts compares contacts by ID, ignoring their order
+func assertContacts(t *testing.T, got, want Contacts) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d contacts, want %d", len(got), len(want))
+	}
+	for _, w := range want {
+		g, err := got.Find(w.ID)
+		if err != nil {
+			t.Fatalf("contact %s is missing", w.ID)
+		}
+		if !reflect.DeepEqual(g, w) {
+			t.Errorf("contact %s = %+v, want %+v", w.ID, g, w)
+		}
+	}
+}
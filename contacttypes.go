@@ -0,0 +1,316 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// colours a contact type can take, named after the Tailwind palette the
+// badges are drawn with
+var typeColours = []string{"gray", "red", "orange", "yellow", "green", "teal", "blue", "indigo", "purple", "pink"}
+
+// ContactTypeDef is a contact type users can pick, such as Work or Client
+type ContactTypeDef struct {
+	ID     string
+	Name   string
+	Colour string
+	Icon   string `json:",omitempty"` // short text shown before the name, usually an emoji
+}
+
+// types offered before any have been set up, as they were hard coded
+var defaultContactTypes = []ContactTypeDef{
+	{ID: "personal", Name: "Personal", Colour: "blue"},
+	{ID: "work", Name: "Work", Colour: "green"},
+	{ID: "family", Name: "Family", Colour: "purple"},
+}
+
+// TypeStore keeps the contact types in a file beside the contact data. an
+// empty filename keeps them in memory only
+type TypeStore struct {
+	mu       sync.RWMutex
+	filename string
+	types    []ContactTypeDef
+}
+
+// contact types shared by the handlers
+var contactTypes = NewTypeStore("")
+
+func NewTypeStore(filename string) *TypeStore {
+	return &TypeStore{filename: filename, types: defaultContactTypes}
+}
+
+// Load reads the types file, keeping the defaults when there is none yet
+func (t *TypeStore) Load() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.filename == "" {
+		return nil
+	}
+	var loaded []ContactTypeDef
+	if err := loadJSONFile(t.filename, &loaded); err != nil {
+		return err
+	}
+	if len(loaded) > 0 {
+		t.types = loaded
+	}
+	return nil
+}
+
+// save writes the types file, the caller holding the lock
+func (t *TypeStore) save() error {
+	if t.filename == "" {
+		return nil
+	}
+	return saveJSONFile(t.filename, t.types)
+}
+
+// Save writes the types file again, used when the data key changes
+func (t *TypeStore) Save() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.save()
+}
+
+// List returns the types in the order they are offered
+func (t *TypeStore) List() []ContactTypeDef {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return append([]ContactTypeDef(nil), t.types...)
+}
+
+// Lookup returns the type with a name, matched ignoring case. unknown names,
+// such as those of imported contacts, come back grey
+func (t *TypeStore) Lookup(name string) ContactTypeDef {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, def := range t.types {
+		if strings.EqualFold(def.Name, name) {
+			return def
+		}
+	}
+	return ContactTypeDef{Name: name, Colour: "gray"}
+}
+
+// Put adds a type without an ID or replaces the one with its ID, returning
+// the saved type and the name it had before
+func (t *TypeStore) Put(def ContactTypeDef) (ContactTypeDef, string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	def.Name = strings.Join(strings.Fields(def.Name), " ")
+	def.Icon = strings.TrimSpace(def.Icon)
+	switch {
+	case def.Name == "":
+		return ContactTypeDef{}, "", errors.New("Type name is required")
+	case strings.Contains(def.Name, ","):
+		return ContactTypeDef{}, "", errors.New("Type names cannot contain commas")
+	case !containsString(typeColours, def.Colour):
+		return ContactTypeDef{}, "", fmt.Errorf("Invalid colour: %s", def.Colour)
+	case len(def.Icon) > 16:
+		return ContactTypeDef{}, "", errors.New("Icon is too long, use an emoji or a few letters")
+	}
+	for _, other := range t.types {
+		if other.ID != def.ID && strings.EqualFold(other.Name, def.Name) {
+			return ContactTypeDef{}, "", fmt.Errorf("There is already a type named %s", other.Name)
+		}
+	}
+
+	before := append([]ContactTypeDef(nil), t.types...)
+	previous := ""
+	if def.ID == "" {
+		id, err := genID()
+		if err != nil {
+			return ContactTypeDef{}, "", err
+		}
+		def.ID = id
+		t.types = append(before, def)
+	} else {
+		i := t.index(def.ID)
+		if i < 0 {
+			return ContactTypeDef{}, "", fmt.Errorf("No contact type found with id %s", def.ID)
+		}
+		previous = t.types[i].Name
+		t.types = append([]ContactTypeDef(nil), before...)
+		t.types[i] = def
+	}
+	if err := t.save(); err != nil {
+		t.types = before
+		return ContactTypeDef{}, "", err
+	}
+	return def, previous, nil
+}
+
+// Delete removes a type, keeping at least one to pick from
+func (t *TypeStore) Delete(id string) (ContactTypeDef, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	i := t.index(id)
+	if i < 0 {
+		return ContactTypeDef{}, fmt.Errorf("No contact type found with id %s", id)
+	}
+	if len(t.types) == 1 {
+		return ContactTypeDef{}, errors.New("Keep at least one contact type")
+	}
+	before := t.types
+	deleted := t.types[i]
+	t.types = append(t.types[:i:i], t.types[i+1:]...)
+	if err := t.save(); err != nil {
+		t.types = before
+		return ContactTypeDef{}, err
+	}
+	return deleted, nil
+}
+
+// index finds a type by ID, the caller holding the lock
+func (t *TypeStore) index(id string) int {
+	for i, def := range t.types {
+		if def.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// ofType counts the contacts of each type, trash included, keyed by lower
+// case name
+func ofType(contacts Contacts) map[string]int {
+	counts := map[string]int{}
+	for _, contact := range contacts {
+		counts[strings.ToLower(contact.ContactType)]++
+	}
+	return counts
+}
+
+var typeSelectHTML = `{{define "type-select"}}
+<select id="contactType" name="ContactType" class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline">
+    {{$found := false}}
+    {{range contactTypes}}
+    <option value="{{.Name}}" {{if eq .Name $}}{{$found = true}}selected{{end}}>{{with .Icon}}{{.}} {{end}}{{.Name}}</option>
+    {{end}}
+    {{if and . (not $found)}}<option value="{{.}}" selected>{{.}}</option>{{end}}
+</select>
+{{end}}`
+
+var typeSettingsHTML = `{{define "type-settings"}}
+<h4 class="text-lg font-bold mb-1">Contact types</h4>
+<p class="text-sm text-gray-500 mb-2">Renaming a type renames it on every contact that has it.</p>
+{{range .Types}}
+{{$n := index $.TypeCounts (lower .Name)}}
+<form class="flex items-center gap-2 p-2 mb-2 border rounded-lg"
+      hx-put="/settings/types/{{.ID}}"
+      hx-target="#modal-container"
+      hx-swap="innerHTML">
+    <span class="inline-block w-28 px-3 py-1 rounded-full text-sm font-medium text-center truncate bg-{{.Colour}}-100 text-{{.Colour}}-800">{{with .Icon}}{{.}} {{end}}{{.Name}}</span>
+    {{template "type-fields" .}}
+    <button type="submit" class="px-3 py-1 text-sm rounded-lg border border-gray-300 hover:border-blue-500 hover:bg-blue-50 transition-colors">Save</button>
+    {{if $n}}
+    <span class="w-16 text-xs text-gray-500 text-center">{{$n}} in use</span>
+    {{else}}
+    <button type="button" class="w-16 px-3 py-1 text-sm rounded-lg border border-gray-300 hover:border-red-500 hover:bg-red-50 transition-colors"
+        hx-delete="/settings/types/{{.ID}}"
+        hx-target="#modal-container"
+        hx-swap="innerHTML"
+        hx-confirm="Delete this contact type?">
+        Delete
+    </button>
+    {{end}}
+</form>
+{{end}}
+<form class="flex items-center gap-2 p-2 mb-2 border border-dashed rounded-lg"
+      hx-post="/settings/types"
+      hx-target="#modal-container"
+      hx-swap="innerHTML">
+    <span class="w-28 text-sm text-gray-500">New type</span>
+    {{template "type-fields" .New}}
+    <button type="submit" class="px-3 py-1 text-sm rounded-lg bg-blue-600 text-white hover:bg-blue-700 transition-colors">Add</button>
+    <span class="w-16"></span>
+</form>
+{{end}}
+{{define "type-fields"}}
+<input name="Name" value="{{.Name}}" placeholder="Name" required class="flex-1 min-w-0 shadow appearance-none border rounded py-1 px-2 text-gray-700 text-sm focus:outline-none focus:shadow-outline">
+<select name="Colour" class="shadow border rounded py-1 px-2 text-gray-700 text-sm focus:outline-none focus:shadow-outline">
+    {{range typeColours}}<option value="{{.}}" {{if eq . $.Colour}}selected{{end}}>{{.}}</option>{{end}}
+</select>
+<input name="Icon" value="{{.Icon}}" placeholder="Icon" maxlength="16" class="w-16 shadow appearance-none border rounded py-1 px-2 text-gray-700 text-sm focus:outline-none focus:shadow-outline">
+{{end}}`
+
+var typeFuncs = template.FuncMap{
+	"contactTypes": func() []ContactTypeDef { return contactTypes.List() },
+	"typeColours":  func() []string { return typeColours },
+	"lower":        strings.ToLower,
+}
+
+// saveType adds a contact type, or updates the one in the URL. a rename is
+// carried over to every contact of the type
+func saveType(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	def, previous, err := contactTypes.Put(ContactTypeDef{
+		ID:     mux.Vars(r)["id"],
+		Name:   r.FormValue("Name"),
+		Colour: r.FormValue("Colour"),
+		Icon:   r.FormValue("Icon"),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fmt.Printf("Contact type saved: %s (%s)\n", def.Name, def.ID)
+
+	if previous != "" && previous != def.Name {
+		n, err := store.ChangeAll(currentUser(r), "type", func(c *Contact) bool {
+			if !strings.EqualFold(c.ContactType, previous) {
+				return false
+			}
+			c.ContactType = def.Name
+			return true
+		})
+		if err != nil {
+			http.Error(w, "Type renamed but contacts were not updated: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Printf("Renamed contact type %q to %q on %d contacts\n", previous, def.Name, n)
+	}
+	renderSettings(w, true)
+}
+
+// deleteType removes a contact type no contact has, trash included. the
+// check and the delete hold the store lock together, so no contact can be
+// given the type in between
+func deleteType(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	var def ContactTypeDef
+	_, err := store.Unlink(currentUser(r), "type", func(contacts Contacts) error {
+		for _, d := range contactTypes.List() {
+			if d.ID == id && ofType(contacts)[strings.ToLower(d.Name)] > 0 {
+				return inUseError("Move every contact off this type first")
+			}
+		}
+		return nil
+	}, nil, func() error {
+		var err error
+		def, err = contactTypes.Delete(id)
+		return err
+	})
+	var inUse inUseError
+	if errors.As(err, &inUse) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fmt.Printf("Contact type %s deleted\n", def.Name)
+	renderSettings(w, true)
+}
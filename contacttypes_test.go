@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// useTestTypes points the handlers at a types file in a temporary directory
+func useTestTypes(t *testing.T) string {
+	t.Helper()
+	old := contactTypes
+	filename := filepath.Join(t.TempDir(), "contacts.types.json")
+	contactTypes = NewTypeStore(filename)
+	t.Cleanup(func() { contactTypes = old })
+	return filename
+}
+
+func TestTypeStorePut(t *testing.T) {
+	useTestTypes(t)
+	tests := []struct {
+		name string
+		def  ContactTypeDef
+		err  string
+	}{
+		{name: "no name", def: ContactTypeDef{Name: "  ", Colour: "red"}, err: "required"},
+		{name: "comma", def: ContactTypeDef{Name: "a, b", Colour: "red"}, err: "commas"},
+		{name: "colour", def: ContactTypeDef{Name: "Client", Colour: "mauve"}, err: "Invalid colour"},
+		{name: "taken", def: ContactTypeDef{Name: "work", Colour: "red"}, err: "already a type"},
+		{name: "unknown id", def: ContactTypeDef{ID: "nope", Name: "Client", Colour: "red"}, err: "No contact type"},
+		{name: "new", def: ContactTypeDef{Name: " Key  client ", Colour: "red"}},
+	}
+	for _, tt := range tests {
+		def, _, err := contactTypes.Put(tt.def)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+		case tt.err == "" && (def.ID == "" || def.Name != "Key client"):
+			t.Errorf("%s: saved %+v", tt.name, def)
+		}
+	}
+	if got := len(contactTypes.List()); got != len(defaultContactTypes)+1 {
+		t.Errorf("%d types, want %d", got, len(defaultContactTypes)+1)
+	}
+}
+
+func TestRenameTypeUpdatesContacts(t *testing.T) {
+	filename := useTestTypes(t)
+	s := useTestStore(t,
+		Contact{ID: "ann", Version: 1, ContactType: "Work", FirstName: "Ann"},
+		Contact{ID: "bob", Version: 1, ContactType: "work", FirstName: "Bob"},
+		Contact{ID: "cat", Version: 1, ContactType: "Family", FirstName: "Cat"},
+	)
+
+	form := url.Values{"Name": {"Office"}, "Colour": {"teal"}}
+	req := httptest.NewRequest(http.MethodPost, "/settings/types/work", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = mux.SetURLVars(req, map[string]string{"id": "work"})
+	rec := httptest.NewRecorder()
+	saveType(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+
+	for id, want := range map[string]string{"ann": "Office", "bob": "Office", "cat": "Family"} {
+		c, err := s.Find(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.ContactType != want {
+			t.Errorf("%s is of type %q, want %q", id, c.ContactType, want)
+		}
+	}
+	if revs := s.History("ann"); revs[len(revs)-1].Action != "type" {
+		t.Errorf("rename recorded as %q, want type", revs[len(revs)-1].Action)
+	}
+
+	reloaded := NewTypeStore(filename)
+	if err := reloaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if def := reloaded.Lookup("office"); def.ID != "work" || def.Colour != "teal" {
+		t.Errorf("reloaded type %+v", def)
+	}
+}
+
+func TestDeleteTypeInUse(t *testing.T) {
+	useTestTypes(t)
+	useTestStore(t, Contact{ID: "ann", Version: 1, ContactType: "Work", FirstName: "Ann", DeletedAt: day("2025-01-01")})
+
+	req := mux.SetURLVars(httptest.NewRequest(http.MethodDelete, "/settings/types/work", nil), map[string]string{"id": "work"})
+	rec := httptest.NewRecorder()
+	deleteType(rec, req)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("status %d, want %d", rec.Code, http.StatusConflict)
+	}
+	if def := contactTypes.Lookup("Work"); def.ID != "work" {
+		t.Error("type in use by a trashed contact was deleted")
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	sealedFormat     = "afcb-encrypted"
+	sealedPrefix     = `{"Format":"` + sealedFormat + `"`
+	pbkdf2Iterations = 600000
+)
+
+// sealedFile is how an encrypted data file is stored. Data is sealed with
+// AES-256-GCM under a key read from a key file or derived from a passphrase
+type sealedFile struct {
+	Format     string
+	Version    int
+	KDF        string // pbkdf2-sha256 or key-file
+	Iterations int    `json:",omitempty"`
+	Salt       []byte `json:",omitempty"`
+	Nonce      []byte
+	Data       []byte
+}
+
+// keySource is a passphrase or a raw 32 byte key from a key file
+type keySource struct {
+	passphrase string
+	key        []byte
+}
+
+// keyring seals data with its first source and opens data sealed by any
+// of them, so files still under the old key stay readable during rotation
+type keyring struct {
+	mu      sync.Mutex
+	sources []keySource
+	salt    []byte
+	derived map[string][]byte
+}
+
+// keys used for data files, nil keeps them in plain text
+var dataKeys *keyring
+
+func newKeyring(sources ...keySource) *keyring {
+	return &keyring{sources: sources, derived: map[string][]byte{}}
+}
+
+// readKeyFile loads a 32 byte key stored raw, as hex or as base64
+func readKeyFile(filename string) (keySource, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return keySource{}, fmt.Errorf("failed to read key file %s: %w", filename, err)
+	}
+	if len(data) == 32 {
+		return keySource{key: data}, nil
+	}
+	text := strings.TrimSpace(string(data))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == 32 {
+		return keySource{key: key}, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == 32 {
+		return keySource{key: key}, nil
+	}
+	return keySource{}, fmt.Errorf("key file %s must hold a 32 byte key as raw bytes, hex or base64", filename)
+}
+
+// loadKeySource picks a key file over a passphrase from the named
+// environment variable. ok is false when neither is set
+func loadKeySource(keyFile, passphraseEnv string) (keySource, bool, error) {
+	if keyFile != "" {
+		source, err := readKeyFile(keyFile)
+		return source, err == nil, err
+	}
+	if passphraseEnv != "" {
+		if passphrase := os.Getenv(passphraseEnv); passphrase != "" {
+			return keySource{passphrase: passphrase}, true, nil
+		}
+	}
+	return keySource{}, false, nil
+}
+
+// key returns the AES key for a source, deriving passphrase keys once per salt
+func (k *keyring) key(i int, salt []byte, iterations int) ([]byte, error) {
+	source := k.sources[i]
+	if source.key != nil {
+		return source.key, nil
+	}
+	cacheKey := fmt.Sprintf("%d/%x/%d", i, salt, iterations)
+	if key, ok := k.derived[cacheKey]; ok {
+		return key, nil
+	}
+	key, err := pbkdf2.Key(sha256.New, source.passphrase, salt, iterations, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	k.derived[cacheKey] = key
+	return key, nil
+}
+
+func (k *keyring) seal(plain []byte) ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	out := sealedFile{Format: sealedFormat, Version: 1, KDF: "key-file"}
+	if k.sources[0].key == nil {
+		//one salt per run keeps the slow key derivation off every save
+		if k.salt == nil {
+			k.salt = make([]byte, 16)
+			if _, err := rand.Read(k.salt); err != nil {
+				return nil, err
+			}
+		}
+		out.KDF, out.Iterations, out.Salt = "pbkdf2-sha256", pbkdf2Iterations, k.salt
+	}
+	key, err := k.key(0, out.Salt, out.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	out.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(out.Nonce); err != nil {
+		return nil, err
+	}
+	out.Data = gcm.Seal(nil, out.Nonce, plain, sealedAAD(out))
+	return json.Marshal(out)
+}
+
+func (k *keyring) open(in sealedFile) ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	for i, source := range k.sources {
+		if (in.KDF == "key-file") != (source.key != nil) {
+			continue
+		}
+		key, err := k.key(i, in.Salt, in.Iterations)
+		if err != nil {
+			return nil, err
+		}
+		gcm, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		if plain, err := gcm.Open(nil, in.Nonce, in.Data, sealedAAD(in)); err == nil {
+			return plain, nil
+		}
+	}
+	return nil, errors.New("wrong key or passphrase, or the file has been tampered with")
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// header fields are bound to the ciphertext so they cannot be swapped
+func sealedAAD(f sealedFile) []byte {
+	return fmt.Appendf(nil, "%s/%d/%s/%d", f.Format, f.Version, f.KDF, f.Iterations)
+}
+
+func isSealed(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte(sealedPrefix))
+}
+
+// sealData encrypts data when a key is configured
+func sealData(plain []byte) ([]byte, error) {
+	if dataKeys == nil {
+		return plain, nil
+	}
+	return dataKeys.seal(plain)
+}
+
+// openData decrypts sealed data and passes plain text through unchanged,
+// so files written before encryption was turned on stay readable
+func openData(data []byte) ([]byte, error) {
+	if !isSealed(data) {
+		return data, nil
+	}
+	var in sealedFile
+	if err := json.Unmarshal(bytes.TrimSpace(data), &in); err != nil {
+		return nil, fmt.Errorf("failed to read encrypted data: %w", err)
+	}
+	if dataKeys == nil {
+		return nil, errors.New("data is encrypted, start with -key-file or set the passphrase environment variable")
+	}
+	return dataKeys.open(in)
+}
+
+// readDataFile reads a data file, decrypting it if it is sealed
+func readDataFile(filename string) ([]byte, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := openData(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return plain, nil
+}
+
+// writeDataFile writes a data file atomically, sealing it if a key is set
+func writeDataFile(filename string, data []byte) error {
+	sealed, err := sealData(data)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt %s: %w", filename, err)
+	}
+	return writeFileAtomic(filename, sealed, dataFilePerm)
+}
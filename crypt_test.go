@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestKeyringSealOpen(t *testing.T) {
+	oldKey := keySource{key: bytes.Repeat([]byte{1}, 32)}
+	newKey := keySource{key: bytes.Repeat([]byte{2}, 32)}
+	passphrase := keySource{passphrase: "correct horse battery staple"}
+	plain := []byte(`{"Version":3,"Contacts":[]}`)
+
+	tests := []struct {
+		name   string
+		seal   *keyring
+		open   *keyring
+		tamper bool
+		err    bool
+	}{
+		{name: "key file", seal: newKeyring(oldKey), open: newKeyring(oldKey)},
+		{name: "passphrase", seal: newKeyring(passphrase), open: newKeyring(passphrase)},
+		{name: "rotated, still under the old key", seal: newKeyring(oldKey), open: newKeyring(newKey, oldKey)},
+		{name: "rotated, under the new key", seal: newKeyring(newKey, oldKey), open: newKeyring(newKey)},
+		{name: "wrong key", seal: newKeyring(oldKey), open: newKeyring(newKey), err: true},
+		{name: "wrong passphrase", seal: newKeyring(passphrase), open: newKeyring(keySource{passphrase: "guess"}), err: true},
+		{name: "tampered", seal: newKeyring(oldKey), open: newKeyring(oldKey), tamper: true, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sealed, err := tt.seal.seal(plain)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !isSealed(sealed) || bytes.Contains(sealed, []byte("Contacts")) {
+				t.Fatalf("sealed data is readable: %s", sealed)
+			}
+			var in sealedFile
+			if err := json.Unmarshal(sealed, &in); err != nil {
+				t.Fatal(err)
+			}
+			if tt.tamper {
+				in.Data[0] ^= 1
+			}
+			got, err := tt.open.open(in)
+			if tt.err {
+				if err == nil {
+					t.Fatal("opened data sealed under another key")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Errorf("opened %q, want %q", got, plain)
+			}
+		})
+	}
+}
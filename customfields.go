@@ -0,0 +1,505 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// kinds of value a custom field can hold
+var fieldTypes = []string{"text", "number", "date", "url", "select", "checkbox"}
+
+// FieldDef is a field admins add to every contact, such as a customer
+// number. values are kept on the contact by the field's ID, so the field can
+// be renamed freely
+type FieldDef struct {
+	ID       string
+	Name     string
+	Type     string
+	Required bool     `json:",omitempty"`
+	Options  []string `json:",omitempty"` // choices of a select
+	Pattern  string   `json:",omitempty"` // regular expression a text value must match
+	Min      *float64 `json:",omitempty"` // bounds of a number
+	Max      *float64 `json:",omitempty"`
+}
+
+// Check validates a value for the field and returns it the way it is
+// saved: numbers without trailing zeros, dates as YYYY-MM-DD, links with a
+// scheme and ticked checkboxes as "yes"
+func (f FieldDef) Check(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if f.Type == "checkbox" {
+		switch strings.ToLower(value) {
+		case "yes", "true", "on", "1":
+			value = "yes"
+		default:
+			value = ""
+		}
+	}
+	if value == "" {
+		if f.Required {
+			return "", fmt.Errorf("%s is required", f.Name)
+		}
+		return "", nil
+	}
+
+	switch f.Type {
+	case "number":
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return "", fmt.Errorf("%s must be a number", f.Name)
+		}
+		if f.Min != nil && n < *f.Min {
+			return "", fmt.Errorf("%s must be at least %s", f.Name, formatNumber(*f.Min))
+		}
+		if f.Max != nil && n > *f.Max {
+			return "", fmt.Errorf("%s must be at most %s", f.Name, formatNumber(*f.Max))
+		}
+		return formatNumber(n), nil
+	case "date":
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			return "", fmt.Errorf("%s must be a date, YYYY-MM-DD", f.Name)
+		}
+	case "url":
+		address, err := webAddress(value)
+		if err != nil {
+			return "", fmt.Errorf("%s must be a web address", f.Name)
+		}
+		value = address
+	case "select":
+		if !containsString(f.Options, value) {
+			return "", fmt.Errorf("%s must be one of %s", f.Name, strings.Join(f.Options, ", "))
+		}
+	case "text":
+		if f.Pattern != "" && !regexp.MustCompile(`^(?:`+f.Pattern+`)$`).MatchString(value) {
+			return "", fmt.Errorf("%s does not have the expected format", f.Name)
+		}
+	}
+	return value, nil
+}
+
+func formatNumber(n float64) string {
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
+
+// Display shows a saved value on the card
+func (f FieldDef) Display(value string) string {
+	switch f.Type {
+	case "checkbox":
+		return "Yes"
+	case "date":
+		if t, err := time.Parse("2006-01-02", value); err == nil {
+			return t.Format("2 Jan 2006")
+		}
+	}
+	return value
+}
+
+// FieldStore keeps the custom field definitions in a file beside the
+// contact data. an empty filename keeps them in memory only
+type FieldStore struct {
+	mu       sync.RWMutex
+	filename string
+	fields   []FieldDef
+}
+
+// custom fields shared by the handlers
+var customFields = NewFieldStore("")
+
+func NewFieldStore(filename string) *FieldStore {
+	return &FieldStore{filename: filename}
+}
+
+func (f *FieldStore) Load() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.filename == "" {
+		return nil
+	}
+	var loaded []FieldDef
+	if err := loadJSONFile(f.filename, &loaded); err != nil {
+		return err
+	}
+	f.fields = loaded
+	return nil
+}
+
+// save writes the fields file, the caller holding the lock
+func (f *FieldStore) save() error {
+	if f.filename == "" {
+		return nil
+	}
+	return saveJSONFile(f.filename, f.fields)
+}
+
+// Save writes the fields file again, used when the data key changes
+func (f *FieldStore) Save() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.save()
+}
+
+// List returns the fields in the order they are shown
+func (f *FieldStore) List() []FieldDef {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return append([]FieldDef(nil), f.fields...)
+}
+
+// Put adds a field without an ID or replaces the one with its ID
+func (f *FieldStore) Put(def FieldDef) (FieldDef, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	def.Name = strings.Join(strings.Fields(def.Name), " ")
+	def.Options = normalizeTags(def.Options)
+	switch {
+	case def.Name == "":
+		return FieldDef{}, errors.New("Field name is required")
+	case !containsString(fieldTypes, def.Type):
+		return FieldDef{}, fmt.Errorf("Invalid field type: %s", def.Type)
+	case def.Type == "select" && len(def.Options) == 0:
+		return FieldDef{}, errors.New("A select needs at least one option")
+	case def.Min != nil && def.Max != nil && *def.Min > *def.Max:
+		return FieldDef{}, errors.New("Minimum is above the maximum")
+	}
+	if def.Pattern != "" {
+		if _, err := regexp.Compile(def.Pattern); err != nil {
+			return FieldDef{}, fmt.Errorf("Invalid pattern: %w", err)
+		}
+	}
+	//keep only the rules that apply to the type
+	if def.Type != "select" {
+		def.Options = nil
+	}
+	if def.Type != "text" {
+		def.Pattern = ""
+	}
+	if def.Type != "number" {
+		def.Min, def.Max = nil, nil
+	}
+	for _, other := range f.fields {
+		if other.ID != def.ID && strings.EqualFold(other.Name, def.Name) {
+			return FieldDef{}, fmt.Errorf("There is already a field named %s", other.Name)
+		}
+	}
+
+	before := append([]FieldDef(nil), f.fields...)
+	if def.ID == "" {
+		id, err := genID()
+		if err != nil {
+			return FieldDef{}, err
+		}
+		def.ID = id
+		f.fields = append(before, def)
+	} else {
+		i := f.index(def.ID)
+		if i < 0 {
+			return FieldDef{}, fmt.Errorf("No custom field found with id %s", def.ID)
+		}
+		f.fields = append([]FieldDef(nil), before...)
+		f.fields[i] = def
+	}
+	if err := f.save(); err != nil {
+		f.fields = before
+		return FieldDef{}, err
+	}
+	return def, nil
+}
+
+func (f *FieldStore) Find(id string) (FieldDef, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	i := f.index(id)
+	if i < 0 {
+		return FieldDef{}, fmt.Errorf("No custom field found with id %s", id)
+	}
+	return f.fields[i], nil
+}
+
+func (f *FieldStore) Delete(id string) (FieldDef, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	i := f.index(id)
+	if i < 0 {
+		return FieldDef{}, fmt.Errorf("No custom field found with id %s", id)
+	}
+	before := f.fields
+	deleted := f.fields[i]
+	f.fields = append(f.fields[:i:i], f.fields[i+1:]...)
+	if err := f.save(); err != nil {
+		f.fields = before
+		return FieldDef{}, err
+	}
+	return deleted, nil
+}
+
+// index finds a field by ID, the caller holding the lock
+func (f *FieldStore) index(id string) int {
+	for i, def := range f.fields {
+		if def.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// CustomValue is a custom field with a contact's value for it
+type CustomValue struct {
+	Field FieldDef
+	Value string
+}
+
+// CustomValues lists the contact's values for the defined fields, in the
+// order the fields are shown, leaving out those without a value
+func (c Contact) CustomValues() []CustomValue {
+	var out []CustomValue
+	for _, def := range customFields.List() {
+		if value := c.Custom[def.ID]; value != "" {
+			out = append(out, CustomValue{Field: def, Value: value})
+		}
+	}
+	return out
+}
+
+func formatCustom(c Contact) string {
+	var parts []string
+	for _, v := range c.CustomValues() {
+		parts = append(parts, v.Field.Name+": "+v.Value)
+	}
+	return strings.Join(parts, "; ")
+}
+
+func encodeCustom(custom map[string]string) string {
+	if len(custom) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(custom)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func decodeCustom(text string) (map[string]string, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+	var custom map[string]string
+	if err := json.Unmarshal([]byte(text), &custom); err != nil {
+		return nil, fmt.Errorf("invalid custom fields: %w", err)
+	}
+	return normalizeCustom(custom), nil
+}
+
+// normalizeCustom drops blank values, nil when none are left
+func normalizeCustom(custom map[string]string) map[string]string {
+	var out map[string]string
+	for id, value := range custom {
+		if value == "" {
+			continue
+		}
+		if out == nil {
+			out = map[string]string{}
+		}
+		out[id] = value
+	}
+	return out
+}
+
+// containsCustom reports whether any custom value holds the lower case
+// keyword
+func containsCustom(c Contact, keyword string) bool {
+	for _, v := range c.CustomValues() {
+		if strings.Contains(strings.ToLower(v.Value), keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// customFromForm reads and checks the custom fields of the add and edit
+// modals, sent as Field-<id>. an encoded CustomFields field, as sent by the
+// merge modal, is read instead when present
+func customFromForm(r *http.Request) (map[string]string, error) {
+	submitted := map[string]string{}
+	if text, ok := r.Form["CustomFields"]; ok {
+		decoded, err := decodeCustom(strings.Join(text, ""))
+		if err != nil {
+			return nil, err
+		}
+		submitted = decoded
+	} else {
+		for _, def := range customFields.List() {
+			submitted[def.ID] = r.FormValue("Field-" + def.ID)
+		}
+	}
+	custom := map[string]string{}
+	for _, def := range customFields.List() {
+		value, err := def.Check(submitted[def.ID])
+		if err != nil {
+			return nil, err
+		}
+		custom[def.ID] = value
+	}
+	return normalizeCustom(custom), nil
+}
+
+var customFieldsHTML = `{{define "custom-fields"}}
+{{$values := .}}
+{{range customFields}}
+{{$value := index $values .ID}}
+<div class="mb-4">
+    <label class="block text-gray-700 text-sm font-bold mb-2" for="field-{{.ID}}">{{.Name}}{{if .Required}} <span class="text-red-600">*</span>{{end}}</label>
+    {{if eq .Type "select"}}
+    <select id="field-{{.ID}}" name="Field-{{.ID}}" {{if .Required}}required{{end}} class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline">
+        <option value=""></option>
+        {{range .Options}}<option value="{{.}}" {{if eq . $value}}selected{{end}}>{{.}}</option>{{end}}
+    </select>
+    {{else if eq .Type "checkbox"}}
+    <input id="field-{{.ID}}" name="Field-{{.ID}}" type="checkbox" value="yes" {{if $value}}checked{{end}} {{if .Required}}required{{end}} class="h-4 w-4">
+    {{else}}
+    <input id="field-{{.ID}}" name="Field-{{.ID}}" value="{{$value}}" {{if .Required}}required{{end}}
+        {{if eq .Type "number"}}type="number" step="any"{{with .Min}} min="{{.}}"{{end}}{{with .Max}} max="{{.}}"{{end}}
+        {{else if eq .Type "date"}}type="date"
+        {{else if eq .Type "url"}}type="url" placeholder="https://"
+        {{else}}type="text"{{with .Pattern}} pattern="{{.}}"{{end}}{{end}}
+        class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline">
+    {{end}}
+</div>
+{{end}}
+{{end}}`
+
+var fieldSettingsHTML = `{{define "field-settings"}}
+<h4 class="text-lg font-bold mt-6 mb-1">Custom fields</h4>
+<p class="text-sm text-gray-500 mb-2">Shown on every contact. Options are comma separated; the pattern applies to text and the bounds to numbers.</p>
+{{range .Fields}}
+<div class="flex items-start gap-2">
+    <form class="flex-1" hx-put="/settings/fields/{{.ID}}" hx-target="#modal-container" hx-swap="innerHTML">
+        {{template "field-form" .}}
+    </form>
+    <button type="button" class="mt-2 px-3 py-1 text-sm rounded-lg border border-gray-300 hover:border-red-500 hover:bg-red-50 transition-colors"
+        hx-delete="/settings/fields/{{.ID}}"
+        hx-target="#modal-container"
+        hx-swap="innerHTML"
+        hx-confirm="Delete this field and its value on every contact?">
+        Delete
+    </button>
+</div>
+{{end}}
+<form hx-post="/settings/fields" hx-target="#modal-container" hx-swap="innerHTML">
+    {{template "field-form" .NewField}}
+</form>
+{{end}}
+{{define "field-form"}}
+<div class="p-2 mb-2 border {{if not .ID}}border-dashed{{end}} rounded-lg">
+    <div class="flex items-center gap-2">
+        <input name="Name" value="{{.Name}}" placeholder="{{if .ID}}Name{{else}}New field{{end}}" required class="flex-1 min-w-0 shadow appearance-none border rounded py-1 px-2 text-gray-700 text-sm focus:outline-none focus:shadow-outline">
+        <select name="Type" class="shadow border rounded py-1 px-2 text-gray-700 text-sm focus:outline-none focus:shadow-outline">
+            {{range fieldTypes}}<option value="{{.}}" {{if eq . $.Type}}selected{{end}}>{{.}}</option>{{end}}
+        </select>
+        <label class="text-sm text-gray-700"><input type="checkbox" name="Required" value="yes" {{if .Required}}checked{{end}}> required</label>
+        <button type="submit" class="px-3 py-1 text-sm rounded-lg {{if .ID}}border border-gray-300 hover:border-blue-500 hover:bg-blue-50{{else}}bg-blue-600 text-white hover:bg-blue-700{{end}} transition-colors">{{if .ID}}Save{{else}}Add{{end}}</button>
+    </div>
+    <div class="flex items-center gap-2 mt-2">
+        <input name="Options" value="{{join .Options ", "}}" placeholder="Options" class="flex-1 min-w-0 shadow appearance-none border rounded py-1 px-2 text-gray-700 text-sm focus:outline-none focus:shadow-outline">
+        <input name="Pattern" value="{{.Pattern}}" placeholder="Pattern" class="w-32 shadow appearance-none border rounded py-1 px-2 text-gray-700 text-sm focus:outline-none focus:shadow-outline">
+        <input name="Min" value="{{with .Min}}{{.}}{{end}}" placeholder="Min" type="number" step="any" class="w-20 shadow appearance-none border rounded py-1 px-2 text-gray-700 text-sm focus:outline-none focus:shadow-outline">
+        <input name="Max" value="{{with .Max}}{{.}}{{end}}" placeholder="Max" type="number" step="any" class="w-20 shadow appearance-none border rounded py-1 px-2 text-gray-700 text-sm focus:outline-none focus:shadow-outline">
+    </div>
+</div>
+{{end}}`
+
+var fieldFuncs = template.FuncMap{
+	"customFields": func() []FieldDef { return customFields.List() },
+	"fieldTypes":   func() []string { return fieldTypes },
+	"join":         strings.Join,
+}
+
+// parseBound reads an optional number bound of the field settings form
+func parseBound(text, name string) (*float64, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, nil
+	}
+	n, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a number", name)
+	}
+	return &n, nil
+}
+
+// saveField adds a custom field, or updates the one in the URL
+func saveField(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	def := FieldDef{
+		ID:       mux.Vars(r)["id"],
+		Name:     r.FormValue("Name"),
+		Type:     r.FormValue("Type"),
+		Required: r.FormValue("Required") != "",
+		Options:  strings.Split(r.FormValue("Options"), ","),
+		Pattern:  strings.TrimSpace(r.FormValue("Pattern")),
+	}
+	var err error
+	if def.Min, err = parseBound(r.FormValue("Min"), "Minimum"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if def.Max, err = parseBound(r.FormValue("Max"), "Maximum"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if def, err = customFields.Put(def); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fmt.Printf("Custom field saved: %s (%s)\n", def.Name, def.ID)
+	renderSettings(w, true)
+}
+
+// deleteField removes a custom field along with its value on every contact.
+// the values are cleared first, so a failure leaves the field in place to
+// delete again rather than values pointing at nothing
+func deleteField(w http.ResponseWriter, r *http.Request) {
+	def, err := customFields.Find(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	n, err := store.Unlink(currentUser(r), "fields", nil, func(c *Contact) bool {
+		if _, ok := c.Custom[def.ID]; !ok {
+			return false
+		}
+		custom := map[string]string{}
+		for id, value := range c.Custom {
+			if id != def.ID {
+				custom[id] = value
+			}
+		}
+		c.Custom = normalizeCustom(custom)
+		return true
+	}, func() error {
+		_, err := customFields.Delete(def.ID)
+		return err
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Printf("Custom field %s deleted, cleared on %d contacts\n", def.Name, n)
+	renderSettings(w, true)
+}
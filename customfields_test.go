@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// useTestFields points the handlers at custom fields kept in memory
+func useTestFields(t *testing.T, defs ...FieldDef) []FieldDef {
+	t.Helper()
+	old := customFields
+	customFields = NewFieldStore("")
+	t.Cleanup(func() { customFields = old })
+	var saved []FieldDef
+	for _, def := range defs {
+		def, err := customFields.Put(def)
+		if err != nil {
+			t.Fatal(err)
+		}
+		saved = append(saved, def)
+	}
+	return saved
+}
+
+func TestFieldCheck(t *testing.T) {
+	one, ten := 1.0, 10.0
+	tests := []struct {
+		def   FieldDef
+		value string
+		want  string
+		err   string
+	}{
+		{def: FieldDef{Name: "Ref", Type: "text", Required: true}, value: " ", err: "required"},
+		{def: FieldDef{Name: "Ref", Type: "text"}, value: " "},
+		{def: FieldDef{Name: "Ref", Type: "text", Pattern: `[A-Z]{2}\d+`}, value: "AB12", want: "AB12"},
+		{def: FieldDef{Name: "Ref", Type: "text", Pattern: `[A-Z]{2}\d+`}, value: "xAB12", err: "expected format"},
+		{def: FieldDef{Name: "Seats", Type: "number", Min: &one, Max: &ten}, value: "4.50", want: "4.5"},
+		{def: FieldDef{Name: "Seats", Type: "number", Min: &one, Max: &ten}, value: "11", err: "at most 10"},
+		{def: FieldDef{Name: "Seats", Type: "number"}, value: "four", err: "a number"},
+		{def: FieldDef{Name: "Since", Type: "date"}, value: "2024-02-30", err: "a date"},
+		{def: FieldDef{Name: "Since", Type: "date"}, value: "2024-02-29", want: "2024-02-29"},
+		{def: FieldDef{Name: "Site", Type: "url"}, value: "example.com/a", want: "https://example.com/a"},
+		{def: FieldDef{Name: "Site", Type: "url"}, value: "ftp://example.com", err: "web address"},
+		{def: FieldDef{Name: "Tier", Type: "select", Options: []string{"Gold", "Silver"}}, value: "Gold", want: "Gold"},
+		{def: FieldDef{Name: "Tier", Type: "select", Options: []string{"Gold", "Silver"}}, value: "Bronze", err: "one of Gold, Silver"},
+		{def: FieldDef{Name: "VIP", Type: "checkbox"}, value: "on", want: "yes"},
+		{def: FieldDef{Name: "VIP", Type: "checkbox", Required: true}, value: "", err: "required"},
+	}
+	for _, tt := range tests {
+		got, err := tt.def.Check(tt.value)
+		switch {
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s %s %q: error %v, want %q", tt.def.Type, tt.def.Name, tt.value, err, tt.err)
+		case tt.err == "" && (err != nil || got != tt.want):
+			t.Errorf("%s %s %q = %q, %v, want %q", tt.def.Type, tt.def.Name, tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestFieldStorePut(t *testing.T) {
+	useTestFields(t)
+	lo, hi := 5.0, 1.0
+	for _, def := range []FieldDef{
+		{Name: "", Type: "text"},
+		{Name: "Colour", Type: "colour"},
+		{Name: "Tier", Type: "select"},
+		{Name: "Seats", Type: "number", Min: &lo, Max: &hi},
+		{Name: "Ref", Type: "text", Pattern: "("},
+	} {
+		if _, err := customFields.Put(def); err == nil {
+			t.Errorf("%+v was saved", def)
+		}
+	}
+	def, err := customFields.Put(FieldDef{Name: "Seats", Type: "number", Pattern: "x", Options: []string{"a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if def.Pattern != "" || def.Options != nil {
+		t.Errorf("rules of other types were kept: %+v", def)
+	}
+	if _, err := customFields.Put(FieldDef{Name: "seats", Type: "text"}); err == nil {
+		t.Error("a second field named seats was saved")
+	}
+}
+
+func TestExportCustomFields(t *testing.T) {
+	defs := useTestFields(t,
+		FieldDef{Name: "Customer number", Type: "text"},
+		FieldDef{Name: "Seats", Type: "number"},
+	)
+	contacts := Contacts{
+		{ID: "ann", FirstName: "Ann", Custom: map[string]string{defs[0].ID: "C-1; 2", defs[1].ID: "3"}},
+		{ID: "bob", FirstName: "Bob", Custom: map[string]string{"gone": "left over"}},
+	}
+
+	var vcards bytes.Buffer
+	if err := writeVCards(&vcards, contacts); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`X-CUSTOMER-NUMBER:C-1\; 2`, "X-SEATS:3"} {
+		if !strings.Contains(vcards.String(), want+"\r\n") {
+			t.Errorf("vCard has no %q line:\n%s", want, vcards.String())
+		}
+	}
+	if strings.Contains(vcards.String(), "left over") {
+		t.Error("value of an undefined field was exported")
+	}
+
+	var out bytes.Buffer
+	if err := writeCSV(&out, contacts); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := rows[0]
+	if got := header[len(header)-2:]; !slices.Equal(got, []string{"Customer number", "Seats"}) {
+		t.Fatalf("last columns %q", got)
+	}
+	if got := rows[1][len(header)-2:]; !slices.Equal(got, []string{"C-1; 2", "3"}) {
+		t.Errorf("ann's custom columns %q", got)
+	}
+	if got := rows[2][len(header)-2:]; !slices.Equal(got, []string{"", ""}) {
+		t.Errorf("bob's custom columns %q", got)
+	}
+}
+
+func TestDeleteFieldClearsContacts(t *testing.T) {
+	defs := useTestFields(t, FieldDef{Name: "Seats", Type: "number"}, FieldDef{Name: "Tier", Type: "text"})
+	s := useTestStore(t,
+		Contact{ID: "ann", Version: 1, FirstName: "Ann", Custom: map[string]string{defs[0].ID: "3", defs[1].ID: "Gold"}},
+		Contact{ID: "bob", Version: 1, FirstName: "Bob", Custom: map[string]string{defs[0].ID: "1"}},
+	)
+
+	req := mux.SetURLVars(httptest.NewRequest(http.MethodDelete, "/settings/fields/"+defs[0].ID, nil), map[string]string{"id": defs[0].ID})
+	rec := httptest.NewRecorder()
+	deleteField(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+
+	if got := customFields.List(); len(got) != 1 || got[0].ID != defs[1].ID {
+		t.Errorf("fields left %+v", got)
+	}
+	ann, _ := s.Find("ann")
+	bob, _ := s.Find("bob")
+	if len(ann.Custom) != 1 || ann.Custom[defs[1].ID] != "Gold" || bob.Custom != nil {
+		t.Errorf("custom values left: ann %v, bob %v", ann.Custom, bob.Custom)
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// how far ahead the upcoming panel looks
+const upcomingDays = 30
+
+// PartialDate is a calendar date whose year may be unknown, such as a
+// birthday given without the year. it is saved as 1990-05-17, or as
+// --05-17 without a year, the form vCard uses
+type PartialDate struct {
+	Year  int // 0 when unknown
+	Month time.Month
+	Day   int
+}
+
+// parseDate reads YYYY-MM-DD, --MM-DD or MM-DD
+func parseDate(text string) (PartialDate, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return PartialDate{}, nil
+	}
+	var d PartialDate
+	parts := strings.Split(strings.TrimPrefix(text, "--"), "-")
+	numbers := make([]int, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return PartialDate{}, fmt.Errorf("invalid date %q, use YYYY-MM-DD or MM-DD", text)
+		}
+		numbers[i] = n
+	}
+	switch len(numbers) {
+	case 3:
+		d = PartialDate{Year: numbers[0], Month: time.Month(numbers[1]), Day: numbers[2]}
+	case 2:
+		d = PartialDate{Month: time.Month(numbers[0]), Day: numbers[1]}
+	default:
+		return PartialDate{}, fmt.Errorf("invalid date %q, use YYYY-MM-DD or MM-DD", text)
+	}
+	if !d.valid() {
+		return PartialDate{}, fmt.Errorf("invalid date %q", text)
+	}
+	return d, nil
+}
+
+// valid checks the day exists in the month, allowing 29 February when the
+// year is unknown
+func (d PartialDate) valid() bool {
+	if d.Month < time.January || d.Month > time.December || d.Day < 1 {
+		return false
+	}
+	year := d.Year
+	if year == 0 {
+		year = 2000 //a leap year
+	}
+	return d.Day <= daysIn(year, d.Month)
+}
+
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+func (d PartialDate) IsZero() bool {
+	return d == PartialDate{}
+}
+
+func (d PartialDate) HasYear() bool {
+	return d.Year != 0
+}
+
+// ISO writes the date the way it is saved
+func (d PartialDate) ISO() string {
+	switch {
+	case d.IsZero():
+		return ""
+	case d.HasYear():
+		return fmt.Sprintf("%04d-%02d-%02d", d.Year, d.Month, d.Day)
+	default:
+		return fmt.Sprintf("--%02d-%02d", d.Month, d.Day)
+	}
+}
+
+// String shows the date as "17 May 1990", or "17 May" without a year
+func (d PartialDate) String() string {
+	switch {
+	case d.IsZero():
+		return ""
+	case d.HasYear():
+		return fmt.Sprintf("%d %s %d", d.Day, d.Month.String()[:3], d.Year)
+	default:
+		return fmt.Sprintf("%d %s", d.Day, d.Month.String()[:3])
+	}
+}
+
+func (d PartialDate) MarshalText() ([]byte, error) {
+	return []byte(d.ISO()), nil
+}
+
+func (d *PartialDate) UnmarshalText(text []byte) error {
+	parsed, err := parseDate(string(text))
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// next returns the first day on or after from that the date falls on. a
+// 29 February date falls on 28 February in other years
+func (d PartialDate) next(from time.Time) time.Time {
+	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, from.Location())
+	for year := from.Year(); ; year++ {
+		day := min(d.Day, daysIn(year, d.Month))
+		on := time.Date(year, d.Month, day, 0, 0, 0, 0, from.Location())
+		if !on.Before(from) {
+			return on
+		}
+	}
+}
+
+// SignificantDate is a labeled date such as an anniversary
+type SignificantDate struct {
+	Label string
+	Date  PartialDate
+}
+
+func (s SignificantDate) String() string {
+	return s.Label + ": " + s.Date.ISO()
+}
+
+func formatDates(dates []SignificantDate) string {
+	parts := make([]string, len(dates))
+	for i, d := range dates {
+		parts[i] = d.String()
+	}
+	return strings.Join(parts, "; ")
+}
+
+// encodeDates writes dates in the form the merge modal submits them
+func encodeDates(dates []SignificantDate) string {
+	if len(dates) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(dates)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func decodeDates(text string) ([]SignificantDate, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+	var dates []SignificantDate
+	if err := json.Unmarshal([]byte(text), &dates); err != nil {
+		return nil, fmt.Errorf("invalid dates: %w", err)
+	}
+	return normalizeDates(dates), nil
+}
+
+// normalizeDates trims labels, drops blank dates and labels the unlabeled
+// ones "anniversary"
+func normalizeDates(dates []SignificantDate) []SignificantDate {
+	var out []SignificantDate
+	for _, d := range dates {
+		if d.Date.IsZero() {
+			continue
+		}
+		d.Label = strings.TrimSpace(d.Label)
+		if d.Label == "" {
+			d.Label = "anniversary"
+		}
+		out = append(out, d)
+	}
+	return out
+}
+
+// datesFromForm reads the birthday field and the date rows of the add and
+// edit modals. an encoded Dates field, as sent by the merge modal, is read
+// in place of the rows
+func datesFromForm(r *http.Request) (PartialDate, []SignificantDate, error) {
+	birthday, err := parseDate(r.FormValue("Birthday"))
+	if err != nil {
+		return PartialDate{}, nil, fmt.Errorf("Birthday: %w", err)
+	}
+	if text, ok := r.Form["Dates"]; ok {
+		dates, err := decodeDates(strings.Join(text, ""))
+		return birthday, dates, err
+	}
+	labels := r.Form["DateLabel"]
+	var dates []SignificantDate
+	for i, value := range r.Form["DateValue"] {
+		date, err := parseDate(value)
+		if err != nil {
+			return PartialDate{}, nil, err
+		}
+		d := SignificantDate{Date: date}
+		if i < len(labels) {
+			d.Label = labels[i]
+		}
+		dates = append(dates, d)
+	}
+	return birthday, normalizeDates(dates), nil
+}
+
+// Upcoming is a birthday or other date falling in the next few days
+type Upcoming struct {
+	Contact Contact
+	Label   string // birthday or the date's own label
+	On      time.Time
+	Years   int // age or years since, 0 when the year is unknown
+	InDays  int
+}
+
+// upcoming lists the dates of contacts outside the trash falling within
+// days of from, soonest first
+func upcoming(contacts Contacts, from time.Time, days int) []Upcoming {
+	today := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, from.Location())
+	end := today.AddDate(0, 0, days)
+	var out []Upcoming
+	add := func(c Contact, label string, d PartialDate) {
+		if d.IsZero() {
+			return
+		}
+		on := d.next(today)
+		if on.After(end) {
+			return
+		}
+		u := Upcoming{Contact: c, Label: label, On: on, InDays: int(on.Sub(today).Hours()+12) / 24}
+		if d.HasYear() && on.Year() > d.Year {
+			u.Years = on.Year() - d.Year
+		}
+		out = append(out, u)
+	}
+	for _, c := range contacts.active() {
+		add(c, "birthday", c.Birthday)
+		for _, d := range c.Dates {
+			add(c, d.Label, d.Date)
+		}
+	}
+	sort.SliceStable(out, func(i, k int) bool {
+		return out[i].On.Before(out[k].On)
+	})
+	return out
+}
+
+var dateRowHTML = `{{define "date-row"}}
+<div class="date-row flex items-center space-x-2 mb-2">
+    <input name="DateLabel" value="{{.Label}}" placeholder="Label" list="date-labels" class="shadow appearance-none border rounded w-1/3 py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline">
+    <input name="DateValue" value="{{.Date.ISO}}" placeholder="YYYY-MM-DD or MM-DD" pattern="(\d{4}-|--)?\d{2}-\d{2}" class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline">
+    <button type="button" hx-get="/modal/close" hx-target="closest .date-row" hx-swap="outerHTML" class="text-gray-400 hover:text-red-600" title="Remove">&times;</button>
+</div>
+{{end}}
+{{define "date-rows"}}
+<datalist id="date-labels">
+    <option value="anniversary"></option>
+    <option value="work anniversary"></option>
+    <option value="name day"></option>
+</datalist>
+<div id="date-rows">
+    {{range .}}{{template "date-row" .}}{{end}}
+</div>
+<button type="button" class="text-sm text-blue-600 hover:underline"
+    hx-get="/modal/row/date"
+    hx-target="#date-rows"
+    hx-swap="beforeend">
+    + Add date
+</button>
+{{end}}`
+
+// dateRowView returns an empty date row for the add and edit modals
+func dateRowView(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html")
+	if err := valueRowTemplate.ExecuteTemplate(w, "date-row", SignificantDate{Label: "anniversary"}); err != nil {
+		fmt.Printf("Error rendering date row: %v\n", err)
+	}
+}
+
+var upcomingHTML = `
+<div id="upcoming" class="mb-6">
+    {{if .}}
+    <div class="bg-white rounded-xl shadow-md p-4">
+        <h3 class="text-lg font-bold text-gray-800 mb-2">Upcoming in the next 30 days</h3>
+        <ul class="text-sm divide-y">
+            {{range .}}
+            <li class="flex justify-between py-1">
+                <span>
+                    <span class="font-medium text-gray-800">{{.Contact.FirstName}} {{.Contact.LastName}}</span>
+                    <span class="text-gray-500">
+                        {{if eq .Label "birthday"}}{{if .Years}}turns {{.Years}}{{else}}birthday{{end}}{{else}}{{.Label}}{{if .Years}}, {{.Years}} years{{end}}{{end}}
+                    </span>
+                </span>
+                <span class="text-gray-500">{{if eq .InDays 0}}today{{else if eq .InDays 1}}tomorrow{{else}}{{.On.Format "Mon 2 Jan"}}{{end}}</span>
+            </li>
+            {{end}}
+        </ul>
+    </div>
+    {{end}}
+</div>
+`
+
+var upcomingPanel = template.Must(template.New("upcoming").Parse(upcomingHTML))
+
+// upcomingView renders the upcoming dates panel of the home page
+func upcomingView(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html")
+	if err := upcomingPanel.Execute(w, upcoming(store.List(), time.Now(), upcomingDays)); err != nil {
+		fmt.Printf("Error rendering upcoming dates: %v\n", err)
+	}
+}
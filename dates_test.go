@@ -0,0 +1,146 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func day(text string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02", text, time.Local)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestPartialDateNext(t *testing.T) {
+	leapDay := PartialDate{Month: time.February, Day: 29}
+	tests := []struct {
+		name string
+		date PartialDate
+		from string
+		want string
+	}{
+		{name: "later this year", date: PartialDate{Month: time.May, Day: 17}, from: "2025-01-10", want: "2025-05-17"},
+		{name: "today", date: PartialDate{Month: time.May, Day: 17}, from: "2025-05-17", want: "2025-05-17"},
+		{name: "passed, next year", date: PartialDate{Year: 1990, Month: time.May, Day: 17}, from: "2025-05-18", want: "2026-05-17"},
+		{name: "year wrap at the end of December", date: PartialDate{Month: time.January, Day: 3}, from: "2025-12-30", want: "2026-01-03"},
+		{name: "31 December from 31 December", date: PartialDate{Month: time.December, Day: 31}, from: "2025-12-31", want: "2025-12-31"},
+		{name: "29 February in a leap year", date: leapDay, from: "2024-01-10", want: "2024-02-29"},
+		{name: "29 February falls on 28 February", date: leapDay, from: "2025-01-10", want: "2025-02-28"},
+		{name: "29 February on 28 February", date: leapDay, from: "2025-02-28", want: "2025-02-28"},
+		{name: "29 February passed, next year is not leap", date: leapDay, from: "2025-03-01", want: "2026-02-28"},
+		{name: "29 February passed, next year is leap", date: leapDay, from: "2027-03-01", want: "2028-02-29"},
+	}
+	for _, tt := range tests {
+		//the time of day does not matter
+		from := day(tt.from).Add(15 * time.Hour)
+		if got := tt.date.next(from); !got.Equal(day(tt.want)) {
+			t.Errorf("%s: next is %s, want %s", tt.name, got.Format("2006-01-02"), tt.want)
+		}
+	}
+}
+
+func TestUpcoming(t *testing.T) {
+	date := func(text string) PartialDate {
+		d, err := parseDate(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	contacts := Contacts{
+		{ID: "alice", Birthday: date("1990-01-05")},
+		{ID: "bob", Birthday: date("--12-25")},
+		{ID: "carol", Birthday: date("2000-02-29")},
+		{ID: "dan", Dates: []SignificantDate{{Label: "anniversary", Date: date("2015-12-20")}}},
+		{ID: "eve", Birthday: date("1990-03-01")},
+		{ID: "baby", Birthday: date("2025-12-22")},
+		{ID: "gone", Birthday: date("--12-21"), DeletedAt: day("2025-12-01")},
+	}
+	type want struct {
+		id, label, on string
+		years, inDays int
+	}
+	tests := []struct {
+		name string
+		from string
+		days int
+		want []want
+	}{
+		{
+			name: "across the new year",
+			from: "2025-12-20",
+			days: 30,
+			want: []want{
+				{id: "dan", label: "anniversary", on: "2025-12-20", years: 10},
+				{id: "baby", label: "birthday", on: "2025-12-22", inDays: 2},
+				{id: "bob", label: "birthday", on: "2025-12-25", inDays: 5},
+				{id: "alice", label: "birthday", on: "2026-01-05", years: 36, inDays: 16},
+			},
+		},
+		{
+			name: "leap day birthday in a common year",
+			from: "2027-02-15",
+			days: 14,
+			want: []want{
+				{id: "carol", label: "birthday", on: "2027-02-28", years: 27, inDays: 13},
+				{id: "eve", label: "birthday", on: "2027-03-01", years: 37, inDays: 14},
+			},
+		},
+		{
+			name: "leap day birthday in a leap year",
+			from: "2028-02-15",
+			days: 14,
+			want: []want{
+				{id: "carol", label: "birthday", on: "2028-02-29", years: 28, inDays: 14},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := upcoming(contacts, day(tt.from).Add(9*time.Hour), tt.days)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d upcoming dates, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i, w := range tt.want {
+				g := got[i]
+				if g.Contact.ID != w.id || g.Label != w.label || !g.On.Equal(day(w.on)) || g.Years != w.years || g.InDays != w.inDays {
+					t.Errorf("upcoming %d is %s %s on %s, %d years, in %d days, want %+v",
+						i, g.Contact.ID, g.Label, g.On.Format("2006-01-02"), g.Years, g.InDays, w)
+				}
+			}
+		})
+	}
+}
+
+func TestDatesRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		dates []SignificantDate
+		want  []SignificantDate
+	}{
+		{name: "none"},
+		{
+			name:  "semicolon in label",
+			dates: []SignificantDate{{Label: "met; married", Date: PartialDate{Year: 2010, Month: time.June, Day: 5}}},
+			want:  []SignificantDate{{Label: "met; married", Date: PartialDate{Year: 2010, Month: time.June, Day: 5}}},
+		},
+		{
+			name:  "no year, unlabeled",
+			dates: []SignificantDate{{Date: PartialDate{Month: time.February, Day: 29}}, {Label: "blank"}},
+			want:  []SignificantDate{{Label: "anniversary", Date: PartialDate{Month: time.February, Day: 29}}},
+		},
+	}
+	for _, tt := range tests {
+		got, err := decodeDates(encodeDates(tt.dates))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: round trip gave %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
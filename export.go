@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// vcardEscape escapes a vCard text value, RFC 6350 section 3.4
+func vcardEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ",", `\,`, ";", `\;`, "\n", `\n`).Replace(s)
+}
+
+// vcardType maps a label onto a vCard TYPE parameter
+func vcardType(label string) string {
+	switch label {
+	case "mobile":
+		return "CELL"
+	case "other":
+		return "OTHER"
+	default:
+		return strings.ToUpper(label)
+	}
+}
+
+// vcardTypes adds PREF to the TYPE parameter of a primary value
+func vcardTypes(label string, primary bool) string {
+	if primary {
+		return vcardType(label) + ",PREF"
+	}
+	return vcardType(label)
+}
+
+// adrValue lays an address out as the seven parts of a vCard ADR: post
+// office box, extended address, street lines, city, region, postal code
+// and country
+func adrValue(a Address) string {
+	street := make([]string, len(a.Street))
+	for i, line := range a.Street {
+		street[i] = vcardEscape(line)
+	}
+	return strings.Join([]string{
+		"",
+		"",
+		strings.Join(street, ","),
+		vcardEscape(a.City),
+		vcardEscape(a.Region),
+		vcardEscape(a.PostalCode),
+		vcardEscape(a.CountryName()),
+	}, ";")
+}
+
+// vcardExtension names the vCard property of a custom field, such as
+// X-CUSTOMER-NUMBER for "Customer number"
+func vcardExtension(name string) string {
+	var b strings.Builder
+	b.WriteString("X-")
+	dash := true
+	for _, r := range strings.ToUpper(name) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash {
+			b.WriteRune('-')
+			dash = true
+		}
+	}
+	if b.Len() == 2 {
+		return "X-FIELD"
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+// writeVCards writes contacts as vCard 3.0
+func writeVCards(w io.Writer, contacts Contacts) error {
+	for _, c := range contacts {
+		lines := []string{
+			"BEGIN:VCARD",
+			"VERSION:3.0",
+			"UID:" + vcardEscape(c.ID),
+			"N:" + vcardEscape(c.LastName) + ";" + vcardEscape(c.FirstName) + ";;;",
+			"FN:" + vcardEscape(strings.TrimSpace(c.FirstName+" "+c.LastName)),
+		}
+		if org := c.Organization; org.ID != "" || org.Department != "" {
+			lines = append(lines, "ORG:"+vcardEscape(orgName(org.ID))+";"+vcardEscape(org.Department))
+		}
+		if c.Organization.JobTitle != "" {
+			lines = append(lines, "TITLE:"+vcardEscape(c.Organization.JobTitle))
+		}
+		for _, v := range c.Emails {
+			lines = append(lines, "EMAIL;TYPE=INTERNET,"+vcardTypes(v.Label, v.Primary)+":"+vcardEscape(v.Value))
+		}
+		for _, v := range c.Phones {
+			lines = append(lines, "TEL;TYPE="+vcardTypes(v.Label, v.Primary)+":"+vcardEscape(v.Value))
+		}
+		for _, a := range c.Addresses {
+			lines = append(lines, "ADR;TYPE="+vcardType(a.Label)+":"+adrValue(a))
+		}
+		if !c.Birthday.IsZero() {
+			lines = append(lines, "BDAY:"+c.Birthday.ISO())
+		}
+		for _, d := range c.Dates {
+			if d.Label == "anniversary" {
+				lines = append(lines, "X-ANNIVERSARY:"+d.Date.ISO())
+				continue
+			}
+			lines = append(lines, "X-EVENT;TYPE="+vcardEscape(d.Label)+":"+d.Date.ISO())
+		}
+		for _, h := range c.Handles {
+			if h.Kind == "website" {
+				lines = append(lines, "URL:"+vcardEscape(h.Value))
+				continue
+			}
+			lines = append(lines, "X-SOCIALPROFILE;TYPE="+h.Kind+":"+vcardEscape(handleProfile(h)))
+		}
+		if c.Notes != "" {
+			lines = append(lines, "NOTE:"+vcardEscape(c.Notes))
+		}
+		for _, v := range c.CustomValues() {
+			lines = append(lines, vcardExtension(v.Field.Name)+":"+vcardEscape(v.Value))
+		}
+		if categories := contactGroups(c); len(categories) > 0 {
+			for i, category := range categories {
+				categories[i] = vcardEscape(category)
+			}
+			lines = append(lines, "CATEGORIES:"+strings.Join(categories, ","))
+		}
+		if !c.UpdatedAt.IsZero() {
+			lines = append(lines, "REV:"+c.UpdatedAt.UTC().Format("20060102T150405Z"))
+		}
+		lines = append(lines, "END:VCARD")
+		if _, err := io.WriteString(w, strings.Join(lines, "\r\n")+"\r\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleProfile is the link of a handle, or the handle itself when it has
+// none
+func handleProfile(h Handle) string {
+	if link := h.Link(); link != "" {
+		return link
+	}
+	return h.Value
+}
+
+// writeCSV writes contacts with the columns Google Contacts imports, one
+// numbered group of columns per email, phone, address, date and handle,
+// followed by a column per custom field
+func writeCSV(w io.Writer, contacts Contacts) error {
+	var emails, phones, addresses, dates, handles int
+	for _, c := range contacts {
+		emails = max(emails, len(c.Emails))
+		phones = max(phones, len(c.Phones))
+		addresses = max(addresses, len(c.Addresses))
+		dates = max(dates, len(c.Dates))
+		handles = max(handles, len(c.Handles))
+	}
+
+	header := []string{"ID", "Given Name", "Family Name", "Group Membership", "Organization Name", "Organization Title", "Organization Department", "Birthday", "Notes"}
+	for i := 1; i <= emails; i++ {
+		n := "E-mail " + strconv.Itoa(i)
+		header = append(header, n+" - Type", n+" - Value")
+	}
+	for i := 1; i <= phones; i++ {
+		n := "Phone " + strconv.Itoa(i)
+		header = append(header, n+" - Type", n+" - Value")
+	}
+	for i := 1; i <= addresses; i++ {
+		n := "Address " + strconv.Itoa(i)
+		header = append(header, n+" - Type", n+" - Street", n+" - City", n+" - Region", n+" - Postal Code", n+" - Country")
+	}
+	for i := 1; i <= dates; i++ {
+		n := "Event " + strconv.Itoa(i)
+		header = append(header, n+" - Type", n+" - Value")
+	}
+	for i := 1; i <= handles; i++ {
+		n := "Website " + strconv.Itoa(i)
+		header = append(header, n+" - Type", n+" - Value")
+	}
+
+	fields := customFields.List()
+	for _, def := range fields {
+		header = append(header, def.Name)
+	}
+
+	out := csv.NewWriter(w)
+	if err := out.Write(header); err != nil {
+		return err
+	}
+	for _, c := range contacts {
+		row := []string{c.ID, c.FirstName, c.LastName, strings.Join(contactGroups(c), " ::: "), orgName(c.Organization.ID), c.Organization.JobTitle, c.Organization.Department, c.Birthday.ISO(), c.Notes}
+		for i := 0; i < emails; i++ {
+			row = append(row, csvValue(c.Emails, i)...)
+		}
+		for i := 0; i < phones; i++ {
+			row = append(row, csvValue(c.Phones, i)...)
+		}
+		for i := 0; i < addresses; i++ {
+			if i >= len(c.Addresses) {
+				row = append(row, "", "", "", "", "", "")
+				continue
+			}
+			a := c.Addresses[i]
+			row = append(row, a.Label, strings.Join(a.Street, "\n"), a.City, a.Region, a.PostalCode, a.CountryName())
+		}
+		for i := 0; i < dates; i++ {
+			if i >= len(c.Dates) {
+				row = append(row, "", "")
+				continue
+			}
+			row = append(row, c.Dates[i].Label, c.Dates[i].Date.ISO())
+		}
+		for i := 0; i < handles; i++ {
+			if i >= len(c.Handles) {
+				row = append(row, "", "")
+				continue
+			}
+			row = append(row, c.Handles[i].Label(), handleProfile(c.Handles[i]))
+		}
+		for _, def := range fields {
+			row = append(row, c.Custom[def.ID])
+		}
+		if err := out.Write(row); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// contactGroups lists the contact type followed by the tags and groups,
+// exported as vCard categories and Google Contacts groups
+func contactGroups(c Contact) []string {
+	var names []string
+	if c.ContactType != "" {
+		names = append(names, c.ContactType)
+	}
+	names = append(names, c.Tags...)
+	return append(names, groupNames(c.Groups)...)
+}
+
+// csvValue returns the type and value columns of the i-th value, with the
+// primary one marked the way Google Contacts does
+func csvValue(values []ContactValue, i int) []string {
+	if i >= len(values) {
+		return []string{"", ""}
+	}
+	v := values[i]
+	label := v.Label
+	if v.Primary && len(values) > 1 {
+		label = "* " + label
+	}
+	return []string{label, v.Value}
+}
+
+// exportContacts downloads every contact outside the trash as vCard or CSV
+func exportContacts(w http.ResponseWriter, r *http.Request) {
+	sendExport(w, r, store.List(), "afcb")
+}
+
+// sendExport downloads contacts as vCard or CSV by the extension of the
+// URL, in a file named after prefix and today's date
+func sendExport(w http.ResponseWriter, r *http.Request, contacts Contacts, prefix string) {
+	name := prefix + "-" + time.Now().Format("20060102")
+
+	var err error
+	if strings.HasSuffix(r.URL.Path, ".csv") {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.csv"`)
+		err = writeCSV(w, contacts)
+	} else {
+		w.Header().Set("Content-Type", "text/vcard; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.vcf"`)
+		err = writeVCards(w, contacts)
+	}
+	if err != nil {
+		fmt.Printf("Error exporting contacts: %v\n", err)
+		return
+	}
+	fmt.Printf("Exported %d contacts as %s\n", len(contacts), r.URL.Path)
+}
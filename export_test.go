@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// exportContact has more than one of everything and characters vCard escapes
+var exportContact = Contact{
+	ID:        "alice",
+	FirstName: "Alice",
+	LastName:  "Smith, Jr",
+	Emails: []ContactValue{
+		{Label: "work", Value: "alice@example.com", Primary: true},
+		{Label: "home", Value: "al@example.com"},
+	},
+	Phones: []ContactValue{{Label: "mobile", Value: "+1 555 1234", Primary: true}},
+	Addresses: []Address{
+		{Label: "work", Street: []string{"Unit 3, Level 2", "1 Main St"}, City: "Springfield", Region: "IL", PostalCode: "62701", Country: "US"},
+		{Label: "home", Street: []string{"12 Jalan Ampang"}, City: "Kuala Lumpur; KL", PostalCode: "50450", Country: "MY"},
+	},
+}
+
+func TestWriteVCards(t *testing.T) {
+	var out bytes.Buffer
+	if err := writeVCards(&out, Contacts{exportContact}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(out.String(), "\r\n")
+	for _, want := range []string{
+		"BEGIN:VCARD",
+		`N:Smith\, Jr;Alice;;;`,
+		"EMAIL;TYPE=INTERNET,WORK,PREF:alice@example.com",
+		"EMAIL;TYPE=INTERNET,HOME:al@example.com",
+		"TEL;TYPE=CELL,PREF:+1 555 1234",
+		`ADR;TYPE=WORK:;;Unit 3\, Level 2,1 Main St;Springfield;IL;62701;United States`,
+		`ADR;TYPE=HOME:;;12 Jalan Ampang;Kuala Lumpur\; KL;;50450;Malaysia`,
+		"END:VCARD",
+	} {
+		if !slices.Contains(lines, want) {
+			t.Errorf("vCard is missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	var out bytes.Buffer
+	bob := Contact{ID: "bob", FirstName: "Bob", Phones: []ContactValue{{Label: "work", Value: "555", Primary: true}}}
+	if err := writeCSV(&out, Contacts{exportContact, bob}); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("wrote %d rows, want a header and 2 contacts", len(rows))
+	}
+	column := func(row []string, name string) string {
+		i := slices.Index(rows[0], name)
+		if i < 0 {
+			t.Fatalf("no %q column in %q", name, rows[0])
+		}
+		return row[i]
+	}
+	alice := rows[1]
+	for name, want := range map[string]string{
+		"E-mail 1 - Type":         "* work",
+		"E-mail 2 - Value":        "al@example.com",
+		"Phone 1 - Type":          "mobile",
+		"Address 1 - Street":      "Unit 3, Level 2\n1 Main St",
+		"Address 1 - Country":     "United States",
+		"Address 2 - City":        "Kuala Lumpur; KL",
+		"Address 2 - Postal Code": "50450",
+	} {
+		if got := column(alice, name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	//a contact with fewer values leaves the extra columns blank
+	if got := column(rows[2], "Address 1 - Street"); got != "" {
+		t.Errorf("bob's address street = %q, want blank", got)
+	}
+	if len(rows[2]) != len(rows[0]) {
+		t.Errorf("bob's row has %d columns, the header %d", len(rows[2]), len(rows[0]))
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// quick actions on a card that count as reaching a contact
+var usageActions = []string{"email", "whatsapp", "signal"}
+
+// a use counts half as much after this long, so the frequently contacted
+// view follows who is reached lately rather than who was years ago
+const usageHalfLife = 14 * 24 * time.Hour
+
+// Usage is how much a contact's quick actions have been used
+type Usage struct {
+	Score  float64        // decayed up to At
+	At     time.Time      // last use
+	Counts map[string]int `json:",omitempty"` // uses by action, never decayed
+}
+
+// decayed returns the score as it stands at now
+func (u Usage) decayed(now time.Time) float64 {
+	return u.Score * math.Pow(0.5, float64(now.Sub(u.At))/float64(usageHalfLife))
+}
+
+// UsageStore keeps usage by contact ID in a file beside the contact data,
+// apart from the contacts so a click does not make a new revision. an empty
+// filename keeps it in memory only
+type UsageStore struct {
+	mu       sync.RWMutex
+	filename string
+	usage    map[string]Usage
+}
+
+// usage shared by the handlers
+var usage = NewUsageStore("")
+
+func NewUsageStore(filename string) *UsageStore {
+	return &UsageStore{filename: filename, usage: map[string]Usage{}}
+}
+
+func (u *UsageStore) Load() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.filename == "" {
+		return nil
+	}
+	loaded := map[string]Usage{}
+	if err := loadJSONFile(u.filename, &loaded); err != nil {
+		return err
+	}
+	u.usage = loaded
+	return nil
+}
+
+// save writes the usage file, the caller holding the lock
+func (u *UsageStore) save() error {
+	if u.filename == "" {
+		return nil
+	}
+	return saveJSONFile(u.filename, u.usage)
+}
+
+// Save writes the usage file again, used when the data key changes
+func (u *UsageStore) Save() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.save()
+}
+
+// Record counts one use of a quick action on a contact
+func (u *UsageStore) Record(id, action string, now time.Time) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	before := u.usage[id]
+	counts := map[string]int{}
+	for name, n := range before.Counts {
+		counts[name] = n
+	}
+	counts[action]++
+	u.usage[id] = Usage{Score: before.decayed(now) + 1, At: now, Counts: counts}
+	if err := u.save(); err != nil {
+		u.usage[id] = before
+		return err
+	}
+	return nil
+}
+
+// Forget drops the usage of a contact that has been purged
+func (u *UsageStore) Forget(id string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	before, ok := u.usage[id]
+	if !ok {
+		return nil
+	}
+	delete(u.usage, id)
+	if err := u.save(); err != nil {
+		u.usage[id] = before
+		return err
+	}
+	return nil
+}
+
+// Frequent returns up to limit of contacts that have been used, highest
+// score first
+func (u *UsageStore) Frequent(contacts Contacts, limit int, now time.Time) Contacts {
+	u.mu.RLock()
+	scores := map[string]float64{}
+	for _, contact := range contacts {
+		if use, ok := u.usage[contact.ID]; ok {
+			scores[contact.ID] = use.decayed(now)
+		}
+	}
+	u.mu.RUnlock()
+
+	var out Contacts
+	for _, contact := range contacts {
+		if _, ok := scores[contact.ID]; ok {
+			out = append(out, contact)
+		}
+	}
+	sort.SliceStable(out, func(i, k int) bool {
+		return scores[out[i].ID] > scores[out[k].ID]
+	})
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+// pinFavorites moves favorites to the front, keeping the order otherwise
+func pinFavorites(contacts Contacts) Contacts {
+	out := contacts.clone()
+	sort.SliceStable(out, func(i, k int) bool {
+		return out[i].Favorite && !out[k].Favorite
+	})
+	return out
+}
+
+func formatFavorite(favorite bool) string {
+	if favorite {
+		return "yes"
+	}
+	return ""
+}
+
+// toggleFavorite stars or unstars a contact
+func toggleFavorite(w http.ResponseWriter, r *http.Request) {
+	contact, err := store.Change(mux.Vars(r)["id"], currentUser(r), "favorite", func(c *Contact) error {
+		c.Favorite = !c.Favorite
+		return nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	fmt.Printf("Contact %s favorite: %t\n", contact.ID, contact.Favorite)
+	renderCard(w, contact)
+}
+
+// recordUse counts a quick action used on a card, sent by the browser as a
+// beacon alongside the action itself
+func recordUse(w http.ResponseWriter, r *http.Request) {
+	id, action := mux.Vars(r)["id"], r.FormValue("action")
+	if !containsString(usageActions, action) {
+		http.Error(w, "Invalid action: "+action, http.StatusBadRequest)
+		return
+	}
+	if _, err := store.Find(id); err != nil {
+		http.Error(w, "Contact not found", http.StatusNotFound)
+		return
+	}
+	if err := usage.Record(id, action, time.Now()); err != nil {
+		fmt.Printf("Error recording %s use of %s: %v\n", action, id, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestUsageDecay(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name string
+		uses []time.Duration // how long before now each use was, oldest first
+		want float64
+	}{
+		{name: "never", want: 0},
+		{name: "just now", uses: []time.Duration{0}, want: 1},
+		{name: "one half-life ago", uses: []time.Duration{usageHalfLife}, want: 0.5},
+		{name: "two half-lives ago", uses: []time.Duration{2 * usageHalfLife}, want: 0.25},
+		{name: "old and new", uses: []time.Duration{2 * usageHalfLife, usageHalfLife, 0}, want: 1.75},
+	}
+	for _, tt := range tests {
+		u := NewUsageStore("")
+		for _, ago := range tt.uses {
+			if err := u.Record("ann", "email", now.Add(-ago)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := u.usage["ann"].decayed(now); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: score %v, want %v", tt.name, got, tt.want)
+		}
+		if got := u.usage["ann"].Counts["email"]; got != len(tt.uses) {
+			t.Errorf("%s: counted %d emails, want %d", tt.name, got, len(tt.uses))
+		}
+	}
+}
+
+func TestFrequent(t *testing.T) {
+	now := time.Now()
+	u := NewUsageStore(filepath.Join(t.TempDir(), "contacts.usage.json"))
+	//three uses six weeks ago are worth less than one yesterday
+	for i := 0; i < 3; i++ {
+		if err := u.Record("ann", "whatsapp", now.Add(-6*7*24*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := u.Record("bob", "email", now.Add(-24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Record("gone", "email", now); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := NewUsageStore(u.filename)
+	if err := reloaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	contacts := Contacts{{ID: "ann"}, {ID: "bob"}, {ID: "cat"}}
+	for limit, want := range map[int]string{10: "bob ann", 1: "bob"} {
+		var ids []string
+		for _, contact := range reloaded.Frequent(contacts, limit, now) {
+			ids = append(ids, contact.ID)
+		}
+		if got := strings.Join(ids, " "); got != want {
+			t.Errorf("frequent with limit %d: %s, want %s", limit, got, want)
+		}
+	}
+}
+
+func TestRecordUse(t *testing.T) {
+	old := usage
+	usage = NewUsageStore("")
+	t.Cleanup(func() { usage = old })
+	useTestStore(t, Contact{ID: "ann", Version: 1, FirstName: "Ann"})
+
+	tests := []struct {
+		id, action string
+		want       int
+	}{
+		{id: "ann", action: "email", want: http.StatusNoContent},
+		{id: "ann", action: "delete", want: http.StatusBadRequest},
+		{id: "ann", action: "telegram", want: http.StatusBadRequest},
+		{id: "zed", action: "email", want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		form := url.Values{"action": {tt.action}}
+		req := httptest.NewRequest(http.MethodPost, "/contacts/"+tt.id+"/used", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		recordUse(rec, mux.SetURLVars(req, map[string]string{"id": tt.id}))
+		if rec.Code != tt.want {
+			t.Errorf("%s on %s: status %d, want %d", tt.action, tt.id, rec.Code, tt.want)
+		}
+	}
+	if got := usage.usage["ann"].Counts; len(got) != 1 || got["email"] != 1 {
+		t.Errorf("counted %v, want one email", got)
+	}
+}
+
+func TestPinFavorites(t *testing.T) {
+	contacts := Contacts{{ID: "a"}, {ID: "b", Favorite: true}, {ID: "c"}, {ID: "d", Favorite: true}}
+	var ids []string
+	for _, contact := range pinFavorites(contacts) {
+		ids = append(ids, contact.ID)
+	}
+	if got := strings.Join(ids, ""); got != "bdac" {
+		t.Errorf("order %s, want bdac", got)
+	}
+	if contacts[0].ID != "a" {
+		t.Error("pinFavorites reordered the contacts passed in")
+	}
+}
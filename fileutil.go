@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// permissions for files holding contact data, readable by the owner only
+const dataFilePerm = 0600
+
+// writeFileAtomic writes data to a temp file next to filename, syncs it to
+// disk and renames it into place, so a crash leaves either the old file or
+// the new one but never a truncated mix of both
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(filename)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(filename)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpName := tmp.Name()
+
+	//remove temp file if anything below fails
+	ok := false
+	defer func() {
+		if !ok {
+			tmp.Close()
+			os.Remove(tmpName)
+		}
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("failed to sync temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+	if err := os.Chmod(tmpName, perm); err != nil {
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+	if err := os.Rename(tmpName, filename); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", filename, err)
+	}
+	ok = true
+
+	//sync directory so the rename itself survives a crash
+	return syncDir(dir)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory %s: %w", dir, err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory %s: %w", dir, err)
+	}
+	return nil
+}
+
+// loadJSONFile decodes filename into v, leaving v untouched if the file
+// does not exist yet
+func loadJSONFile(filename string, v any) error {
+	data, err := readDataFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read file %s: %w", filename, err)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("Failed to unmarshal %s: %w", filename, err)
+	}
+	return nil
+}
+
+// saveJSONFile encodes v and writes it to filename atomically, encrypted
+// when a data key is set
+func saveJSONFile(filename string, v any) error {
+	data, err := json.MarshalIndent(v, "", " ")
+	if err != nil {
+		return fmt.Errorf("Failed to marshal %s: %w", filename, err)
+	}
+	if err := writeDataFile(filename, data); err != nil {
+		return fmt.Errorf("Failed to write file %s: %w", filename, err)
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "data.json")
+	for _, content := range []string{"first", "second, longer than the first", "3"} {
+		if err := writeFileAtomic(filename, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != content {
+			t.Errorf("file holds %q, want %q", got, content)
+		}
+	}
+	leftovers, _ := filepath.Glob(filepath.Join(filepath.Dir(filename), "*"))
+	if len(leftovers) != 1 {
+		t.Errorf("directory holds %v, want only the data file", leftovers)
+	}
+}
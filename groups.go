@@ -0,0 +1,558 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Group is a named list of contacts, such as "All suppliers", picked by
+// hand rather than by type or tag
+type Group struct {
+	ID          string
+	Name        string
+	Description string `json:",omitempty"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// GroupStore keeps groups in a file beside the contact data. membership is
+// kept on the contacts. an empty filename keeps them in memory only
+type GroupStore struct {
+	mu       sync.RWMutex
+	filename string
+	groups   []Group
+}
+
+// groups shared by the handlers
+var groups = NewGroupStore("")
+
+func NewGroupStore(filename string) *GroupStore {
+	return &GroupStore{filename: filename}
+}
+
+func (g *GroupStore) Load() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.filename == "" {
+		return nil
+	}
+	var loaded []Group
+	if err := loadJSONFile(g.filename, &loaded); err != nil {
+		return err
+	}
+	g.groups = loaded
+	return nil
+}
+
+// save writes the groups file, the caller holding the lock
+func (g *GroupStore) save() error {
+	if g.filename == "" {
+		return nil
+	}
+	return saveJSONFile(g.filename, g.groups)
+}
+
+// Save writes the groups file again, used when the data key changes
+func (g *GroupStore) Save() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.save()
+}
+
+// List returns the groups sorted by name
+func (g *GroupStore) List() []Group {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	list := append([]Group(nil), g.groups...)
+	sort.Slice(list, func(i, k int) bool {
+		return strings.ToLower(list[i].Name) < strings.ToLower(list[k].Name)
+	})
+	return list
+}
+
+func (g *GroupStore) Find(id string) (Group, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	for _, group := range g.groups {
+		if group.ID == id {
+			return group, nil
+		}
+	}
+	return Group{}, fmt.Errorf("No group found with id %s", id)
+}
+
+// Put adds a group without an ID or replaces the one with its ID
+func (g *GroupStore) Put(group Group) (Group, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	group.Name = strings.Join(strings.Fields(group.Name), " ")
+	group.Description = strings.TrimSpace(group.Description)
+	if group.Name == "" {
+		return Group{}, errors.New("Group name is required")
+	}
+	for _, other := range g.groups {
+		if other.ID != group.ID && strings.EqualFold(other.Name, group.Name) {
+			return Group{}, fmt.Errorf("There is already a group named %s", other.Name)
+		}
+	}
+	group.UpdatedAt = time.Now()
+
+	before := append([]Group(nil), g.groups...)
+	if group.ID == "" {
+		id, err := genID()
+		if err != nil {
+			return Group{}, err
+		}
+		group.ID, group.CreatedAt = id, group.UpdatedAt
+		g.groups = append(g.groups, group)
+	} else {
+		found := false
+		for i := range g.groups {
+			if g.groups[i].ID == group.ID {
+				group.CreatedAt = g.groups[i].CreatedAt
+				g.groups[i] = group
+				found = true
+			}
+		}
+		if !found {
+			return Group{}, fmt.Errorf("No group found with id %s", group.ID)
+		}
+	}
+	if err := g.save(); err != nil {
+		g.groups = before
+		return Group{}, err
+	}
+	return group, nil
+}
+
+func (g *GroupStore) Delete(id string) (Group, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for i, group := range g.groups {
+		if group.ID == id {
+			before := append([]Group(nil), g.groups...)
+			g.groups = append(g.groups[:i:i], g.groups[i+1:]...)
+			if err := g.save(); err != nil {
+				g.groups = before
+				return Group{}, err
+			}
+			return group, nil
+		}
+	}
+	return Group{}, fmt.Errorf("No group found with id %s", id)
+}
+
+// normalizeGroups drops blank and repeated group IDs
+func normalizeGroups(ids []string) []string {
+	var out []string
+	for _, id := range ids {
+		id = strings.TrimSpace(id)
+		if id != "" && !containsString(out, id) {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// groupName returns the name of a group for display, its ID when it no
+// longer exists
+func groupName(id string) string {
+	if group, err := groups.Find(id); err == nil {
+		return group.Name
+	}
+	return id
+}
+
+func groupNames(ids []string) []string {
+	names := make([]string, len(ids))
+	for i, id := range ids {
+		names[i] = groupName(id)
+	}
+	return names
+}
+
+func formatGroups(ids []string) string {
+	return strings.Join(groupNames(ids), ", ")
+}
+
+// InGroup reports whether the contact is a member of a group
+func (c Contact) InGroup(id string) bool {
+	return containsString(c.Groups, id)
+}
+
+// inGroup returns the contacts outside the trash in a group
+func (c Contacts) inGroup(id string) Contacts {
+	var out Contacts
+	for _, contact := range c.active() {
+		if contact.InGroup(id) {
+			out = append(out, contact)
+		}
+	}
+	return out
+}
+
+// withGroup returns a change adding a contact to a group, or taking it out
+func withGroup(id string, member bool) func(c *Contact) error {
+	return func(c *Contact) error {
+		if c.InGroup(id) == member {
+			return nil
+		}
+		var ids []string
+		for _, existing := range c.Groups {
+			if existing != id {
+				ids = append(ids, existing)
+			}
+		}
+		if member {
+			ids = append(ids, id)
+		}
+		c.Groups = ids
+		return nil
+	}
+}
+
+// groupsFromForm reads the group checkboxes of the add and edit modals. a
+// comma separated Groups field of IDs, as sent by the merge modal, is read
+// in place of the checkboxes
+func groupsFromForm(r *http.Request) ([]string, error) {
+	ids := r.Form["Group"]
+	if text, ok := r.Form["Groups"]; ok {
+		ids = strings.Split(strings.Join(text, ","), ",")
+	}
+	ids = normalizeGroups(ids)
+	for _, id := range ids {
+		if _, err := groups.Find(id); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+var groupChecksHTML = `{{define "group-checks"}}
+{{$ids := .}}
+{{with allGroups}}
+<div class="flex flex-wrap gap-2">
+    {{range .}}
+    <label class="inline-flex items-center px-3 py-1 rounded-full border text-sm cursor-pointer hover:bg-blue-50 has-[:checked]:bg-blue-600 has-[:checked]:text-white">
+        <input type="checkbox" name="Group" value="{{.ID}}" class="hidden" {{if inGroups $ids .ID}}checked{{end}}>{{.Name}}
+    </label>
+    {{end}}
+</div>
+{{else}}
+<p class="text-sm text-gray-500">No groups yet. Create them from Groups above the contact list.</p>
+{{end}}
+{{end}}`
+
+var groupFuncs = template.FuncMap{
+	"allGroups": func() []Group { return groups.List() },
+	"inGroups":  func(ids []string, id string) bool { return containsString(ids, id) },
+}
+
+var groupsModalHTML = `
+<div id="contact-modal" class="fixed inset-0 bg-gray-600 bg-opacity-50 overflow-y-auto h-full w-full">
+    <div class="relative top-20 mx-auto p-5 border w-full max-w-2xl shadow-lg rounded-md bg-white">
+        <div class="flex justify-end">
+            <button hx-target="#contact-modal" hx-swap="outerHTML" hx-get="/modal/close" class="text-gray-400 hover:text-gray-600">&times;</button>
+        </div>
+        <h3 class="text-xl font-bold mb-4">Groups</h3>
+        {{if not .Groups}}
+        <div class="p-4 mb-4 bg-gray-100 text-gray-500 rounded-lg">No groups yet.</div>
+        {{end}}
+        {{range .Groups}}
+        <button class="w-full flex justify-between items-center p-3 mb-2 border rounded-lg hover:border-blue-500 hover:bg-blue-50 transition-colors text-left"
+            hx-get="/groups/{{.ID}}"
+            hx-target="#modal-container"
+            hx-swap="innerHTML">
+            <span>
+                <strong class="text-gray-800">{{.Name}}</strong>
+                {{if .Description}}<span class="ml-2 text-sm text-gray-500">{{.Description}}</span>{{end}}
+            </span>
+            <span class="text-sm text-gray-500">{{countContacts (index $.Counts .ID)}}</span>
+        </button>
+        {{end}}
+        <h4 class="text-lg font-bold mt-6 mb-2">New group</h4>
+        {{template "group-form" .New}}
+    </div>
+</div>
+`
+
+var groupModalHTML = `
+<div id="contact-modal" class="fixed inset-0 bg-gray-600 bg-opacity-50 overflow-y-auto h-full w-full">
+    <div class="relative top-20 mx-auto p-5 border w-full max-w-2xl shadow-lg rounded-md bg-white">
+        <div class="flex justify-between">
+            <button hx-get="/groups" hx-target="#modal-container" hx-swap="innerHTML" class="text-sm text-blue-600 hover:underline">&larr; All groups</button>
+            <button hx-target="#contact-modal" hx-swap="outerHTML" hx-get="/modal/close" class="text-gray-400 hover:text-gray-600">&times;</button>
+        </div>
+        {{with .Group}}
+        <h3 class="text-xl font-bold mt-2">{{.Name}}</h3>
+        {{if .Description}}<p class="text-sm text-gray-600 whitespace-pre-line">{{.Description}}</p>{{end}}
+        {{end}}
+        <div class="flex flex-wrap gap-2 my-4 text-sm">
+            {{if .Emails}}
+            <a href="{{.Mailto}}" class="px-3 py-1 rounded-lg border border-gray-300 hover:border-blue-500 hover:bg-blue-50 transition-colors">Email everyone ({{len .Emails}})</a>
+            {{end}}
+            {{if .Phones}}
+            <pre id="group-phones" class="hidden">{{range .Phones}}{{.}}
+{{end}}</pre>
+            <button type="button" onclick="copyLines('group-phones')" class="px-3 py-1 rounded-lg border border-gray-300 hover:border-blue-500 hover:bg-blue-50 transition-colors">Copy phone numbers ({{len .Phones}})</button>
+            {{end}}
+            {{if .Members}}
+            <a href="/groups/{{.Group.ID}}/export.vcf" class="px-3 py-1 rounded-lg border border-gray-300 hover:border-blue-500 hover:bg-blue-50 transition-colors">Export vCard</a>
+            <a href="/groups/{{.Group.ID}}/export.csv" class="px-3 py-1 rounded-lg border border-gray-300 hover:border-blue-500 hover:bg-blue-50 transition-colors">Export CSV</a>
+            {{end}}
+        </div>
+        <h4 class="text-lg font-bold mb-2">Members</h4>
+        {{if not .Members}}
+        <div class="p-4 mb-4 bg-gray-100 text-gray-500 rounded-lg">Nobody is in this group yet.</div>
+        {{end}}
+        <table class="w-full text-sm mb-4">
+            {{range .Members}}
+            <tr class="border-t">
+                <td class="py-1 pr-2 font-medium text-gray-800">{{.FirstName}} {{.LastName}}</td>
+                <td class="py-1 pr-2 text-gray-600">{{.PrimaryEmail}}</td>
+                <td class="py-1 pr-2 text-gray-600">{{.PrimaryPhone}}</td>
+                <td class="py-1 text-right">
+                    <button class="text-gray-400 hover:text-red-600"
+                        hx-delete="/groups/{{$.Group.ID}}/members/{{.ID}}"
+                        hx-target="#modal-container"
+                        hx-swap="innerHTML"
+                        title="Remove from group">&times;</button>
+                </td>
+            </tr>
+            {{end}}
+        </table>
+        {{with .Others}}
+        <form class="flex items-center gap-2 mb-4"
+              hx-post="/groups/{{$.Group.ID}}/members"
+              hx-target="#modal-container"
+              hx-swap="innerHTML">
+            <select name="Contact" required class="flex-1 shadow border rounded py-2 px-2 text-gray-700 text-sm focus:outline-none focus:shadow-outline">
+                <option value=""></option>
+                {{range .}}<option value="{{.ID}}">{{.FirstName}} {{.LastName}}</option>{{end}}
+            </select>
+            <button type="submit" class="bg-blue-600 text-white font-bold py-2 px-4 rounded-lg shadow-md hover:bg-blue-700 transition-colors duration-300">Add to group</button>
+        </form>
+        {{end}}
+        <details class="mb-2">
+            <summary class="cursor-pointer text-sm text-blue-600">Edit group</summary>
+            <div class="mt-2">{{template "group-form" .Group}}</div>
+        </details>
+        <button class="px-3 py-1 text-sm rounded-lg border border-gray-300 hover:border-red-500 hover:bg-red-50 transition-colors"
+            hx-delete="/groups/{{.Group.ID}}"
+            hx-target="#modal-container"
+            hx-swap="innerHTML"
+            hx-confirm="Delete this group? Its members are kept, only the group goes.">
+            Delete group
+        </button>
+    </div>
+</div>
+`
+
+var groupFormHTML = `{{define "group-form"}}
+<form {{if .ID}}hx-put="/groups/{{.ID}}"{{else}}hx-post="/groups"{{end}}
+      hx-target="#modal-container"
+      hx-swap="innerHTML">
+    <input name="Name" value="{{.Name}}" placeholder="Name, e.g. Project Alpha team" required class="shadow appearance-none border rounded w-full py-2 px-3 mb-2 text-gray-700 leading-tight focus:outline-none focus:shadow-outline">
+    <textarea name="Description" rows="2" placeholder="Description" class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline">{{.Description}}</textarea>
+    <div class="flex justify-end mt-2">
+        <button type="submit" class="bg-blue-600 text-white font-bold py-2 px-4 rounded-lg shadow-md hover:bg-blue-700 transition-colors duration-300">{{if .ID}}Save Group{{else}}Add Group{{end}}</button>
+    </div>
+</form>
+{{end}}`
+
+var (
+	groupsModal = template.Must(template.New("groups-modal").Funcs(template.FuncMap{"countContacts": countContacts}).Parse(groupsModalHTML + groupFormHTML))
+	groupModal  = template.Must(template.New("group-modal").Parse(groupModalHTML + groupFormHTML))
+)
+
+// groupsView lists every group with a form to add one
+func groupsView(w http.ResponseWriter, r *http.Request) {
+	counts := map[string]int{}
+	for _, contact := range store.List() {
+		for _, id := range contact.Groups {
+			counts[id]++
+		}
+	}
+	w.Header().Set("Content-Type", "text/html")
+	err := groupsModal.Execute(w, map[string]any{
+		"Groups": groups.List(),
+		"Counts": counts,
+		"New":    Group{},
+	})
+	if err != nil {
+		fmt.Printf("Error rendering groups: %v\n", err)
+	}
+}
+
+// renderGroup shows the page of a group, with the primary email and phone
+// of every member for emailing and copying
+func renderGroup(w http.ResponseWriter, group Group) {
+	contacts := store.List()
+	members := contacts.inGroup(group.ID)
+	var others Contacts
+	for _, contact := range contacts {
+		if !contact.InGroup(group.ID) {
+			others = append(others, contact)
+		}
+	}
+	sortByName(members)
+	sortByName(others)
+
+	var emails, phones []string
+	for _, member := range members {
+		if email := member.PrimaryEmail(); email != "" {
+			emails = append(emails, email)
+		}
+		if phone := member.PrimaryPhone(); phone != "" {
+			phones = append(phones, phone)
+		}
+	}
+	//everyone goes in BCC so members do not see each other's addresses
+	mailto := "mailto:?" + url.Values{"bcc": {strings.Join(emails, ",")}}.Encode()
+
+	w.Header().Set("Content-Type", "text/html")
+	err := groupModal.Execute(w, map[string]any{
+		"Group":   group,
+		"Members": members,
+		"Others":  others,
+		"Emails":  emails,
+		"Phones":  phones,
+		"Mailto":  template.URL(mailto),
+	})
+	if err != nil {
+		fmt.Printf("Error rendering group %s: %v\n", group.ID, err)
+	}
+}
+
+// sortByName orders contacts by first then last name, ignoring case
+func sortByName(contacts Contacts) {
+	sort.SliceStable(contacts, func(i, k int) bool {
+		return strings.ToLower(contacts[i].FirstName+" "+contacts[i].LastName) < strings.ToLower(contacts[k].FirstName+" "+contacts[k].LastName)
+	})
+}
+
+func groupView(w http.ResponseWriter, r *http.Request) {
+	group, err := groups.Find(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	}
+	renderGroup(w, group)
+}
+
+// saveGroup adds a group, or updates the one in the URL
+func saveGroup(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	group, err := groups.Put(Group{
+		ID:          mux.Vars(r)["id"],
+		Name:        r.FormValue("Name"),
+		Description: r.FormValue("Description"),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fmt.Printf("Group saved: %s (%s)\n", group.Name, group.ID)
+	renderGroup(w, group)
+}
+
+// deleteGroup takes every contact out of a group, trash included, and then
+// removes the group, so a failure leaves the group in place to delete again
+// rather than contacts in a group that is gone
+func deleteGroup(w http.ResponseWriter, r *http.Request) {
+	group, err := groups.Find(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	leave := withGroup(group.ID, false)
+	n, err := store.Unlink(currentUser(r), "groups", nil, func(c *Contact) bool {
+		if !c.InGroup(group.ID) {
+			return false
+		}
+		leave(c)
+		return true
+	}, func() error {
+		_, err := groups.Delete(group.ID)
+		return err
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Printf("Group %s deleted, removed from %d contacts\n", group.Name, n)
+	groupsView(w, r)
+	renderContactListOOB(w)
+}
+
+// changeMember adds a contact to the group in the URL or takes it out,
+// refreshing its card
+func changeMember(w http.ResponseWriter, r *http.Request, contactID string, member bool) {
+	group, err := groups.Find(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	}
+	contact, err := store.Change(contactID, currentUser(r), "groups", withGroup(group.ID, member))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	fmt.Printf("Contact %s in group %s: %t\n", contact.ID, group.Name, member)
+	renderGroup(w, group)
+	renderCardOOB(w, contact)
+}
+
+func addMember(w http.ResponseWriter, r *http.Request) {
+	changeMember(w, r, r.FormValue("Contact"), true)
+}
+
+func removeMember(w http.ResponseWriter, r *http.Request) {
+	changeMember(w, r, mux.Vars(r)["contact"], false)
+}
+
+// exportGroup downloads the members of a group as vCard or CSV
+func exportGroup(w http.ResponseWriter, r *http.Request) {
+	group, err := groups.Find(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	}
+	sendExport(w, r, store.List().inGroup(group.ID), "afcb-"+fileSlug(group.Name))
+}
+
+// fileSlug turns a name into something safe for a file name, such as
+// "project-alpha-team"
+func fileSlug(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	if b.Len() == 0 {
+		return "group"
+	}
+	return b.String()
+}
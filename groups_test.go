@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// useTestGroups points the handlers at groups kept in memory
+func useTestGroups(t *testing.T, names ...string) []Group {
+	t.Helper()
+	old := groups
+	groups = NewGroupStore("")
+	t.Cleanup(func() { groups = old })
+	var saved []Group
+	for _, name := range names {
+		group, err := groups.Put(Group{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		saved = append(saved, group)
+	}
+	return saved
+}
+
+// groupRequest is a request for a group handler with the group in the URL
+func groupRequest(method, path string, group Group) *http.Request {
+	return mux.SetURLVars(httptest.NewRequest(method, path, nil), map[string]string{"id": group.ID})
+}
+
+// groupMembers is a group of two with email addresses, someone outside it
+// and a member in the trash
+func groupMembers(t *testing.T) Group {
+	t.Helper()
+	team := useTestGroups(t, "Project Alpha team", "Suppliers")
+	useTestStore(t,
+		Contact{ID: "ann", Version: 1, ContactType: "Work", FirstName: "Ann", LastName: "Lee", Groups: []string{team[0].ID},
+			Emails: []ContactValue{{Label: "home", Value: "ann@home.example"}, {Label: "work", Value: "ann@example.com", Primary: true}}},
+		Contact{ID: "bob", Version: 1, ContactType: "Work", FirstName: "Bob", LastName: "Ng", Groups: []string{team[1].ID, team[0].ID},
+			Emails: []ContactValue{{Label: "work", Value: "bob@example.com", Primary: true}}},
+		Contact{ID: "cat", Version: 1, FirstName: "Cat", Groups: []string{team[0].ID}},
+		Contact{ID: "dan", Version: 1, FirstName: "Dan", Groups: []string{team[1].ID},
+			Emails: []ContactValue{{Label: "work", Value: "dan@example.com", Primary: true}}},
+		Contact{ID: "eve", Version: 1, FirstName: "Eve", Groups: []string{team[0].ID}, DeletedAt: day("2025-01-01"),
+			Emails: []ContactValue{{Label: "work", Value: "eve@example.com", Primary: true}}},
+	)
+	return team[0]
+}
+
+func TestGroupMailto(t *testing.T) {
+	team := groupMembers(t)
+	rec := httptest.NewRecorder()
+	groupView(rec, groupRequest(http.MethodGet, "/groups/"+team.ID, team))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, `href="mailto:?bcc=ann%40example.com%2Cbob%40example.com"`) {
+		t.Errorf("page has no BCC link to the primary emails of the members:\n%s", body)
+	}
+	for _, other := range []string{"ann@home.example", "dan@example.com", "eve@example.com"} {
+		if strings.Contains(body, other) {
+			t.Errorf("page shows %s", other)
+		}
+	}
+}
+
+func TestExportGroup(t *testing.T) {
+	team := groupMembers(t)
+	rec := httptest.NewRecorder()
+	exportGroup(rec, groupRequest(http.MethodGet, "/groups/"+team.ID+"/export.csv", team))
+	if got := rec.Header().Get("Content-Disposition"); !strings.HasPrefix(got, `attachment; filename="afcb-project-alpha-team-`) {
+		t.Errorf("file named %s", got)
+	}
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, row := range rows[1:] {
+		ids = append(ids, row[0])
+	}
+	if got := strings.Join(ids, " "); got != "ann bob cat" {
+		t.Errorf("exported %s, want ann bob cat", got)
+	}
+	if got := rows[2][3]; got != "Work ::: Suppliers ::: Project Alpha team" {
+		t.Errorf("bob's group membership %q", got)
+	}
+
+	rec = httptest.NewRecorder()
+	exportGroup(rec, groupRequest(http.MethodGet, "/groups/"+team.ID+"/export.vcf", team))
+	if got := strings.Count(rec.Body.String(), "BEGIN:VCARD"); got != 3 {
+		t.Errorf("exported %d vCards, want 3", got)
+	}
+	if !strings.Contains(rec.Body.String(), "CATEGORIES:Work,Suppliers,Project Alpha team\r\n") {
+		t.Errorf("vCard has no categories for bob's groups:\n%s", rec.Body)
+	}
+}
+
+func TestDeleteGroup(t *testing.T) {
+	team := groupMembers(t)
+	rec := httptest.NewRecorder()
+	deleteGroup(rec, groupRequest(http.MethodDelete, "/groups/"+team.ID, team))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if _, err := groups.Find(team.ID); err == nil {
+		t.Error("group is still there")
+	}
+	for _, contact := range store.All() {
+		if contact.InGroup(team.ID) {
+			t.Errorf("%s is still in the deleted group", contact.ID)
+		}
+	}
+	if bob, _ := store.Find("bob"); len(bob.Groups) != 1 {
+		t.Errorf("bob is in %v, want only the other group", bob.Groups)
+	}
+}
+
+func TestFileSlug(t *testing.T) {
+	for name, want := range map[string]string{
+		"Project Alpha team": "project-alpha-team",
+		"  R&D / 2025 ":      "r-d-2025",
+		"Ümlauts":            "mlauts",
+		"***":                "group",
+	} {
+		if got := fileSlug(name); got != want {
+			t.Errorf("fileSlug(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,548 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Handle is a messaging or social account of a contact, such as a GitHub
+// username. Value is kept in the form its kind's parse returns
+type Handle struct {
+	Kind  string
+	Value string
+}
+
+// handleKind is a kind of handle: how to read what users type for it and
+// the link that opens it. link returns "" when there is nothing to open
+type handleKind struct {
+	Name  string
+	Label string
+	parse func(value string) (string, error)
+	link  func(value string) string
+}
+
+var (
+	telegramRegex = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]{4,31}$`)
+	signalRegex   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{2,31}\.[0-9]{2,9}$`)
+	linkedInRegex = regexp.MustCompile(`^[A-Za-z0-9_%-]{3,100}$`)
+	mastodonRegex = regexp.MustCompile(`^@([A-Za-z0-9_]+(?:\.[A-Za-z0-9_]+)*)@([a-z0-9-]+(?:\.[a-z0-9-]+)+)$`)
+	githubRegex   = regexp.MustCompile(`^[A-Za-z0-9](?:-?[A-Za-z0-9]){0,38}$`)
+	e164Regex     = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+	signalEURegex = regexp.MustCompile(`^[A-Za-z0-9_-]{20,200}$`)
+)
+
+// kinds of handle in the order they are offered
+var handleKinds = []handleKind{
+	{
+		Name: "telegram", Label: "Telegram",
+		parse: func(value string) (string, error) {
+			name := trimPrefixes(value, "https://t.me/", "http://t.me/", "t.me/", "@")
+			if !telegramRegex.MatchString(name) {
+				return "", errors.New("Telegram usernames are 5 to 32 letters, digits or underscores")
+			}
+			return name, nil
+		},
+		link: func(value string) string { return "https://t.me/" + value },
+	},
+	{
+		Name: "signal", Label: "Signal",
+		parse: func(value string) (string, error) {
+			//signal.me links carry a number (#p), a username (#u) or an
+			//encrypted username link shared from the app (#eu)
+			if link, ok := strings.CutPrefix(value, "https://signal.me/#"); ok {
+				kind, data, _ := strings.Cut(link, "/")
+				switch kind {
+				case "p":
+					value = data
+				case "u":
+					name, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(data, "="))
+					if err != nil {
+						return "", errors.New("Signal link is not a valid username link")
+					}
+					value = string(name)
+				case "eu":
+					if !signalEURegex.MatchString(data) {
+						return "", errors.New("Signal link is not a valid username link")
+					}
+					return "https://signal.me/#eu/" + data, nil
+				default:
+					return "", errors.New("Signal links start with https://signal.me/#p/ or https://signal.me/#eu/")
+				}
+			}
+			if strings.HasPrefix(value, "+") {
+				if number := e164(value); number != "" {
+					return number, nil
+				}
+				return "", errors.New("Signal numbers need the country code, such as +15551234567")
+			}
+			name := strings.TrimPrefix(value, "@")
+			if !signalRegex.MatchString(name) {
+				return "", errors.New("Signal usernames look like name.01, or give a number with its country code")
+			}
+			return name, nil
+		},
+		link: func(value string) string {
+			switch {
+			case strings.HasPrefix(value, "https://"):
+				return value
+			case strings.HasPrefix(value, "+"):
+				return "https://signal.me/#p/" + value
+			}
+			return "https://signal.me/#u/" + base64.RawURLEncoding.EncodeToString([]byte(value))
+		},
+	},
+	{
+		Name: "linkedin", Label: "LinkedIn",
+		parse: func(value string) (string, error) {
+			name := trimPrefixes(value, "https://", "http://", "www.", "linkedin.com/in/")
+			name = strings.TrimSuffix(name, "/")
+			if !linkedInRegex.MatchString(name) {
+				return "", errors.New("LinkedIn needs the name from linkedin.com/in/name")
+			}
+			return name, nil
+		},
+		link: func(value string) string { return "https://www.linkedin.com/in/" + value },
+	},
+	{
+		Name: "mastodon", Label: "Mastodon",
+		parse: func(value string) (string, error) {
+			//a profile URL, https://instance.social/@user, is turned around
+			if u, err := url.Parse(value); err == nil && u.Host != "" && strings.HasPrefix(u.Path, "/@") {
+				value = strings.TrimPrefix(u.Path, "/") + "@" + u.Host
+			}
+			if !strings.HasPrefix(value, "@") {
+				value = "@" + value
+			}
+			user, host, _ := strings.Cut(value[1:], "@")
+			value = "@" + user + "@" + strings.ToLower(host)
+			if !mastodonRegex.MatchString(value) {
+				return "", errors.New("Mastodon handles look like @user@instance.social")
+			}
+			return value, nil
+		},
+		link: func(value string) string {
+			m := mastodonRegex.FindStringSubmatch(value)
+			if m == nil {
+				return ""
+			}
+			return "https://" + m[2] + "/@" + m[1]
+		},
+	},
+	{
+		Name: "github", Label: "GitHub",
+		parse: func(value string) (string, error) {
+			name := trimPrefixes(value, "https://", "http://", "www.", "github.com/", "@")
+			name = strings.TrimSuffix(name, "/")
+			if !githubRegex.MatchString(name) {
+				return "", errors.New("GitHub usernames are up to 39 letters, digits or single hyphens")
+			}
+			return name, nil
+		},
+		link: func(value string) string { return "https://github.com/" + value },
+	},
+	{
+		Name: "website", Label: "Website",
+		parse: func(value string) (string, error) {
+			address, err := webAddress(value)
+			if err != nil {
+				return "", errors.New("Website must be a web address")
+			}
+			return address, nil
+		},
+		link: func(value string) string { return value },
+	},
+}
+
+func findHandleKind(name string) (handleKind, bool) {
+	for _, kind := range handleKinds {
+		if kind.Name == name {
+			return kind, true
+		}
+	}
+	return handleKind{}, false
+}
+
+// trimPrefixes strips each prefix in turn, ignoring case
+func trimPrefixes(value string, prefixes ...string) string {
+	for _, prefix := range prefixes {
+		if len(value) >= len(prefix) && strings.EqualFold(value[:len(prefix)], prefix) {
+			value = value[len(prefix):]
+		}
+	}
+	return value
+}
+
+// webAddress reads a web address, taking one without a scheme as https
+func webAddress(value string) (string, error) {
+	if !strings.Contains(value, "://") {
+		value = "https://" + value
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid web address %q", value)
+	}
+	return value, nil
+}
+
+// phoneDigits keeps only the digits of a phone number
+func phoneDigits(phone string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, phone)
+}
+
+// e164 returns a phone number written with its country code as + and
+// digits only, or "" for a local number that cannot be dialled from abroad
+func e164(phone string) string {
+	phone = strings.TrimSpace(phone)
+	if !strings.HasPrefix(phone, "+") {
+		return ""
+	}
+	if number := "+" + phoneDigits(phone); e164Regex.MatchString(number) {
+		return number
+	}
+	return ""
+}
+
+// parseHandle checks a handle of a kind, returning it in its saved form
+func parseHandle(kind, value string) (Handle, error) {
+	k, ok := findHandleKind(kind)
+	if !ok {
+		return Handle{}, fmt.Errorf("Invalid handle kind: %s", kind)
+	}
+	value, err := k.parse(strings.TrimSpace(value))
+	if err != nil {
+		return Handle{}, err
+	}
+	return Handle{Kind: kind, Value: value}, nil
+}
+
+// Label names the kind of the handle, such as GitHub
+func (h Handle) Label() string {
+	if k, ok := findHandleKind(h.Kind); ok {
+		return k.Label
+	}
+	return h.Kind
+}
+
+// Link is the web address or deep link that opens the handle, "" when it
+// has none
+func (h Handle) Link() string {
+	if k, ok := findHandleKind(h.Kind); ok {
+		return k.link(h.Value)
+	}
+	return ""
+}
+
+// String shows a handle as "github: alice", the form it takes in history
+func (h Handle) String() string {
+	return h.Kind + ": " + h.Value
+}
+
+func formatHandles(handles []Handle) string {
+	parts := make([]string, len(handles))
+	for i, h := range handles {
+		parts[i] = h.String()
+	}
+	return strings.Join(parts, "; ")
+}
+
+// encodeHandles writes handles in the form the merge modal submits them
+func encodeHandles(handles []Handle) string {
+	if len(handles) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(handles)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// decodeHandles reads handles written by encodeHandles, checking each one
+// as the handle rows are
+func decodeHandles(text string) ([]Handle, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+	var decoded []Handle
+	if err := json.Unmarshal([]byte(text), &decoded); err != nil {
+		return nil, fmt.Errorf("invalid handles: %w", err)
+	}
+	var handles []Handle
+	for _, h := range decoded {
+		h, err := parseHandle(h.Kind, h.Value)
+		if err != nil {
+			return nil, err
+		}
+		handles = append(handles, h)
+	}
+	return normalizeHandles(handles), nil
+}
+
+// normalizeHandles drops blank and repeated handles
+func normalizeHandles(handles []Handle) []Handle {
+	var out []Handle
+	for _, h := range handles {
+		h.Value = strings.TrimSpace(h.Value)
+		if h.Value == "" {
+			continue
+		}
+		repeated := false
+		for _, seen := range out {
+			repeated = repeated || seen == h
+		}
+		if !repeated {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+// containsHandle reports whether any handle holds the lower case keyword
+func containsHandle(handles []Handle, keyword string) bool {
+	for _, h := range handles {
+		if strings.Contains(strings.ToLower(h.Value), keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// handlesFromForm reads the handle rows of the add and edit modals. an
+// encoded Handles field, as sent by the merge modal, is read in their place
+func handlesFromForm(r *http.Request) ([]Handle, error) {
+	if text, ok := r.Form["Handles"]; ok {
+		return decodeHandles(strings.Join(text, ""))
+	}
+	kinds := r.Form["HandleKind"]
+	var handles []Handle
+	for i, value := range r.Form["HandleValue"] {
+		if strings.TrimSpace(value) == "" || i >= len(kinds) {
+			continue
+		}
+		h, err := parseHandle(kinds[i], value)
+		if err != nil {
+			return nil, err
+		}
+		handles = append(handles, h)
+	}
+	return normalizeHandles(handles), nil
+}
+
+var handleRowHTML = `{{define "handle-row"}}
+<div class="handle-row flex items-center space-x-2 mb-2">
+    <select name="HandleKind" class="shadow border rounded py-2 px-2 text-gray-700 text-sm focus:outline-none focus:shadow-outline">
+        {{range handleKinds}}<option value="{{.Name}}" {{if eq .Name $.Kind}}selected{{end}}>{{.Label}}</option>{{end}}
+    </select>
+    <input name="HandleValue" value="{{.Value}}" placeholder="Username or link" class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline">
+    <button type="button" hx-get="/modal/close" hx-target="closest .handle-row" hx-swap="outerHTML" class="text-gray-400 hover:text-red-600" title="Remove">&times;</button>
+</div>
+{{end}}
+{{define "handle-rows"}}
+<div id="handle-rows">
+    {{range .}}{{template "handle-row" .}}{{end}}
+</div>
+<button type="button" class="text-sm text-blue-600 hover:underline"
+    hx-get="/modal/row/handle"
+    hx-target="#handle-rows"
+    hx-swap="beforeend">
+    + Add handle
+</button>
+{{end}}`
+
+var handleFuncs = template.FuncMap{
+	"handleKinds": func() []handleKind { return handleKinds },
+}
+
+// handleRowView returns an empty handle row for the add and edit modals
+func handleRowView(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html")
+	if err := valueRowTemplate.ExecuteTemplate(w, "handle-row", Handle{Kind: handleKinds[0].Name}); err != nil {
+		fmt.Printf("Error rendering handle row: %v\n", err)
+	}
+}
+
+// QuickAction is a button on a card that opens a chat or profile
+type QuickAction struct {
+	Name    string // whatsapp or a handle kind
+	Label   string
+	Link    string
+	Counted bool // counts toward the frequently contacted view
+}
+
+// quick actions in the order they show on cards. whatsapp opens the mobile
+// number, signal the Signal handle or else the mobile number, and the rest
+// the handle of their kind. the mobile number is only used when it has its
+// country code, as the links need one
+var quickActionNames = []string{"whatsapp", "signal", "telegram", "linkedin", "mastodon", "github", "website"}
+
+// QuickActions lists the quick actions shown on the contact's card, those
+// turned on in settings that the contact has a link for
+func (c Contact) QuickActions() []QuickAction {
+	var out []QuickAction
+	mobile := e164(c.MobilePhone())
+	for _, name := range quickActions.List() {
+		action := QuickAction{Name: name}
+		for _, h := range c.Handles {
+			if h.Kind == name && h.Link() != "" {
+				action.Label, action.Link = h.Label(), h.Link()
+				break
+			}
+		}
+		switch {
+		case action.Link != "":
+		case name == "whatsapp" && mobile != "":
+			action.Label, action.Link = "WhatsApp", "https://wa.me/"+mobile[1:]
+		case name == "signal" && mobile != "":
+			action.Label, action.Link = "Signal", "https://signal.me/#p/"+mobile
+		default:
+			continue
+		}
+		action.Counted = containsString(usageActions, name)
+		out = append(out, action)
+	}
+	return out
+}
+
+// ActionStore keeps which quick actions show on cards in a file beside the
+// contact data. an empty filename keeps them in memory only
+type ActionStore struct {
+	mu       sync.RWMutex
+	filename string
+	actions  []string
+}
+
+// quick actions shared by the handlers
+var quickActions = NewActionStore("")
+
+func NewActionStore(filename string) *ActionStore {
+	return &ActionStore{filename: filename, actions: quickActionNames}
+}
+
+// Load reads the quick actions file, showing every action when there is
+// none yet
+func (a *ActionStore) Load() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.filename == "" {
+		return nil
+	}
+	var loaded []string
+	if err := loadJSONFile(a.filename, &loaded); err != nil {
+		return err
+	}
+	if loaded != nil {
+		a.actions = loaded
+	}
+	return nil
+}
+
+// Save writes the quick actions file again, used when the data key changes
+func (a *ActionStore) Save() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.filename == "" {
+		return nil
+	}
+	return saveJSONFile(a.filename, a.actions)
+}
+
+// List returns the quick actions shown, in card order
+func (a *ActionStore) List() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return append([]string(nil), a.actions...)
+}
+
+// Set picks the quick actions to show
+func (a *ActionStore) Set(names []string) error {
+	actions := []string{}
+	for _, name := range quickActionNames {
+		if containsString(names, name) {
+			actions = append(actions, name)
+		}
+	}
+	for _, name := range names {
+		if !containsString(quickActionNames, name) {
+			return fmt.Errorf("Invalid quick action: %s", name)
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	before := a.actions
+	a.actions = actions
+	if a.filename == "" {
+		return nil
+	}
+	if err := saveJSONFile(a.filename, a.actions); err != nil {
+		a.actions = before
+		return err
+	}
+	return nil
+}
+
+var actionSettingsHTML = `{{define "action-settings"}}
+<h4 class="text-lg font-bold mt-6 mb-1">Quick actions</h4>
+<p class="text-sm text-gray-500 mb-2">Buttons shown on cards of contacts with a mobile number or the matching handle.</p>
+<form class="flex flex-wrap items-center gap-2 p-2 mb-2 border rounded-lg"
+      hx-put="/settings/actions"
+      hx-target="#modal-container"
+      hx-swap="innerHTML">
+    {{range .Actions}}
+    <label class="inline-flex items-center px-3 py-1 rounded-full border text-sm cursor-pointer hover:bg-blue-50 has-[:checked]:bg-blue-600 has-[:checked]:text-white">
+        <input type="checkbox" name="Action" value="{{.Name}}" class="hidden" {{if .On}}checked{{end}}>{{.Label}}
+    </label>
+    {{end}}
+    <button type="submit" class="ml-auto px-3 py-1 text-sm rounded-lg border border-gray-300 hover:border-blue-500 hover:bg-blue-50 transition-colors">Save</button>
+</form>
+{{end}}`
+
+// actionChoice is a quick action in the settings form
+type actionChoice struct {
+	Name  string
+	Label string
+	On    bool
+}
+
+// actionChoices lists every quick action for the settings form
+func actionChoices() []actionChoice {
+	shown := quickActions.List()
+	var out []actionChoice
+	for _, name := range quickActionNames {
+		label := "WhatsApp"
+		if k, ok := findHandleKind(name); ok {
+			label = k.Label
+		}
+		out = append(out, actionChoice{Name: name, Label: label, On: containsString(shown, name)})
+	}
+	return out
+}
+
+// saveActions picks the quick actions shown on cards
+func saveActions(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := quickActions.Set(r.Form["Action"]); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fmt.Printf("Quick actions set to %v\n", quickActions.List())
+	renderSettings(w, true)
+}
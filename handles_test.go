@@ -0,0 +1,109 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseHandle(t *testing.T) {
+	tests := []struct {
+		kind, value string
+		want        string
+		link        string
+		err         bool
+	}{
+		{kind: "telegram", value: "https://t.me/alice_b", want: "alice_b", link: "https://t.me/alice_b"},
+		{kind: "telegram", value: "@al", err: true},
+		{kind: "signal", value: "+1 (555) 123-4567", want: "+15551234567", link: "https://signal.me/#p/+15551234567"},
+		{kind: "signal", value: "555 1234", err: true},
+		{kind: "signal", value: "@alice.01", want: "alice.01", link: "https://signal.me/#u/YWxpY2UuMDE"},
+		{kind: "signal", value: "alice", err: true},
+		{kind: "signal", value: "https://signal.me/#p/+15551234567", want: "+15551234567", link: "https://signal.me/#p/+15551234567"},
+		{kind: "signal", value: "https://signal.me/#u/YWxpY2UuMDE=", want: "alice.01", link: "https://signal.me/#u/YWxpY2UuMDE"},
+		{kind: "signal", value: "https://signal.me/#u/YWxpY2U", err: true},
+		{kind: "signal", value: "https://signal.me/#eu/" + "AbCdEfGhIjKlMnOpQrStUvWxYz_-0123", want: "https://signal.me/#eu/AbCdEfGhIjKlMnOpQrStUvWxYz_-0123", link: "https://signal.me/#eu/AbCdEfGhIjKlMnOpQrStUvWxYz_-0123"},
+		{kind: "signal", value: "https://signal.me/#eu/<script>", err: true},
+		{kind: "signal", value: "https://signal.me/#x/anything", err: true},
+		{kind: "github", value: "https://github.com/alice/", want: "alice", link: "https://github.com/alice"},
+		{kind: "mastodon", value: "https://Mastodon.Social/@alice", want: "@alice@mastodon.social", link: "https://mastodon.social/@alice"},
+		{kind: "website", value: "a.dev/x;y", want: "https://a.dev/x;y", link: "https://a.dev/x;y"},
+		{kind: "fax", value: "123", err: true},
+	}
+	for _, tt := range tests {
+		h, err := parseHandle(tt.kind, tt.value)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseHandle(%q, %q) = %q, want an error", tt.kind, tt.value, h.Value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseHandle(%q, %q): %v", tt.kind, tt.value, err)
+			continue
+		}
+		if h.Value != tt.want || h.Link() != tt.link {
+			t.Errorf("parseHandle(%q, %q) = %q linking %q, want %q linking %q", tt.kind, tt.value, h.Value, h.Link(), tt.want, tt.link)
+		}
+	}
+}
+
+func TestQuickActionsPhoneFallback(t *testing.T) {
+	tests := []struct {
+		phone    string
+		whatsapp string
+		signal   string
+	}{
+		{phone: "+60 12-345 6789", whatsapp: "https://wa.me/60123456789", signal: "https://signal.me/#p/+60123456789"},
+		{phone: "012-345 6789"},
+		{phone: ""},
+	}
+	for _, tt := range tests {
+		c := Contact{Phones: []ContactValue{{Label: "mobile", Value: tt.phone}}}
+		links := map[string]string{}
+		for _, action := range c.QuickActions() {
+			links[action.Name] = action.Link
+		}
+		if links["whatsapp"] != tt.whatsapp || links["signal"] != tt.signal {
+			t.Errorf("quick actions for %q = %v, want whatsapp %q and signal %q", tt.phone, links, tt.whatsapp, tt.signal)
+		}
+	}
+}
+
+func TestHandlesRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		handles []Handle
+		want    []Handle
+		err     bool
+	}{
+		{name: "none"},
+		{
+			name:    "semicolon in a website",
+			handles: []Handle{{Kind: "website", Value: "https://a.dev/x;y"}, {Kind: "github", Value: "alice"}},
+			want:    []Handle{{Kind: "website", Value: "https://a.dev/x;y"}, {Kind: "github", Value: "alice"}},
+		},
+		{
+			name:    "checked and normalized",
+			handles: []Handle{{Kind: "telegram", Value: "https://t.me/alice_b"}, {Kind: "telegram", Value: "@alice_b"}},
+			want:    []Handle{{Kind: "telegram", Value: "alice_b"}},
+		},
+		{name: "invalid", handles: []Handle{{Kind: "github", Value: "not a name"}}, err: true},
+		{name: "unknown kind", handles: []Handle{{Kind: "fax", Value: "123"}}, err: true},
+	}
+	for _, tt := range tests {
+		got, err := decodeHandles(encodeHandles(tt.handles))
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: decoded %+v, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: round trip gave %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
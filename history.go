@@ -0,0 +1,542 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Revision is one recorded change to a contact
+type Revision struct {
+	Rev     int
+	At      time.Time
+	By      string
+	Action  string // baseline, create, update, timeline, tags, groups, favorite, type, fields, organization, relation, restore, delete, undelete, reload, resolve or snapshot
+	Changes []FieldChange
+	Contact Contact // contact as it was after this change
+}
+
+// UnmarshalJSON upgrades the contact of a revision recorded under an older
+// schema. the history file carries no version, so every migration is run,
+// which leaves contacts already in a newer form as they are
+func (r *Revision) UnmarshalJSON(data []byte) error {
+	type plain Revision
+	var raw struct {
+		plain
+		Contact json.RawMessage
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*r = Revision(raw.plain)
+	if len(raw.Contact) == 0 || string(raw.Contact) == "null" {
+		return nil
+	}
+	contact, err := decodeContact(raw.Contact, 0)
+	if err != nil {
+		return err
+	}
+	r.Contact = contact
+	return nil
+}
+
+// FieldChange holds the previous and new value of one field
+type FieldChange struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// contactField is one named, displayable value of a contact. Raw is the
+// value in the form the edit form reads back, when that differs from Value
+type contactField struct {
+	Name  string
+	Value string
+	Raw   string
+}
+
+// formValue is the value to submit for the field in an edit
+func (f contactField) formValue() string {
+	if f.Raw != "" {
+		return f.Raw
+	}
+	return f.Value
+}
+
+// restoredFields are the fields a restore puts back from an earlier
+// revision, the details typed into the edit form
+var restoredFields = []string{
+	"Contact Type", "First Name", "Last Name", "Emails", "Phones", "Addresses",
+	"Organization", "Job Title", "Department", "Birthday", "Dates", "Handles",
+	"Notes", "Tags", "Custom Fields",
+}
+
+// fields lists the values compared between revisions
+func (c Contact) fields() []contactField {
+	return []contactField{
+		{Name: "Contact Type", Value: c.ContactType},
+		{Name: "First Name", Value: c.FirstName},
+		{Name: "Last Name", Value: c.LastName},
+		{Name: "Emails", Value: formatValues(c.Emails), Raw: encodeValues(c.Emails)},
+		{Name: "Phones", Value: formatValues(c.Phones), Raw: encodeValues(c.Phones)},
+		{Name: "Addresses", Value: formatAddresses(c.Addresses), Raw: encodeAddresses(c.Addresses)},
+		{Name: "Organization", Value: orgName(c.Organization.ID), Raw: c.Organization.ID},
+		{Name: "Job Title", Value: c.Organization.JobTitle},
+		{Name: "Department", Value: c.Organization.Department},
+		{Name: "Birthday", Value: c.Birthday.String(), Raw: c.Birthday.ISO()},
+		{Name: "Dates", Value: formatDates(c.Dates), Raw: encodeDates(c.Dates)},
+		{Name: "Handles", Value: formatHandles(c.Handles), Raw: encodeHandles(c.Handles)},
+		{Name: "Notes", Value: c.Notes},
+		{Name: "Tags", Value: formatTags(c.Tags)},
+		{Name: "Groups", Value: formatGroups(c.Groups), Raw: strings.Join(c.Groups, ",")},
+		{Name: "Custom Fields", Value: formatCustom(c), Raw: encodeCustom(c.Custom)},
+		{Name: "Photo", Value: c.Photo},
+		{Name: "Favorite", Value: formatFavorite(c.Favorite)},
+		{Name: "Relationships", Value: formatRelations(c.Relations)},
+		{Name: "Timeline", Value: formatTimeline(c.Interactions)},
+		{Name: "In Trash Since", Value: formatTime(c.DeletedAt)},
+	}
+}
+
+// format a timestamp for display, blank when unset
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2 Jan 2006 15:04")
+}
+
+// ago describes how long ago t was, such as "3 days ago"
+func ago(t time.Time) string {
+	d := time.Since(t)
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return "1 " + unit + " ago"
+		}
+		return fmt.Sprintf("%d %ss ago", n, unit)
+	}
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return plural(int(d/time.Minute), "minute")
+	case d < 24*time.Hour:
+		return plural(int(d/time.Hour), "hour")
+	case d < 48*time.Hour:
+		return "yesterday"
+	case d < 30*24*time.Hour:
+		return plural(int(d/(24*time.Hour)), "day")
+	case d < 365*24*time.Hour:
+		return plural(int(d/(30*24*time.Hour)), "month")
+	default:
+		return plural(int(d/(365*24*time.Hour)), "year")
+	}
+}
+
+// template funcs shared by the card and the modals
+var templateFuncs = template.FuncMap{
+	"ago":         ago,
+	"daysAgo":     daysAgo,
+	"orgName":     orgName,
+	"groupName":   groupName,
+	"contactType": func(name string) ContactTypeDef { return contactTypes.Lookup(name) },
+}
+
+// diffContacts lists every field whose value differs between before and after
+func diffContacts(before, after Contact) []FieldChange {
+	old := before.fields()
+	var changes []FieldChange
+	for i, f := range after.fields() {
+		if old[i].Value != f.Value {
+			changes = append(changes, FieldChange{Field: f.Name, Old: old[i].Value, New: f.Value})
+		}
+	}
+	return changes
+}
+
+// most revisions kept per contact, the oldest are dropped past it
+var historyMaxRevisions = 100
+
+// size past which the history journal is folded back into the history file
+const historyMaxJournal = 1 << 20
+
+// historyRecord is one line of the history journal
+type historyRecord struct {
+	Op       string // add or forget
+	ID       string
+	Revision *Revision `json:",omitempty"`
+}
+
+// HistoryStore keeps the revisions of every contact in a file beside the
+// contact data. each change appends one record to a journal next to the
+// file rather than rewriting it, and the journal is folded back in once it
+// grows past historyMaxJournal. an empty filename keeps history in memory
+// only
+type HistoryStore struct {
+	mu        sync.Mutex
+	filename  string
+	journal   string
+	file      *os.File
+	size      int64
+	revisions map[string][]Revision
+}
+
+func NewHistoryStore(filename string) *HistoryStore {
+	h := &HistoryStore{
+		filename:  filename,
+		revisions: map[string][]Revision{},
+	}
+	if filename != "" {
+		h.journal = filename + ".journal"
+	}
+	return h
+}
+
+// Load reads the history file and replays the journal on top of it
+func (h *HistoryStore) Load() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.filename == "" {
+		return nil
+	}
+	revisions := map[string][]Revision{}
+	if err := loadJSONFile(h.filename, &revisions); err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(h.journal)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read history journal %s: %w", h.journal, err)
+	}
+	var good int64
+	for good < int64(len(data)) {
+		line, _, found := bytes.Cut(data[good:], []byte("\n"))
+		if !found {
+			//an append cut short by a crash
+			fmt.Printf("WARNING: dropping incomplete last record in %s\n", h.journal)
+			break
+		}
+		plain, err := openData(line)
+		if err != nil {
+			return fmt.Errorf("history journal %s record at byte %d: %w", h.journal, good, err)
+		}
+		var rec historyRecord
+		if err := json.Unmarshal(plain, &rec); err != nil {
+			return fmt.Errorf("history journal %s is damaged at byte %d: %w", h.journal, good, err)
+		}
+		applyHistoryRecord(revisions, rec)
+		good += int64(len(line)) + 1
+	}
+	//files written before the cap keep only their newest revisions
+	for id, revs := range revisions {
+		revisions[id] = capRevisions(revs)
+	}
+
+	if h.file != nil {
+		h.file.Close()
+	}
+	h.file, err = os.OpenFile(h.journal, os.O_CREATE|os.O_WRONLY|os.O_APPEND, dataFilePerm)
+	if err != nil {
+		return fmt.Errorf("failed to open history journal %s: %w", h.journal, err)
+	}
+	if err := h.file.Truncate(good); err != nil {
+		return fmt.Errorf("failed to truncate history journal %s: %w", h.journal, err)
+	}
+	h.size = good
+	h.revisions = revisions
+	return nil
+}
+
+func applyHistoryRecord(revisions map[string][]Revision, rec historyRecord) {
+	switch rec.Op {
+	case "add":
+		if rec.Revision != nil {
+			revisions[rec.ID] = capRevisions(append(revisions[rec.ID], *rec.Revision))
+		}
+	case "forget":
+		delete(revisions, rec.ID)
+	}
+}
+
+// capRevisions drops the oldest revisions past historyMaxRevisions
+func capRevisions(revs []Revision) []Revision {
+	if historyMaxRevisions > 0 && len(revs) > historyMaxRevisions {
+		return append([]Revision(nil), revs[len(revs)-historyMaxRevisions:]...)
+	}
+	return revs
+}
+
+// Record adds a revision for a change from before to after. before is nil
+// for a new contact and after is nil for a deleted one. the first change to
+// a contact that predates history also stores a baseline of its old values
+func (h *HistoryStore) Record(before, after *Contact, by, action string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var id string
+	var changes []FieldChange
+	var snapshot Contact
+	switch {
+	case before != nil && after != nil:
+		id, snapshot = after.ID, *after
+		changes = diffContacts(*before, *after)
+	case after != nil:
+		id, snapshot = after.ID, *after
+		changes = diffContacts(Contact{}, *after)
+	case before != nil:
+		id, snapshot = before.ID, *before
+		changes = diffContacts(*before, Contact{})
+	default:
+		return nil
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	var added []Revision
+	revs := h.revisions[id]
+	if len(revs) == 0 && before != nil {
+		added = append(added, Revision{Rev: 1, Action: "baseline", Contact: *before})
+	}
+	rev := len(added) + 1
+	if len(revs) > 0 {
+		rev = revs[len(revs)-1].Rev + 1
+	}
+	added = append(added, Revision{
+		Rev:     rev,
+		At:      time.Now(),
+		By:      by,
+		Action:  action,
+		Changes: changes,
+		Contact: snapshot,
+	})
+
+	var recs []historyRecord
+	for i := range added {
+		recs = append(recs, historyRecord{Op: "add", ID: id, Revision: &added[i]})
+	}
+	if err := h.append(recs...); err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		applyHistoryRecord(h.revisions, rec)
+	}
+	return h.compactIfLarge()
+}
+
+// append writes records to the journal in one synced write, the caller
+// holding the lock
+func (h *HistoryStore) append(recs ...historyRecord) error {
+	if h.filename == "" {
+		return nil
+	}
+	if h.file == nil {
+		return fmt.Errorf("history journal %s is not open, load it first", h.journal)
+	}
+	var buf bytes.Buffer
+	for _, rec := range recs {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return fmt.Errorf("Failed to marshal history record: %w", err)
+		}
+		data, err = sealData(data)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt history record: %w", err)
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	if _, err := h.file.Write(buf.Bytes()); err != nil {
+		h.file.Truncate(h.size)
+		return fmt.Errorf("failed to append to history journal %s: %w", h.journal, err)
+	}
+	if err := h.file.Sync(); err != nil {
+		h.file.Truncate(h.size)
+		return fmt.Errorf("failed to sync history journal %s: %w", h.journal, err)
+	}
+	h.size += int64(buf.Len())
+	return nil
+}
+
+// compactIfLarge folds the journal into the history file once it passes
+// historyMaxJournal. the change is already in the journal, so a failure
+// here is logged and tried again on the next change
+func (h *HistoryStore) compactIfLarge() error {
+	if h.size < historyMaxJournal {
+		return nil
+	}
+	if err := h.compact(); err != nil {
+		fmt.Printf("Error compacting history journal: %v\n", err)
+	}
+	return nil
+}
+
+// compact writes the history file and empties the journal, the caller
+// holding the lock
+func (h *HistoryStore) compact() error {
+	if h.filename == "" {
+		return nil
+	}
+	if err := saveJSONFile(h.filename, h.revisions); err != nil {
+		return err
+	}
+	var err error
+	if h.file != nil {
+		err = h.file.Truncate(0)
+	} else if err = os.Truncate(h.journal, 0); os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to truncate history journal %s: %w", h.journal, err)
+	}
+	h.size = 0
+	return nil
+}
+
+// Save writes the history file again and empties the journal, used when
+// the data key changes
+func (h *HistoryStore) Save() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.compact()
+}
+
+// Forget drops every revision of a contact, used when it is purged for good
+func (h *HistoryStore) Forget(id string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.revisions[id]; !ok {
+		return nil
+	}
+	//the history file still holds the revisions until the next compaction,
+	//so fold the journal in now rather than leave a purged contact on disk
+	delete(h.revisions, id)
+	return h.compact()
+}
+
+// List returns a copy of the revisions of a contact, oldest first
+func (h *HistoryStore) List(id string) []Revision {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]Revision(nil), h.revisions[id]...)
+}
+
+func (h *HistoryStore) Get(id string, rev int) (Revision, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, r := range h.revisions[id] {
+		if r.Rev == rev {
+			return r, nil
+		}
+	}
+	return Revision{}, fmt.Errorf("No revision %d found for contact %s", rev, id)
+}
+
+var historyModalHTML = `
+<div id="contact-modal" class="fixed inset-0 bg-gray-600 bg-opacity-50 overflow-y-auto h-full w-full">
+    <div class="relative top-20 mx-auto p-5 border w-full max-w-2xl shadow-lg rounded-md bg-white">
+        <div class="flex justify-end">
+            <button hx-target="#contact-modal" hx-swap="outerHTML" hx-get="/modal/close" class="text-gray-400 hover:text-gray-600">&times;</button>
+        </div>
+        <h3 class="text-xl font-bold mb-4">History of {{.Contact.FirstName}} {{.Contact.LastName}}</h3>
+        {{if not .Revisions}}
+        <div class="p-4 bg-gray-100 text-gray-500 rounded-lg">No changes recorded yet.</div>
+        {{end}}
+        {{range .Revisions}}
+        <div class="mb-4 p-4 border rounded-lg">
+            <div class="flex justify-between items-center mb-2">
+                <div class="text-sm text-gray-600">
+                    <span class="font-semibold text-gray-800">#{{.Rev}} {{.Action}}</span>
+                    {{if .At.IsZero}}earliest known version{{else}}{{.At.Format "2 Jan 2006 15:04"}}{{end}}
+                    {{if .By}}by {{.By}}{{end}}
+                </div>
+                {{if ne .Rev $.Latest}}
+                <button class="px-3 py-1 text-sm rounded-lg border border-gray-300 hover:border-blue-500 hover:bg-blue-50 transition-colors"
+                    hx-post="/contacts/{{$.Contact.ID}}/history/{{.Rev}}/restore"
+                    hx-target="#contact-{{$.Contact.ID}}"
+                    hx-swap="outerHTML"
+                    hx-confirm="Restore this version?"
+                    hx-on::after-request="if(event.detail.successful) htmx.remove(htmx.find('#contact-modal'))">
+                    Restore this version
+                </button>
+                {{end}}
+            </div>
+            {{if .Changes}}
+            <table class="w-full text-sm">
+                {{range .Changes}}
+                <tr class="border-t">
+                    <td class="py-1 pr-2 font-medium text-gray-700">{{.Field}}</td>
+                    <td class="py-1 pr-2 text-red-700 line-through">{{.Old}}</td>
+                    <td class="py-1 text-green-700">{{.New}}</td>
+                </tr>
+                {{end}}
+            </table>
+            {{else}}
+            <div class="text-sm text-gray-500">{{with .Contact}}{{.ContactType}} &middot; {{.FirstName}} {{.LastName}} &middot; {{.PrimaryEmail}} &middot; {{.PrimaryPhone}}{{end}}</div>
+            {{end}}
+        </div>
+        {{end}}
+    </div>
+</div>
+`
+
+var historyModal = template.Must(template.New("history-modal").Parse(historyModalHTML))
+
+// contactHistory renders the revisions of a contact newest first
+func contactHistory(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	contact, err := store.Find(id)
+	if err != nil {
+		http.Error(w, "Contact not found", http.StatusNotFound)
+		return
+	}
+
+	revs := store.History(id)
+	latest := 0
+	if len(revs) > 0 {
+		latest = revs[len(revs)-1].Rev
+	}
+	for i, k := 0, len(revs)-1; i < k; i, k = i+1, k-1 {
+		revs[i], revs[k] = revs[k], revs[i]
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	err = historyModal.Execute(w, map[string]any{
+		"Contact":   contact,
+		"Revisions": revs,
+		"Latest":    latest,
+	})
+	if err != nil {
+		fmt.Printf("Error rendering history for %s: %v\n", id, err)
+	}
+}
+
+// restoreRevision puts a contact back to the values of an earlier revision
+func restoreRevision(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	rev, err := strconv.Atoi(vars["rev"])
+	if err != nil {
+		http.Error(w, "Invalid revision", http.StatusBadRequest)
+		return
+	}
+
+	contact, err := store.Restore(id, rev, currentUser(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	fmt.Printf("Restored contact %s to revision %d\n", id, rev)
+	renderCard(w, contact)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestHistoryStoreReload(t *testing.T) {
+	defer func(n int) { historyMaxRevisions = n }(historyMaxRevisions)
+	historyMaxRevisions = 5
+
+	tests := []struct {
+		name     string
+		changes  int
+		baseline bool // alice predates history
+		forget   bool
+		compact  bool
+		wantRevs []int
+	}{
+		{name: "under the cap", changes: 3, wantRevs: []int{1, 2, 3}},
+		{name: "older contact", changes: 3, baseline: true, wantRevs: []int{1, 2, 3, 4}},
+		{name: "past the cap", changes: 8, wantRevs: []int{4, 5, 6, 7, 8}},
+		{name: "compacted", changes: 8, compact: true, wantRevs: []int{4, 5, 6, 7, 8}},
+		{name: "forgotten", changes: 3, forget: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "AFcb.history.json")
+			h := NewHistoryStore(filename)
+			if err := h.Load(); err != nil {
+				t.Fatal(err)
+			}
+			var before *Contact
+			if tt.baseline {
+				before = &Contact{ID: "alice", Notes: "0"}
+			}
+			for i := 1; i <= tt.changes; i++ {
+				after := Contact{ID: "alice", Version: i, Notes: strconv.Itoa(i)}
+				if err := h.Record(before, &after, "af", "update"); err != nil {
+					t.Fatal(err)
+				}
+				//recording the same values again adds nothing
+				if err := h.Record(&after, &after, "af", "update"); err != nil {
+					t.Fatal(err)
+				}
+				before = &after
+			}
+			//a change to someone else is kept apart
+			if err := h.Record(nil, &Contact{ID: "bob", Version: 1, FirstName: "Bob"}, "af", "create"); err != nil {
+				t.Fatal(err)
+			}
+			if tt.forget {
+				if err := h.Forget("alice"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.compact {
+				if err := h.Save(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			reloaded := NewHistoryStore(filename)
+			if err := reloaded.Load(); err != nil {
+				t.Fatal(err)
+			}
+			revs := reloaded.List("alice")
+			if len(revs) != len(tt.wantRevs) {
+				t.Fatalf("reloaded %d revisions, want %d", len(revs), len(tt.wantRevs))
+			}
+			offset := 0
+			if tt.baseline {
+				offset = 1
+			}
+			for i, rev := range revs {
+				want := strconv.Itoa(tt.wantRevs[i] - offset)
+				if rev.Rev != tt.wantRevs[i] || rev.Contact.Notes != want {
+					t.Errorf("revision %d is rev %d with notes %q, want rev %d with notes %q", i, rev.Rev, rev.Contact.Notes, tt.wantRevs[i], want)
+				}
+			}
+			if len(reloaded.List("bob")) != 1 {
+				t.Errorf("other contact has %d revisions, want 1", len(reloaded.List("bob")))
+			}
+		})
+	}
+}
+
+func TestDiffContacts(t *testing.T) {
+	before := Contact{FirstName: "Alice", Phones: []ContactValue{{Label: "mobile", Value: "+1 555 1234;ext=2"}}}
+	tests := []struct {
+		name  string
+		after func(c Contact) Contact
+		want  []string
+	}{
+		{name: "unchanged", after: func(c Contact) Contact { return c }},
+		{name: "name", after: func(c Contact) Contact { c.FirstName = "Alicia"; return c }, want: []string{"First Name"}},
+		{name: "phone and notes", after: func(c Contact) Contact {
+			c.Phones = []ContactValue{{Label: "work", Value: "+1 555 1234;ext=2"}}
+			c.Notes = "new"
+			return c
+		}, want: []string{"Phones", "Notes"}},
+	}
+	for _, tt := range tests {
+		changes := diffContacts(before, tt.after(before))
+		var got []string
+		for _, change := range changes {
+			got = append(got, change.Field)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: changed %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
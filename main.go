@@ -1,10 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"flag"
 	"fmt"
 	"html/template"
 	"net/http"
+	"os"
 	"regexp"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -23,6 +30,12 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 			Value: "authenticated",
 			Path:  "/",
 		})
+		//remember who is signed in so changes can be attributed
+		http.SetCookie(w, &http.Cookie{
+			Name:  "user",
+			Value: username,
+			Path:  "/",
+		})
 		w.Header().Set("HX-Redirect", "/")
 
 		w.WriteHeader(http.StatusOK)
@@ -41,9 +54,23 @@ func logoutHandler(w http.ResponseWriter, r *http.Request) {
 		Path:   "/",
 		MaxAge: -1,
 	})
+	http.SetCookie(w, &http.Cookie{
+		Name:   "user",
+		Value:  "",
+		Path:   "/",
+		MaxAge: -1,
+	})
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
 
+// currentUser returns the username of the signed in user
+func currentUser(r *http.Request) string {
+	if cookie, err := r.Cookie("user"); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+	return ValidUser.Username
+}
+
 func authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		cookie, err := r.Cookie("session")
@@ -55,55 +82,187 @@ func authMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-var contacts Contacts
+var store *ContactStore
 
 var emailRegex = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
 
 const dataFile = "AFcb.json"
 
-var conCard = template.Must(template.New("card").Parse(`
+// contacts shown in the recently added and recently updated views
+const recentLimit = 20
+
+var conCard = template.Must(template.New("card").Funcs(templateFuncs).Funcs(avatarFuncs).Parse(avatarHTML + `
     <div class="card bg-white rounded-xl shadow-md p-6 hover:shadow-lg transition-all duration-300" id="contact-{{.ID}}">
     <div class="details">
-        <span class="id text-xs font-semibold text-gray-500">ID: {{.ID}}</span>
-        <strong class="name block text-xl font-bold text-gray-800 mt-1">{{.FirstName}} {{.LastName}}</strong>
-        <span class="type inline-block mt-2 px-3 py-1 rounded-full text-sm font-medium
-            {{if eq .ContactType "Personal"}}bg-blue-100 text-blue-800
-            {{else if eq .ContactType "Work"}}bg-green-100 text-green-800
-            {{else if eq .ContactType "Family"}}bg-purple-100 text-purple-800
-            {{else}}bg-gray-100 text-gray-800{{end}}">
-            {{.ContactType}}
+        <div class="flex items-center gap-3">
+            {{template "avatar" (avatar . 96 48)}}
+            <div class="flex-1">
+                <span class="id text-xs font-semibold text-gray-500">ID: {{.ID}}</span>
+                <strong class="name block text-xl font-bold text-gray-800">{{.FirstName}} {{.LastName}}</strong>
+            </div>
+            <button class="favorite-btn self-start p-1 rounded-full {{if .Favorite}}text-yellow-400{{else}}text-gray-300{{end}} hover:text-yellow-500 transition-colors"
+                hx-post="/contacts/{{.ID}}/favorite"
+                hx-target="#contact-{{.ID}}"
+                hx-swap="outerHTML"
+                title="{{if .Favorite}}Remove from favorites{{else}}Add to favorites{{end}}">
+                <svg xmlns="http://www.w3.org/2000/svg" class="h-6 w-6" viewBox="0 0 24 24" fill="{{if .Favorite}}currentColor{{else}}none{{end}}" stroke="currentColor" stroke-width="2" stroke-linejoin="round">
+                    <polygon points="12 2 15.09 8.26 22 9.27 17 14.14 18.18 21.02 12 17.77 5.82 21.02 7 14.14 2 9.27 8.91 8.26 12 2"/>
+                </svg>
+            </button>
+        </div>
+        {{with .Organization}}
+        <div class="org text-sm text-gray-600">
+            {{.Role}}{{if and .ID .Role}} at {{end}}
+            {{if .ID}}<button class="text-blue-600 hover:underline" hx-get="/organizations/{{.ID}}" hx-target="#modal-container" hx-swap="innerHTML">{{orgName .ID}}</button>{{end}}
+        </div>
+        {{end}}
+        {{with contactType .ContactType}}
+        <span class="type inline-block mt-2 px-3 py-1 rounded-full text-sm font-medium bg-{{.Colour}}-100 text-{{.Colour}}-800">
+            {{with .Icon}}{{.}} {{end}}{{.Name}}
         </span>
+        {{end}}
+        {{range .Tags}}
+        <button class="tag inline-block mt-2 px-2 py-1 rounded-full text-xs font-medium bg-indigo-100 text-indigo-800 hover:bg-indigo-200"
+            hx-get="/contacts?tag={{urlquery .}}"
+            hx-target="#contact-list"
+            hx-swap="innerHTML"
+            title="Show contacts tagged {{.}}">
+            {{.}}
+        </button>
+        {{end}}
         <div class="details mt-3 text-gray-600">
+            {{range $i, $e := .Emails}}
             <div class="flex items-center mb-1">
                 <svg xmlns="http://www.w3.org/2000/svg" class="h-4 w-4 mr-2" fill="none" viewBox="0 0 24 24" stroke="currentColor">
                     <path stroke-linecap="round" stroke-linejoin="round" stroke-width="2" d="M3 8l7.89 5.26a2 2 0 002.22 0L21 8M5 19h14a2 2 0 002-2V7a2 2 0 00-2-2H5a2 2 0 00-2 2v10a2 2 0 002 2z" />
                 </svg>
-                <span id="email-{{.ID}}">{{.Email}}</span>
-                <button onclick="copyEmail('email-{{.ID}}')" class="ml-2 p-1 rounded-full hover:bg-gray-200 focus:outline-none focus:ring-2 focus:ring-blue-500" title="Copy Email">
+                <span id="email-{{$.ID}}-{{$i}}">{{$e.Value}}</span>
+                <span class="ml-2 text-xs text-gray-400">{{$e.Label}}{{if and $e.Primary (gt (len $.Emails) 1)}} &middot; primary{{end}}</span>
+                <button onclick="copyEmail('email-{{$.ID}}-{{$i}}'); recordUse('{{$.ID}}', 'email')" class="ml-2 p-1 rounded-full hover:bg-gray-200 focus:outline-none focus:ring-2 focus:ring-blue-500" title="Copy Email">
                     <svg xmlns="http://www.w3.org/2000/svg" class="h-4 w-4" fill="none" viewBox="0 0 24 24" stroke="currentColor">
                         <path stroke-linecap="round" stroke-linejoin="round" stroke-width="2" d="M8 5H6a2 2 0 00-2 2v12a2 2 0 002 2h10a2 2 0 002-2v-1M8 5a2 2 0 002 2h2a2 2 0 002-2M8 5a2 2 0 012-2h2a2 2 0 012 2m0 0h2.5a1.5 1.5 0 011.5 1.5v4.5m-14-6.5h3v-3h-3v3z" />
                     </svg>
                 </button>
             </div>
-            <div class="flex items-center">
+            {{end}}
+            {{range .Phones}}
+            <div class="flex items-center mb-1">
                 <svg xmlns="http://www.w3.org/2000/svg" class="h-4 w-4 mr-2" fill="none" viewBox="0 0 24 24" stroke="currentColor">
                     <path stroke-linecap="round" stroke-linejoin="round" stroke-width="2" d="M3 5a2 2 0 012-2h3.28a1 1 0 01.948.684l1.498 4.493a1 1 0 01-.502 1.21l-2.257 1.13a11.042 11.042 0 005.516 5.516l1.13-2.257a1 1 0 011.21-.502l4.493 1.498a1 1 0 01.684.949V19a2 2 0 01-2 2h-1C9.716 21 3 14.284 3 6V5z" />
                 </svg>
-                <span>{{.Phone}}</span>
-                <a href="https://wa.me/{{.Phone}}" target="_blank" class="ml-2 p-1 rounded-full text-green-500 hover:bg-green-100 transition-colors" title="WhatsApp">
+                <span>{{.Value}}</span>
+                <span class="ml-2 text-xs text-gray-400">{{.Label}}{{if and .Primary (gt (len $.Phones) 1)}} &middot; primary{{end}}</span>
+            </div>
+            {{end}}
+            {{range .Addresses}}
+            <div class="flex items-start mb-1">
+                <svg xmlns="http://www.w3.org/2000/svg" class="h-4 w-4 mr-2 mt-1 flex-shrink-0" fill="none" viewBox="0 0 24 24" stroke="currentColor">
+                    <path stroke-linecap="round" stroke-linejoin="round" stroke-width="2" d="M17.657 16.657L13.414 20.9a2 2 0 01-2.827 0l-4.244-4.243a8 8 0 1111.314 0z" />
+                    <path stroke-linecap="round" stroke-linejoin="round" stroke-width="2" d="M15 11a3 3 0 11-6 0 3 3 0 016 0z" />
+                </svg>
+                <address class="not-italic text-sm">{{range .Lines}}{{.}}<br>{{end}}</address>
+                <span class="ml-2 text-xs text-gray-400">{{.Label}}</span>
+            </div>
+            {{end}}
+            {{if not .Birthday.IsZero}}
+            <div class="flex items-center mb-1 text-sm">
+                <svg xmlns="http://www.w3.org/2000/svg" class="h-4 w-4 mr-2" fill="none" viewBox="0 0 24 24" stroke="currentColor">
+                    <path stroke-linecap="round" stroke-linejoin="round" stroke-width="2" d="M8 7V3m8 4V3m-9 8h10M5 21h14a2 2 0 002-2V7a2 2 0 00-2-2H5a2 2 0 00-2 2v12a2 2 0 002 2z" />
+                </svg>
+                <span>{{.Birthday}}</span>
+                <span class="ml-2 text-xs text-gray-400">birthday</span>
+            </div>
+            {{end}}
+            {{range .Dates}}
+            <div class="flex items-center mb-1 text-sm">
+                <svg xmlns="http://www.w3.org/2000/svg" class="h-4 w-4 mr-2" fill="none" viewBox="0 0 24 24" stroke="currentColor">
+                    <path stroke-linecap="round" stroke-linejoin="round" stroke-width="2" d="M8 7V3m8 4V3m-9 8h10M5 21h14a2 2 0 002-2V7a2 2 0 00-2-2H5a2 2 0 00-2 2v12a2 2 0 002 2z" />
+                </svg>
+                <span>{{.Date}}</span>
+                <span class="ml-2 text-xs text-gray-400">{{.Label}}</span>
+            </div>
+            {{end}}
+            {{range .CustomValues}}
+            <div class="custom flex items-center mb-1 text-sm">
+                <span class="mr-2 text-xs text-gray-400">{{.Field.Name}}</span>
+                {{if eq .Field.Type "url"}}<a href="{{.Value}}" target="_blank" rel="noopener" class="text-blue-600 hover:underline truncate">{{.Value}}</a>{{else}}<span>{{.Field.Display .Value}}</span>{{end}}
+            </div>
+            {{end}}
+            {{range $h := .Handles}}
+            <div class="handle flex items-center mb-1 text-sm">
+                <span class="mr-2 text-xs text-gray-400">{{$h.Label}}</span>
+                {{with $h.Link}}<a href="{{.}}" target="_blank" rel="noopener" class="text-blue-600 hover:underline truncate">{{$h.Value}}</a>{{else}}<span>{{$h.Value}}</span>{{end}}
+            </div>
+            {{end}}
+            {{with .QuickActions}}
+            <div class="quick-actions flex flex-wrap items-center gap-1 mt-1">
+                {{range .}}
+                <a href="{{.Link}}" target="_blank" rel="noopener" {{if .Counted}}onclick="recordUse('{{$.ID}}', '{{.Name}}')"{{end}} class="p-1 rounded-full {{if eq .Name "whatsapp"}}text-green-500 hover:bg-green-100{{else}}text-gray-800 hover:bg-gray-200{{end}} transition-colors" title="{{.Label}}">
+                    {{if eq .Name "whatsapp"}}
                     <svg xmlns="http://www.w3.org/2000/svg" class="h-5 w-5" viewBox="0 0 24 24" fill="currentColor">
                         <path d="M12.04 2.87c-5.42 0-9.82 4.4-9.82 9.82 0 1.94.57 3.8.14 5.39l-1.39 5.09 5.25-1.36c1.5.25 3.09.4 4.56.4 5.42 0 9.82-4.4 9.82-9.82-.01-5.42-4.4-9.81-9.8-9.81zm-.04 17.1c-1.36 0-2.7-.22-3.9-.66l-2.61.68.68-2.55c-.5-1.16-.76-2.43-.76-3.75 0-4.41 3.59-8 8-8s8 3.59 8 8-3.59 8-8 8zm4.53-5.59c-.25-.13-.49-.2-.72-.2-.23 0-.46.07-.69.21-.23.14-.52.28-.84.38-.32.1-.64.16-.96.06-.32-.1-.6-.24-.87-.45-.27-.2-.5-.45-.7-.7-.19-.24-.34-.49-.49-.77s-.27-.58-.33-.89c-.06-.31-.05-.59-.01-.84.04-.26.13-.5.26-.72.13-.22.25-.4.36-.57.11-.17.18-.32.22-.44.04-.12.02-.27-.04-.43-.06-.16-.18-.32-.34-.48-.16-.16-.36-.31-.6-.44-.24-.13-.49-.2-.73-.2-.24 0-.48.05-.72.15-.24.1-.46.25-.66.44-.2.19-.38.41-.54.67-.16.26-.28.53-.4.81s-.2 0-.25-.06c-.05-.06-.2-.25-.37-.47s-.35-.4-.5-.54c-.16-.14-.28-.2-.37-.2s-.22 0-.36-.05c-.14-.05-.3-.08-.5-.09-.19-.01-.39-.01-.58 0-.19 0-.4.04-.61.09-.2.05-.4.14-.57.26-.17.12-.3.27-.4.45-.1.18-.15.39-.15.63s.06.48.19.74c.12.26.3.52.54.78.24.26.54.55.89.87.35.31.75.63 1.18.96 1.05.78 1.95 1.48 2.5 1.77.55.29 1.01.44 1.39.44.38 0 .82-.13 1.34-.38.52-.25.96-.54 1.33-.88.37-.34.6-.78.71-1.32.11-.54.06-1.04-.08-1.52z"/>
                     </svg>
-                </a>
-                <a href="signal://send?text=&phone={{.Phone}}" target="_blank" class="ml-2 p-1 rounded-full text-gray-800 hover:bg-gray-200 transition-colors" title="Signal">
+                    {{else if eq .Name "signal"}}
                     <svg xmlns="http://www.w3.org/2000/svg" class="h-5 w-5" viewBox="0 0 24 24" fill="currentColor">
                         <path d="M12 2C6.48 2 2 6.48 2 12s4.48 10 10 10 10-4.48 10-10S17.52 2 12 2zm.8 14.8c-.37.37-.87.5-1.37.5-.5 0-1-.13-1.37-.5-.75-.75-.75-1.99 0-2.74L12 11.39l-1.44-1.44c-.75-.75-.75-1.99 0-2.74s1.99-.75 2.74 0L12 8.61l1.44-1.44c.75-.75 1.99-.75 2.74 0s.75 1.99 0 2.74L12.8 12.8l1.44 1.44c.75.75.75 1.99 0 2.74zm0 0"/>
                     </svg>
+                    {{else}}
+                    <span class="px-1 text-xs font-medium">{{.Label}}</span>
+                    {{end}}
                 </a>
+                {{end}}
             </div>
+            {{end}}
+        </div>
+        {{with .Groups}}
+        <div class="groups flex flex-wrap gap-1 mt-3 text-xs">
+            {{range .}}
+            <button class="px-2 py-1 rounded-full bg-blue-50 text-blue-800 hover:bg-blue-100" hx-get="/groups/{{.}}" hx-target="#modal-container" hx-swap="innerHTML">{{groupName .}}</button>
+            {{end}}
         </div>
+        {{end}}
+        {{with .Related}}
+        <div class="related mt-3 text-sm text-gray-600">
+            {{range .}}
+            <div>
+                <span class="text-gray-400">{{.Type}}</span>
+                <button class="text-blue-600 hover:underline" hx-get="/contacts/{{.Contact.ID}}/detail" hx-target="#modal-container" hx-swap="innerHTML">{{.Contact.FirstName}} {{.Contact.LastName}}</button>
+            </div>
+            {{end}}
+        </div>
+        {{end}}
+        {{with .LastContacted}}{{if not .IsZero}}
+        <div class="last-contacted mt-3 text-sm text-gray-600">Last contacted {{daysAgo .}}</div>
+        {{end}}{{end}}
+        {{if not .UpdatedAt.IsZero}}
+        <div class="meta mt-3 text-xs text-gray-400" title="Added {{.CreatedAt.Format "2 Jan 2006 15:04"}}{{if .CreatedBy}} by {{.CreatedBy}}{{end}}">
+            {{if .UpdatedAt.Equal .CreatedAt}}added{{else}}edited{{end}} {{ago .UpdatedAt}}{{if .UpdatedBy}} by {{.UpdatedBy}}{{end}}
+        </div>
+        {{end}}
     </div>
     <div class="actions flex justify-end mt-4 space-x-2">
+        <button class="detail-btn p-2 rounded-lg border border-gray-300 hover:border-blue-500 hover:bg-blue-50 transition-colors"
+            hx-get="/contacts/{{.ID}}/detail"
+            hx-target="#modal-container"
+            hx-swap="innerHTML"
+            title="Notes and timeline">
+            <svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 24 24" width="20" height="20" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round">
+                <path d="M14 2H6a2 2 0 0 0-2 2v16a2 2 0 0 0 2 2h12a2 2 0 0 0 2-2V8z"/>
+                <polyline points="14 2 14 8 20 8"/>
+                <line x1="16" y1="13" x2="8" y2="13"/>
+                <line x1="16" y1="17" x2="8" y2="17"/>
+            </svg>
+        </button>
+        <button class="history-btn p-2 rounded-lg border border-gray-300 hover:border-blue-500 hover:bg-blue-50 transition-colors"
+            hx-get="/contacts/{{.ID}}/history"
+            hx-target="#modal-container"
+            hx-swap="innerHTML"
+            title="History">
+            <svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 24 24" width="20" height="20" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round">
+                <circle cx="12" cy="12" r="10"/>
+                <polyline points="12 6 12 12 16 14"/>
+            </svg>
+        </button>
         <button class="edit-btn p-2 rounded-lg border border-gray-300 hover:border-blue-500 hover:bg-blue-50 transition-colors"
             hx-get="/modal/edit/{{.ID}}"
             hx-target="#modal-container"
@@ -118,7 +277,6 @@ var conCard = template.Must(template.New("card").Parse(`
                 hx-delete="/contacts/{{.ID}}"
                 hx-target="#contact-{{.ID}}"
                 hx-swap="outerHTML"
-                hx-confirm="Are you sure you want to delete this contact?"
                 title="Delete">
             <svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 24 24" width="20" height="20" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round">
                 <polyline points="3 6 5 6 21 6"/>
@@ -133,7 +291,7 @@ var conCard = template.Must(template.New("card").Parse(`
 
 var addModalHTML = `
 <div id="contact-modal" class="fixed inset-0 bg-gray-600 bg-opacity-50 overflow-y-auto h-full w-full">
-    <div class="relative top-20 mx-auto p-5 border w-96 shadow-lg rounded-md bg-white">
+    <div class="relative top-20 mx-auto p-5 border w-full max-w-lg shadow-lg rounded-md bg-white">
         <div class="flex justify-end">
             <button hx-target="#contact-modal" hx-swap="outerHTML" hx-get="/modal/close" class="text-gray-400 hover:text-gray-600">&times;</button>
         </div>
@@ -142,15 +300,20 @@ var addModalHTML = `
               hx-post="/contacts"
               hx-target="#contact-list"
               hx-swap="afterbegin"
+              hx-encoding="multipart/form-data"
               hx-on::after-request="if(event.detail.successful) htmx.remove(htmx.find('#contact-modal'))">
             <input type="hidden" id="contact-id" name="id">
             <div class="mb-4">
                 <label class="block text-gray-700 text-sm font-bold mb-2" for="contactType">Contact Type</label>
-                <select id="contactType" name="ContactType" class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline">
-                    <option value="Personal">Personal</option>
-                    <option value="Work">Work</option>
-                    <option value="Family">Family</option>
-                </select>
+                {{template "type-select" .ContactType}}
+            </div>
+            <div class="mb-4">
+                <label class="block text-gray-700 text-sm font-bold mb-2" for="photo">Photo</label>
+                <div class="flex items-center gap-3">
+                    {{if .ID}}{{template "avatar" (avatar . 96 48)}}{{end}}
+                    <input id="photo" name="PhotoFile" type="file" accept="image/jpeg,image/png,image/gif" class="text-sm text-gray-700">
+                    {{if .Photo}}<label class="text-sm text-gray-700 whitespace-nowrap"><input type="checkbox" name="RemovePhoto" value="yes"> Remove</label>{{end}}
+                </div>
             </div>
             <div class="mb-4">
                 <label class="block text-gray-700 text-sm font-bold mb-2" for="firstName">First Name</label>
@@ -161,13 +324,55 @@ var addModalHTML = `
                 <input class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline" id="lastName" name="LastName" type="text" placeholder="Last Name" required>
             </div>
             <div class="mb-4">
-                <label class="block text-gray-700 text-sm font-bold mb-2" for="email">Email</label>
-                <input class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline" id="email" name="Email" type="email" placeholder="Email" required>
+                <label class="block text-gray-700 text-sm font-bold mb-2">Emails</label>
+                {{template "value-rows" (rows "Email" .Emails)}}
+            </div>
+            <div class="mb-4">
+                <label class="block text-gray-700 text-sm font-bold mb-2">Phones</label>
+                {{template "value-rows" (rows "Phone" .Phones)}}
             </div>
             <div class="mb-4">
-                <label class="block text-gray-700 text-sm font-bold mb-2" for="phone">Phone</label>
-                <input class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline" id="phone" name="Phone" type="tel" placeholder="Phone" required>
+                <label class="block text-gray-700 text-sm font-bold mb-2">Addresses</label>
+                {{template "address-rows" .Addresses}}
+            </div>
+            <div class="mb-4"
+                 hx-get="/organizations/suggest"
+                 hx-trigger="change from:#email-rows"
+                 hx-include="closest form"
+                 hx-target="#org-field"
+                 hx-swap="outerHTML">
+                <label class="block text-gray-700 text-sm font-bold mb-2">Organization</label>
+                {{template "org-field" (orgChoice .Organization.ID)}}
+                <div class="grid grid-cols-2 gap-2 mt-2">
+                    <input class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline" name="JobTitle" type="text" value="{{.Organization.JobTitle}}" placeholder="Job title">
+                    <input class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline" name="Department" type="text" value="{{.Organization.Department}}" placeholder="Department">
+                </div>
             </div>
+            <div class="mb-4">
+                <label class="block text-gray-700 text-sm font-bold mb-2" for="birthday">Birthday</label>
+                <input class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline" id="birthday" name="Birthday" type="text" value="{{.Birthday.ISO}}" placeholder="YYYY-MM-DD, or MM-DD without the year" pattern="(\d{4}-|--)?\d{2}-\d{2}">
+            </div>
+            <div class="mb-4">
+                <label class="block text-gray-700 text-sm font-bold mb-2">Dates</label>
+                {{template "date-rows" .Dates}}
+            </div>
+            <div class="mb-4">
+                <label class="block text-gray-700 text-sm font-bold mb-2">Handles</label>
+                {{template "handle-rows" .Handles}}
+            </div>
+            <div class="mb-4">
+                <label class="block text-gray-700 text-sm font-bold mb-2" for="notes">Notes</label>
+                <textarea class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline" id="notes" name="Notes" rows="3" placeholder="Met at a conference, prefers email...">{{.Notes}}</textarea>
+            </div>
+            <div class="mb-4">
+                <label class="block text-gray-700 text-sm font-bold mb-2">Tags</label>
+                {{template "tag-input" .Tags}}
+            </div>
+            <div class="mb-4">
+                <label class="block text-gray-700 text-sm font-bold mb-2">Groups</label>
+                {{template "group-checks" .Groups}}
+            </div>
+            {{template "custom-fields" .Custom}}
             <div class="flex items-center justify-end">
                 <button type="button" hx-target="#contact-modal" hx-swap="outerHTML" hx-get="/modal/close" class="bg-gray-500 text-white font-bold py-2 px-4 rounded-lg shadow-md hover:bg-gray-600 transition-colors duration-300 mr-2">Cancel</button>
                 <button type="submit" class="bg-blue-600 text-white font-bold py-2 px-4 rounded-lg shadow-md hover:bg-blue-700 transition-colors duration-300">Save Contact</button>
@@ -179,7 +384,7 @@ var addModalHTML = `
 
 var editModalHTML = `
 <div id="contact-modal" class="fixed inset-0 bg-gray-600 bg-opacity-50 overflow-y-auto h-full w-full">
-    <div class="relative top-20 mx-auto p-5 border w-96 shadow-lg rounded-md bg-white">
+    <div class="relative top-20 mx-auto p-5 border w-full max-w-lg shadow-lg rounded-md bg-white">
         <div class="flex justify-end">
             <button hx-target="#contact-modal" hx-swap="outerHTML" hx-get="/modal/close" class="text-gray-400 hover:text-gray-600">&times;</button>
         </div>
@@ -188,15 +393,21 @@ var editModalHTML = `
               hx-put="/contacts/{{.ID}}"
               hx-target="#contact-{{.ID}}"
               hx-swap="outerHTML"
+              hx-encoding="multipart/form-data"
               hx-on::after-request="if(event.detail.successful) htmx.remove(htmx.find('#contact-modal'))">
             <input type="hidden" name="id" value="{{.ID}}">
+            <input type="hidden" name="Version" value="{{.Version}}">
             <div class="mb-4">
                 <label class="block text-gray-700 text-sm font-bold mb-2" for="contactType">Contact Type</label>
-                <select id="contactType" name="ContactType" class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline">
-                    <option value="Personal" {{if eq .ContactType "Personal"}}selected{{end}}>Personal</option>
-                    <option value="Work" {{if eq .ContactType "Work"}}selected{{end}}>Work</option>
-                    <option value="Family" {{if eq .ContactType "Family"}}selected{{end}}>Family</option>
-                </select>
+                {{template "type-select" .ContactType}}
+            </div>
+            <div class="mb-4">
+                <label class="block text-gray-700 text-sm font-bold mb-2" for="photo">Photo</label>
+                <div class="flex items-center gap-3">
+                    {{if .ID}}{{template "avatar" (avatar . 96 48)}}{{end}}
+                    <input id="photo" name="PhotoFile" type="file" accept="image/jpeg,image/png,image/gif" class="text-sm text-gray-700">
+                    {{if .Photo}}<label class="text-sm text-gray-700 whitespace-nowrap"><input type="checkbox" name="RemovePhoto" value="yes"> Remove</label>{{end}}
+                </div>
             </div>
             <div class="mb-4">
                 <label class="block text-gray-700 text-sm font-bold mb-2" for="firstName">First Name</label>
@@ -207,13 +418,55 @@ var editModalHTML = `
                 <input class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline" id="lastName" name="LastName" type="text" value="{{.LastName}}" required>
             </div>
             <div class="mb-4">
-                <label class="block text-gray-700 text-sm font-bold mb-2" for="email">Email</label>
-                <input class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline" id="email" name="Email" type="email" value="{{.Email}}" required>
+                <label class="block text-gray-700 text-sm font-bold mb-2">Emails</label>
+                {{template "value-rows" (rows "Email" .Emails)}}
+            </div>
+            <div class="mb-4">
+                <label class="block text-gray-700 text-sm font-bold mb-2">Phones</label>
+                {{template "value-rows" (rows "Phone" .Phones)}}
+            </div>
+            <div class="mb-4">
+                <label class="block text-gray-700 text-sm font-bold mb-2">Addresses</label>
+                {{template "address-rows" .Addresses}}
+            </div>
+            <div class="mb-4"
+                 hx-get="/organizations/suggest"
+                 hx-trigger="change from:#email-rows"
+                 hx-include="closest form"
+                 hx-target="#org-field"
+                 hx-swap="outerHTML">
+                <label class="block text-gray-700 text-sm font-bold mb-2">Organization</label>
+                {{template "org-field" (orgChoice .Organization.ID)}}
+                <div class="grid grid-cols-2 gap-2 mt-2">
+                    <input class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline" name="JobTitle" type="text" value="{{.Organization.JobTitle}}" placeholder="Job title">
+                    <input class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline" name="Department" type="text" value="{{.Organization.Department}}" placeholder="Department">
+                </div>
+            </div>
+            <div class="mb-4">
+                <label class="block text-gray-700 text-sm font-bold mb-2" for="birthday">Birthday</label>
+                <input class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline" id="birthday" name="Birthday" type="text" value="{{.Birthday.ISO}}" placeholder="YYYY-MM-DD, or MM-DD without the year" pattern="(\d{4}-|--)?\d{2}-\d{2}">
+            </div>
+            <div class="mb-4">
+                <label class="block text-gray-700 text-sm font-bold mb-2">Dates</label>
+                {{template "date-rows" .Dates}}
+            </div>
+            <div class="mb-4">
+                <label class="block text-gray-700 text-sm font-bold mb-2">Handles</label>
+                {{template "handle-rows" .Handles}}
+            </div>
+            <div class="mb-4">
+                <label class="block text-gray-700 text-sm font-bold mb-2" for="notes">Notes</label>
+                <textarea class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline" id="notes" name="Notes" rows="3" placeholder="Met at a conference, prefers email...">{{.Notes}}</textarea>
             </div>
             <div class="mb-4">
-                <label class="block text-gray-700 text-sm font-bold mb-2" for="phone">Phone</label>
-                <input class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline" id="phone" name="Phone" type="tel" value="{{.Phone}}" required>
+                <label class="block text-gray-700 text-sm font-bold mb-2">Tags</label>
+                {{template "tag-input" .Tags}}
             </div>
+            <div class="mb-4">
+                <label class="block text-gray-700 text-sm font-bold mb-2">Groups</label>
+                {{template "group-checks" .Groups}}
+            </div>
+            {{template "custom-fields" .Custom}}
             <div class="flex items-center justify-end">
                 <button type="button" hx-target="#contact-modal" hx-swap="outerHTML" hx-get="/modal/close" class="bg-gray-500 text-white font-bold py-2 px-4 rounded-lg shadow-md hover:bg-gray-600 transition-colors duration-300 mr-2">Cancel</button>
                 <button type="submit" class="bg-blue-600 text-white font-bold py-2 px-4 rounded-lg shadow-md hover:bg-blue-700 transition-colors duration-300">Save Changes</button>
@@ -225,16 +478,72 @@ var editModalHTML = `
 
 func renderCard(w http.ResponseWriter, c Contact) {
 	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("ETag", etag(c))
 	conCard.Execute(w, c)
 }
 
+// renderCardOOB writes a card that htmx swaps in place of the one on the
+// page, next to whatever the response is for
+func renderCardOOB(w http.ResponseWriter, c Contact) {
+	var card bytes.Buffer
+	if err := conCard.Execute(&card, c); err != nil {
+		fmt.Printf("Error rendering contact %s: %v\n", c.ID, err)
+		return
+	}
+	id := `id="contact-` + c.ID + `"`
+	fmt.Fprint(w, strings.Replace(card.String(), id, id+` hx-swap-oob="true"`, 1))
+}
+
+// renderContactListOOB writes the whole contact list for htmx to swap in
+// out of band
+func renderContactListOOB(w http.ResponseWriter) {
+	fmt.Fprint(w, `<div id="contact-list" class="grid gap-6 sm:grid-cols-1 md:grid-cols-2 lg:grid-cols-3" hx-swap-oob="true">`)
+	for _, contact := range store.List() {
+		conCard.Execute(w, contact)
+	}
+	fmt.Fprint(w, `</div>`)
+}
+
 func getContacts(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html")
 
+	//recently added, updated or frequently contacted views, otherwise every
+	//contact with favorites first
+	var contacts Contacts
+	view := r.URL.Query().Get("view")
+	switch view {
+	case "added", "updated":
+		contacts = store.Recent(view == "updated", recentLimit)
+	case "frequent":
+		contacts = usage.Frequent(store.List(), recentLimit, time.Now())
+	default:
+		contacts = pinFavorites(store.List())
+	}
+
+	//narrow to contacts carrying every tag asked for, and tick those tags in
+	//the filter above the list
+	tags := r.URL.Query()["tag"]
+	contacts = contacts.withTags(tags)
+	if r.Header.Get("HX-Request") != "" {
+		renderTagFilter(w, tags, true)
+	}
+
 	fmt.Printf("=== GET /contacts called ===\n")
 	fmt.Printf("Returning %d contacts to client\n", len(contacts))
 
 	// Check if the contacts slice is empty
+	if len(contacts) == 0 && view == "frequent" {
+		fmt.Fprintf(w, `<div class="flex items-center justify-center p-8 bg-gray-100 text-gray-500 rounded-lg shadow-md">
+            Nobody has been emailed or messaged from AFcb yet.
+        </div>`)
+		return
+	}
+	if len(contacts) == 0 && len(tags) > 0 {
+		fmt.Fprintf(w, `<div class="flex items-center justify-center p-8 bg-gray-100 text-gray-500 rounded-lg shadow-md">
+            No contacts carry all of the selected tags.
+        </div>`)
+		return
+	}
 	if len(contacts) == 0 {
 		fmt.Printf("No contacts found, returning empty message\n")
 		fmt.Fprintf(w, `<div class="flex items-center justify-center p-8 bg-gray-100 text-gray-500 rounded-lg shadow-md">
@@ -257,6 +566,104 @@ func getContacts(w http.ResponseWriter, r *http.Request) {
 	fmt.Printf("=== END GET /contacts ===\n")
 }
 
+// fields of a contact the add and edit modals send, each edit replacing all
+// of them. Photo is added when a new photo was uploaded or removed
+var contactFormFields = []string{
+	"ContactType", "FirstName", "LastName", "Emails", "Phones", "Addresses",
+	"Organization", "JobTitle", "Department", "Birthday", "Dates", "Handles",
+	"Notes", "Tags", "Groups", "CustomFields",
+}
+
+// contactFromForm reads and checks the add and edit modals, returning what
+// was entered as an update of every field they send
+func contactFromForm(r *http.Request) (ContactUpdate, error) {
+	c := Contact{
+		ContactType: r.FormValue("ContactType"),
+		FirstName:   r.FormValue("FirstName"),
+		LastName:    r.FormValue("LastName"),
+		Notes:       strings.TrimSpace(r.FormValue("Notes")),
+		Tags:        tagsFromForm(r),
+	}
+	if c.ContactType == "" || c.FirstName == "" || c.LastName == "" {
+		return ContactUpdate{}, errors.New("All fields are required")
+	}
+	var err error
+	if c.Emails, err = valuesFromForm(r, "Email"); err != nil {
+		return ContactUpdate{}, err
+	}
+	if c.Phones, err = valuesFromForm(r, "Phone"); err != nil {
+		return ContactUpdate{}, err
+	}
+	if err := validateValues(c.Emails, c.Phones); err != nil {
+		return ContactUpdate{}, err
+	}
+	if c.Addresses, err = addressesFromForm(r); err != nil {
+		return ContactUpdate{}, err
+	}
+	if c.Organization, err = orgLinkFromForm(r); err != nil {
+		return ContactUpdate{}, err
+	}
+	if c.Birthday, c.Dates, err = datesFromForm(r); err != nil {
+		return ContactUpdate{}, err
+	}
+	if c.Handles, err = handlesFromForm(r); err != nil {
+		return ContactUpdate{}, err
+	}
+	if c.Custom, err = customFromForm(r); err != nil {
+		return ContactUpdate{}, err
+	}
+	if c.Groups, err = groupsFromForm(r); err != nil {
+		return ContactUpdate{}, err
+	}
+
+	update := ContactUpdate{Fields: append([]string(nil), contactFormFields...)}
+	//a new photo is only read here, the store saves it with the edit
+	photo, upload, photoSent, err := photoFromForm(r)
+	if err != nil {
+		return ContactUpdate{}, err
+	}
+	if photoSent {
+		c.Photo, update.Upload = photo, upload
+		update.Fields = append(update.Fields, "Photo")
+	}
+	update.Contact = c
+	return update, nil
+}
+
+// saveUpdate saves an edit made in the edit modal and answers with the
+// card, or with the merge modal when the contact changed in the meantime
+func saveUpdate(w http.ResponseWriter, r *http.Request, id string, update ContactUpdate) {
+	//version the form was opened on, so a stale edit cannot overwrite a newer one
+	version, err := requestVersion(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	//update contact, store saves to file
+	c, err := store.Update(id, version, update, currentUser(r))
+	var stale *staleVersionError
+	if errors.As(err, &stale) {
+		fmt.Printf("Stale update of contact %s at version %d, now %d\n", id, version, stale.Current.Version)
+		if update.Upload != nil {
+			//the new photo was not saved, so there is none to offer
+			update.Fields = slices.DeleteFunc(slices.Clone(update.Fields), func(f string) bool { return f == "Photo" })
+		}
+		renderMergeModal(w, stale.Current, update)
+		return
+	}
+	if err != nil {
+		fmt.Println("Update error:", err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	//return updated contact, letting the tag filter pick up new tags
+	fmt.Printf("Contact updated and saved: %s\n", c.ID)
+	w.Header().Set("HX-Trigger", "tagsChanged")
+	renderCard(w, c)
+}
+
 func addContact(w http.ResponseWriter, r *http.Request) {
 	// make sure it's a POST request
 	if r.Method != http.MethodPost {
@@ -264,73 +671,34 @@ func addContact(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := r.ParseForm(); err != nil {
+	if err := parseContactForm(w, r); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	update, err := contactFromForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	id := r.FormValue("id")
-	contactType := r.FormValue("ContactType")
-	firstName := r.FormValue("FirstName")
-	lastName := r.FormValue("LastName")
-	email := r.FormValue("Email")
-	phone := r.FormValue("Phone")
-
-	fmt.Printf("Received form date - ID: '%s', Type: '%s', Name: '%s %s', Email: '%s', Phone: '%s'\n",
-		id, contactType, firstName, lastName, email, phone)
+	fmt.Printf("Received form data - ID: '%s', Type: '%s'\n", id, update.ContactType)
 
 	if id != "" {
 		//update existing contact
-		updates := map[string]string{
-			"ContactType": contactType,
-			"FirstName":   firstName,
-			"LastName":    lastName,
-			"Email":       email,
-			"Phone":       phone,
-		}
-
-		if err := contacts.Update(id, updates); err != nil {
-			http.Error(w, err.Error(), http.StatusNotFound)
-			return
-		}
-
-		//find and return updated contact
-		for _, c := range contacts {
-			if c.ID == id {
-				if err := contacts.SaveToFile(dataFile); err != nil {
-					http.Error(w, "Fail to save contacts: "+err.Error(), http.StatusInternalServerError)
-					return
-				}
-				fmt.Printf("Contact updated and save to file: %s\n", dataFile)
-				renderCard(w, c)
-				return
-			}
-		}
-		http.Error(w, "Contact not found after update", http.StatusNotFound)
+		saveUpdate(w, r, id, update)
 		return
 	}
 
-	//email validation
-	if !emailRegex.MatchString(email) {
-		http.Error(w, "Invalid email address input", http.StatusBadRequest)
-		return
-	}
-
-	//use New method
-	newContact, err := contacts.New(contactType, firstName, lastName, email, phone)
+	//use New method, store saves to file
+	newContact, err := store.New(update.Contact, update.Upload, currentUser(r))
 	if err != nil {
 		http.Error(w, "Fail to create contact: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-	fmt.Printf("New contact created with ID: %s. Total contact: %d\n", newContact.ID, len(contacts))
-
-	//save to file
-	if err := contacts.SaveToFile(dataFile); err != nil {
-		http.Error(w, "unable to save contacts: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-	fmt.Printf("Succesfully saved contact to file: %s\n", dataFile)
+	fmt.Printf("New contact created and saved with ID: %s\n", newContact.ID)
 
+	w.Header().Set("HX-Trigger", "tagsChanged")
 	renderCard(w, newContact)
 }
 
@@ -341,98 +709,48 @@ func updateContact(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := r.ParseForm(); err != nil {
+	if err := parseContactForm(w, r); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	vars := mux.Vars(r)
-	id := vars["id"]
-	fmt.Printf("UPDATE request received for id: %s, form values: %+v\n", id, r.Form)
-
-	// Validate required fields
-	contactType := r.FormValue("ContactType")
-	firstName := r.FormValue("FirstName")
-	lastName := r.FormValue("LastName")
-	email := r.FormValue("Email")
-	phone := r.FormValue("Phone")
-
-	if contactType == "" || firstName == "" || lastName == "" || email == "" || phone == "" {
-		http.Error(w, "All fields are required", http.StatusBadRequest)
-		return
-	}
-
-	// Email validation
-	if !emailRegex.MatchString(email) {
-		http.Error(w, "Invalid email address format", http.StatusBadRequest)
-		return
-	}
-
-	updates := map[string]string{
-		"ContactType": r.FormValue("ContactType"),
-		"FirstName":   r.FormValue("FirstName"),
-		"LastName":    r.FormValue("LastName"),
-		"Email":       r.FormValue("Email"),
-		"Phone":       r.FormValue("Phone"),
-	}
-
-	fmt.Printf("Attempting to update contact %s with: %+v\n", id, updates)
+	id := mux.Vars(r)["id"]
+	fmt.Printf("UPDATE request received for id: %s\n", id)
 
 	// find contact to ensure it exists
-	var found bool
-	for i := range contacts {
-		if contacts[i].ID == id {
-			found = true
-			break
-		}
-	}
-	if !found {
+	if _, err := store.Find(id); err != nil {
 		http.Error(w, "Contact not found", http.StatusNotFound)
 		return
 	}
 
-	//update contact
-	if err := contacts.Update(id, updates); err != nil {
-		fmt.Println("Update error:", err)
-		http.Error(w, err.Error(), http.StatusNotFound)
-		return
-	}
-
-	//save to file
-	if err := contacts.SaveToFile(dataFile); err != nil {
-		http.Error(w, "Fail to save contact to file: "+err.Error(), http.StatusInternalServerError)
+	update, err := contactFromForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	//return updated contact
-	for _, c := range contacts {
-		if c.ID == id {
-			fmt.Printf("Successfully update contact: %+v\n", c)
-			renderCard(w, c)
-			return
-		}
-	}
-
-	http.Error(w, "contact not found after update", http.StatusNotFound)
+	saveUpdate(w, r, id, update)
 }
 
 func deleteContact(w http.ResponseWriter, r *http.Request) {
 	id := mux.Vars(r)["id"]
 	fmt.Println("DELETE request received for id:", id)
 
-	if err := contacts.Delete(id); err != nil {
+	if _, err := store.Find(id); err != nil {
 		fmt.Println("Delete error:", err)
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
-	if err := contacts.SaveToFile(dataFile); err != nil {
+	//move to trash
+	contact, err := store.Delete(id, currentUser(r))
+	if err != nil {
 		http.Error(w, "failed to save contacts: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// return empty content - HTMX remove element
-	w.WriteHeader(http.StatusOK)
+	// replace card with undo toast
+	w.Header().Set("Content-Type", "text/html")
+	deletedToast.Execute(w, contact)
 }
 
 func searchContacts(w http.ResponseWriter, r *http.Request) {
@@ -444,7 +762,7 @@ func searchContacts(w http.ResponseWriter, r *http.Request) {
 	if keyword == "" {
 		fmt.Println("No keyword provided, returning all contacts") //log
 
-		for _, c := range contacts {
+		for _, c := range pinFavorites(store.List()) {
 			if err := conCard.Execute(w, c); err != nil {
 				http.Error(w, "Error rendering template: "+err.Error(), http.StatusInternalServerError)
 				return
@@ -453,7 +771,7 @@ func searchContacts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	results := contacts.Search(keyword)
+	results := pinFavorites(store.Search(keyword))
 	fmt.Printf("Found %d results for keyword '%s'\n", len(results), keyword) //log
 
 	if len(results) == 0 {
@@ -473,20 +791,23 @@ func searchContacts(w http.ResponseWriter, r *http.Request) {
 // add modal render the add contact form modal
 func addModal(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html")
-	tmpl := template.Must(template.New("modal").Parse(addModalHTML))
-	tmpl.Execute(w, nil)
+	tmpl := modalTemplate("modal", addModalHTML)
+	tmpl.Execute(w, Contact{
+		Emails: []ContactValue{{Label: "home", Primary: true}},
+		Phones: []ContactValue{{Label: "mobile", Primary: true}},
+	})
 }
 
 func editModal(w http.ResponseWriter, r *http.Request) {
 	id := mux.Vars(r)["id"]
-	contact, err := contacts.Find(id)
+	contact, err := store.Find(id)
 	if err != nil {
 		http.Error(w, "Contact not found", http.StatusNotFound)
 		return
 	}
 
 	w.Header().Set("Content-Type", "text/html")
-	tmpl := template.Must(template.New("edit-modal").Parse(editModalHTML))
+	tmpl := modalTemplate("edit-modal", editModalHTML)
 	tmpl.Execute(w, contact)
 }
 
@@ -495,15 +816,105 @@ func closeForm(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// namedSidecar is a sidecar store with what it holds, for messages
+type namedSidecar struct {
+	what  string
+	store sidecarStore
+}
+
+// openSidecars points the organization, type, field, group, usage, quick
+// action and photo stores at the companion files of spec, returning the
+// ones the caller still has to load
+func openSidecars(spec string) []namedSidecar {
+	orgs = NewOrgStore(sidecarPath(spec, "organizations"))
+	contactTypes = NewTypeStore(sidecarPath(spec, "types"))
+	customFields = NewFieldStore(sidecarPath(spec, "fields"))
+	groups = NewGroupStore(sidecarPath(spec, "groups"))
+	usage = NewUsageStore(sidecarPath(spec, "usage"))
+	quickActions = NewActionStore(sidecarPath(spec, "actions"))
+	photos = NewPhotoStore(photoDir(spec))
+	return []namedSidecar{
+		{"organizations", orgs},
+		{"contact types", contactTypes},
+		{"custom fields", customFields},
+		{"groups", groups},
+		{"usage", usage},
+		{"quick actions", quickActions},
+	}
+}
+
 func main() {
-	//initialize contacts
-	contacts = Contacts{}
+	//subcommands such as migrate run instead of the server
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		if err := runCommand(os.Args[1], os.Args[2:]); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	storageSpec := flag.String("storage", "json:"+dataFile, "storage backend: json:FILE, journal:FILE, dir:DIR or memory")
+	flag.Int64Var(&journalMaxSize, "journal-max-size", journalMaxSize, "compact the journal once it reaches this many bytes")
+	flag.DurationVar(&journalMaxAge, "journal-max-age", journalMaxAge, "compact the journal once its oldest record is this old")
+	keyFile := flag.String("key-file", "", "file holding a 32 byte key to encrypt contact data, see keygen")
+	passphraseEnv := flag.String("passphrase-env", "AFCB_PASSPHRASE", "environment variable holding a passphrase to encrypt contact data")
+	flag.IntVar(&historyMaxRevisions, "history-max-revisions", historyMaxRevisions, "revisions kept per contact, 0 keeps them all")
+	flag.IntVar(&trashRetentionDays, "trash-retention", trashRetentionDays, "days before trashed contacts are purged, 0 keeps them forever")
+	flag.StringVar(&snapshotDir, "snapshot-dir", snapshotDir, "directory point in time snapshots are written to")
+	flag.DurationVar(&snapshotInterval, "snapshot-interval", snapshotInterval, "how often to take a snapshot, 0 turns scheduled snapshots off")
+	flag.StringVar(&snapshotKeep, "snapshot-keep", snapshotKeep, "snapshots to keep per hour (h), day (d), week (w), month (m) and year (y)")
+	flag.DurationVar(&watchInterval, "watch-interval", watchInterval, "how often to check the data file for outside changes, 0 turns it off")
+	flag.Parse()
+
+	//unlock encrypted data when a key or passphrase is given
+	source, encrypted, err := loadKeySource(*keyFile, *passphraseEnv)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	if encrypted {
+		dataKeys = newKeyring(source)
+		fmt.Println("Contact data is encrypted at rest")
+	}
 
-	//load contacts from file
-	if err := contacts.LoadContacts(dataFile); err != nil {
+	//initialize contact store with chosen backend
+	storage, err := openStorage(*storageSpec)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	store = NewContactStore(storage, NewHistoryStore(sidecarPath(*storageSpec, "history")))
+	sidecars := openSidecars(*storageSpec)
+
+	//load contacts, refusing to start rather than overwrite data we could not read
+	if err := store.Load(); err != nil {
 		fmt.Printf("Error loading contacts: %v\n", err)
-		fmt.Println("Starting with empty contacts list")
-		contacts = Contacts{}
+		fmt.Printf("Not starting so %s is not overwritten. Repair or restore it, then try again.\n", *storageSpec)
+		os.Exit(1)
+	}
+	for _, sidecar := range sidecars {
+		if err := sidecar.store.Load(); err != nil {
+			fmt.Printf("Error loading %s: %v\n", sidecar.what, err)
+			os.Exit(1)
+		}
+	}
+
+	//purge old contacts from the trash in the background
+	startTrashPurger()
+
+	//take point in time snapshots on a schedule
+	if _, err := parseRetention(snapshotKeep); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	startSnapshots()
+
+	//reload the data file when it is changed outside AFcb
+	store.Watch(watchInterval)
+
+	//fold the journal into the snapshot in the background
+	if journal, ok := storage.(*JournalStorage); ok {
+		journal.StartCompactor(time.Minute)
 	}
 
 	router := mux.NewRouter()
@@ -533,9 +944,66 @@ func main() {
 	authRouter.HandleFunc("/modal/add", addModal).Methods("GET")
 	authRouter.HandleFunc("/modal/edit/{id}", editModal).Methods("GET")
 	authRouter.HandleFunc("/modal/close", closeForm).Methods("GET")
+	authRouter.HandleFunc("/modal/row/email", valueRowView).Methods("GET")
+	authRouter.HandleFunc("/modal/row/phone", valueRowView).Methods("GET")
+	authRouter.HandleFunc("/modal/row/address", addressRowView).Methods("GET")
+	authRouter.HandleFunc("/modal/row/date", dateRowView).Methods("GET")
+	authRouter.HandleFunc("/modal/row/tag", tagChipView).Methods("GET")
+	authRouter.HandleFunc("/modal/row/handle", handleRowView).Methods("GET")
+	authRouter.HandleFunc("/contacts/{id}", getContact).Methods("GET")
 	authRouter.HandleFunc("/contacts/{id}", updateContact).Methods("PUT", "PATCH")
 	authRouter.HandleFunc("/contacts/{id}", deleteContact).Methods("DELETE")
+	authRouter.HandleFunc("/contacts/{id}/history", contactHistory).Methods("GET")
+	authRouter.HandleFunc("/contacts/{id}/detail", contactDetail).Methods("GET")
+	authRouter.HandleFunc("/contacts/{id}/timeline", addInteraction).Methods("POST")
+	authRouter.HandleFunc("/contacts/{id}/timeline/{entry}", deleteInteraction).Methods("DELETE")
+	authRouter.HandleFunc("/trash", trashView).Methods("GET")
+	authRouter.HandleFunc("/trash/{id}/restore", undeleteContact).Methods("POST")
+	authRouter.HandleFunc("/trash/{id}", purgeContact).Methods("DELETE")
+	authRouter.HandleFunc("/contacts/{id}/history/{rev}/restore", restoreRevision).Methods("POST")
+	authRouter.HandleFunc("/conflicts", conflictsView).Methods("GET")
+	authRouter.HandleFunc("/conflicts/banner", conflictBannerView).Methods("GET")
+	authRouter.HandleFunc("/conflicts/{id}/resolve", resolveConflict).Methods("POST")
+	authRouter.HandleFunc("/admin/snapshots", snapshotsView).Methods("GET")
+	authRouter.HandleFunc("/admin/snapshots", createSnapshot).Methods("POST")
+	authRouter.HandleFunc("/admin/snapshots/{name}", previewSnapshot).Methods("GET")
+	authRouter.HandleFunc("/admin/snapshots/{name}/restore", restoreSnapshot).Methods("POST")
 	authRouter.HandleFunc("/search", searchContacts).Methods("GET")
+	authRouter.HandleFunc("/upcoming", upcomingView).Methods("GET")
+	authRouter.HandleFunc("/tags", tagsView).Methods("GET")
+	authRouter.HandleFunc("/contacts/{id}/favorite", toggleFavorite).Methods("POST")
+	authRouter.HandleFunc("/contacts/{id}/used", recordUse).Methods("POST")
+	authRouter.HandleFunc("/contacts/{id}/relations", addRelation).Methods("POST")
+	authRouter.HandleFunc("/contacts/{id}/relations", deleteRelation).Methods("DELETE")
+	authRouter.HandleFunc("/settings", settingsView).Methods("GET")
+	authRouter.HandleFunc("/photos/{id}/{size}", servePhoto).Methods("GET")
+	authRouter.HandleFunc("/settings/types", saveType).Methods("POST")
+	authRouter.HandleFunc("/settings/types/{id}", saveType).Methods("PUT")
+	authRouter.HandleFunc("/settings/types/{id}", deleteType).Methods("DELETE")
+	authRouter.HandleFunc("/settings/actions", saveActions).Methods("PUT")
+	authRouter.HandleFunc("/settings/fields", saveField).Methods("POST")
+	authRouter.HandleFunc("/settings/fields/{id}", saveField).Methods("PUT")
+	authRouter.HandleFunc("/settings/fields/{id}", deleteField).Methods("DELETE")
+	authRouter.HandleFunc("/tags/filter", tagFilterView).Methods("GET")
+	authRouter.HandleFunc("/tags/rename", renameTag).Methods("POST")
+	authRouter.HandleFunc("/tags/delete", deleteTag).Methods("POST")
+	authRouter.HandleFunc("/groups", groupsView).Methods("GET")
+	authRouter.HandleFunc("/groups", saveGroup).Methods("POST")
+	authRouter.HandleFunc("/groups/{id}", groupView).Methods("GET")
+	authRouter.HandleFunc("/groups/{id}", saveGroup).Methods("PUT")
+	authRouter.HandleFunc("/groups/{id}", deleteGroup).Methods("DELETE")
+	authRouter.HandleFunc("/groups/{id}/members", addMember).Methods("POST")
+	authRouter.HandleFunc("/groups/{id}/members/{contact}", removeMember).Methods("DELETE")
+	authRouter.HandleFunc("/groups/{id}/export.vcf", exportGroup).Methods("GET")
+	authRouter.HandleFunc("/groups/{id}/export.csv", exportGroup).Methods("GET")
+	authRouter.HandleFunc("/organizations", organizationsView).Methods("GET")
+	authRouter.HandleFunc("/organizations", saveOrganization).Methods("POST")
+	authRouter.HandleFunc("/organizations/suggest", suggestOrg).Methods("GET")
+	authRouter.HandleFunc("/organizations/{id}", organizationView).Methods("GET")
+	authRouter.HandleFunc("/organizations/{id}", saveOrganization).Methods("PUT")
+	authRouter.HandleFunc("/organizations/{id}", deleteOrganization).Methods("DELETE")
+	authRouter.HandleFunc("/export.vcf", exportContacts).Methods("GET")
+	authRouter.HandleFunc("/export.csv", exportContacts).Methods("GET")
 
 	//server start
 	fmt.Println("AFcb started at http://localhost:1330")
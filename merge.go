@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// etag of a contact, changing whenever its version does
+func etag(c Contact) string {
+	return fmt.Sprintf(`"%d"`, c.Version)
+}
+
+// requestVersion returns the contact version an edit was made against,
+// from an If-Match header or the form's Version field. 0 means any version
+func requestVersion(r *http.Request) (int, error) {
+	value := r.FormValue("Version")
+	if match := strings.TrimSpace(r.Header.Get("If-Match")); match != "" {
+		if match == "*" {
+			return 0, nil
+		}
+		value = strings.Trim(strings.TrimPrefix(match, "W/"), `"`)
+	}
+	if value == "" {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(value)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("invalid version %q", value)
+	}
+	return version, nil
+}
+
+// getContact renders one contact card with its ETag, answering 304 when
+// the client already has the current version
+func getContact(w http.ResponseWriter, r *http.Request) {
+	contact, err := store.Find(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Contact not found", http.StatusNotFound)
+		return
+	}
+	if r.Header.Get("If-None-Match") == etag(contact) {
+		w.Header().Set("ETag", etag(contact))
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	renderCard(w, contact)
+}
+
+// mergeField is one edited field next to the value saved in the meantime.
+// Mine and Theirs are shown, MineValue and TheirsValue are submitted
+type mergeField struct {
+	Name        string // form field name
+	Label       string
+	Mine        string
+	MineValue   string
+	Theirs      string
+	TheirsValue string
+}
+
+// mergeFields pairs a submitted update with the saved contact's values
+func mergeFields(current Contact, update ContactUpdate) []mergeField {
+	//apply the update to a copy to show it the way the saved values are
+	edited := Contacts{current}
+	if err := edited.Update(current.ID, update, ""); err != nil {
+		fmt.Printf("Error applying updates for merge: %v\n", err)
+	}
+	theirs := current.fields()
+	var fields []mergeField
+	for i, f := range edited[0].fields() {
+		name := strings.ReplaceAll(f.Name, " ", "")
+		if !update.Has(name) {
+			continue
+		}
+		fields = append(fields, mergeField{
+			Name:        name,
+			Label:       f.Name,
+			Mine:        f.Value,
+			MineValue:   f.formValue(),
+			Theirs:      theirs[i].Value,
+			TheirsValue: theirs[i].formValue(),
+		})
+	}
+	return fields
+}
+
+var mergeModalHTML = `
+<div id="contact-modal" class="fixed inset-0 bg-gray-600 bg-opacity-50 overflow-y-auto h-full w-full">
+    <div class="relative top-20 mx-auto p-5 border w-full max-w-lg shadow-lg rounded-md bg-white">
+        <div class="flex justify-end">
+            <button hx-target="#contact-modal" hx-swap="outerHTML" hx-get="/modal/close" class="text-gray-400 hover:text-gray-600">&times;</button>
+        </div>
+        <h3 class="text-xl font-bold mb-1">Contact changed while you were editing</h3>
+        <p class="text-sm text-gray-500 mb-4">
+            {{with .Latest}}{{if .By}}{{.By}}{{else}}Someone{{end}} saved a newer version at {{.At.Format "15:04"}}.{{else}}A newer version was saved.{{end}}
+            Pick which value to keep for each field that differs.
+        </p>
+        <form id="contactForm"
+              hx-put="/contacts/{{.Current.ID}}"
+              hx-target="#contact-{{.Current.ID}}"
+              hx-swap="outerHTML"
+              hx-on::after-request="if(event.detail.successful) htmx.remove(htmx.find('#contact-modal'))">
+            <input type="hidden" name="id" value="{{.Current.ID}}">
+            <input type="hidden" name="Version" value="{{.Current.Version}}">
+            {{range .Fields}}
+            {{if eq .MineValue .TheirsValue}}
+            <input type="hidden" name="{{.Name}}" value="{{.MineValue}}">
+            {{else}}
+            <fieldset class="mb-4">
+                <legend class="block text-gray-700 text-sm font-bold mb-2">{{.Label}}</legend>
+                <label class="flex items-center p-2 mb-1 border rounded-lg hover:bg-blue-50">
+                    <input type="radio" name="{{.Name}}" value="{{.MineValue}}" class="mr-2" required>
+                    <span class="text-xs text-gray-500 w-16">Yours</span>
+                    <span class="text-gray-800">{{.Mine}}</span>
+                </label>
+                <label class="flex items-center p-2 border rounded-lg hover:bg-blue-50">
+                    <input type="radio" name="{{.Name}}" value="{{.TheirsValue}}" class="mr-2" required>
+                    <span class="text-xs text-gray-500 w-16">Saved</span>
+                    <span class="text-gray-800">{{.Theirs}}</span>
+                </label>
+            </fieldset>
+            {{end}}
+            {{end}}
+            <div class="flex items-center justify-end">
+                <button type="button" hx-target="#contact-modal" hx-swap="outerHTML" hx-get="/modal/close" class="bg-gray-500 text-white font-bold py-2 px-4 rounded-lg shadow-md hover:bg-gray-600 transition-colors duration-300 mr-2">Cancel</button>
+                <button type="submit" class="bg-blue-600 text-white font-bold py-2 px-4 rounded-lg shadow-md hover:bg-blue-700 transition-colors duration-300">Save Merged</button>
+            </div>
+        </form>
+    </div>
+</div>
+`
+
+var mergeModal = template.Must(template.New("merge-modal").Parse(mergeModalHTML))
+
+// renderMergeModal answers a stale edit with 409 and a modal to merge the
+// submitted values with the saved ones, shown in place of the edit modal
+func renderMergeModal(w http.ResponseWriter, current Contact, update ContactUpdate) {
+	var latest *Revision
+	if revs := store.History(current.ID); len(revs) > 0 {
+		latest = &revs[len(revs)-1]
+	}
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("ETag", etag(current))
+	w.Header().Set("HX-Retarget", "#modal-container")
+	w.Header().Set("HX-Reswap", "innerHTML")
+	w.WriteHeader(http.StatusConflict)
+	err := mergeModal.Execute(w, map[string]any{
+		"Current": current,
+		"Latest":  latest,
+		"Fields":  mergeFields(current, update),
+	})
+	if err != nil {
+		fmt.Printf("Error rendering merge modal: %v\n", err)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// useTestStore points the handlers at a test store for the length of a test
+func useTestStore(t *testing.T, contacts ...Contact) *ContactStore {
+	t.Helper()
+	old := store
+	store = newTestStore(t, contacts...)
+	t.Cleanup(func() { store = old })
+	return store
+}
+
+func TestStaleEditRejected(t *testing.T) {
+	alice := Contact{ID: "alice", Version: 1, ContactType: "Work", FirstName: "Alice", LastName: "Smith",
+		Emails: []ContactValue{{Label: "other", Value: "alice@example.com", Primary: true}},
+		Phones: []ContactValue{{Label: "mobile", Value: "555", Primary: true}},
+	}
+	edit := func(name string) url.Values {
+		return url.Values{
+			"ContactType": {"Work"},
+			"FirstName":   {name},
+			"LastName":    {"Smith"},
+			"Email":       {"alice@example.com"},
+			"Phone":       {"555"},
+			"Version":     {"1"},
+		}
+	}
+	tests := []struct {
+		name string
+		send func(form url.Values) *httptest.ResponseRecorder
+	}{
+		{
+			name: "edit modal",
+			send: func(form url.Values) *httptest.ResponseRecorder {
+				r := httptest.NewRequest(http.MethodPut, "/contacts/alice", strings.NewReader(form.Encode()))
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				r = mux.SetURLVars(r, map[string]string{"id": "alice"})
+				w := httptest.NewRecorder()
+				updateContact(w, r)
+				return w
+			},
+		},
+		{
+			name: "add modal with an id",
+			send: func(form url.Values) *httptest.ResponseRecorder {
+				form.Set("id", "alice")
+				r := httptest.NewRequest(http.MethodPost, "/contacts", strings.NewReader(form.Encode()))
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				w := httptest.NewRecorder()
+				addContact(w, r)
+				return w
+			},
+		},
+		{
+			name: "if-match header",
+			send: func(form url.Values) *httptest.ResponseRecorder {
+				form.Del("Version")
+				r := httptest.NewRequest(http.MethodPut, "/contacts/alice", strings.NewReader(form.Encode()))
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				r.Header.Set("If-Match", `"1"`)
+				r = mux.SetURLVars(r, map[string]string{"id": "alice"})
+				w := httptest.NewRecorder()
+				updateContact(w, r)
+				return w
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := useTestStore(t, alice)
+
+			//two edits opened on version 1, the first one saved wins
+			if w := tt.send(edit("Alicia")); w.Code != http.StatusOK {
+				t.Fatalf("first edit answered %d: %s", w.Code, w.Body)
+			}
+			w := tt.send(edit("Ally"))
+			if w.Code != http.StatusConflict {
+				t.Fatalf("stale edit answered %d, want %d", w.Code, http.StatusConflict)
+			}
+			if w.Header().Get("ETag") != `"2"` || !strings.Contains(w.Body.String(), "Ally") || !strings.Contains(w.Body.String(), "Alicia") {
+				t.Errorf("merge modal with ETag %s does not show both edits: %s", w.Header().Get("ETag"), w.Body)
+			}
+
+			saved, err := s.Find("alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if saved.FirstName != "Alicia" || saved.Version != 2 {
+				t.Errorf("stale edit changed the contact to %q at version %d", saved.FirstName, saved.Version)
+			}
+		})
+	}
+}
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Organization is a company or other body contacts work for
+type Organization struct {
+	ID        string
+	Name      string
+	Domain    string  `json:",omitempty"` // email domain, such as drofylla.com
+	Phone     string  `json:",omitempty"`
+	Address   Address `json:",omitzero"`
+	Notes     string  `json:",omitempty"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// OrgLink ties a contact to an organization, with the contact's role there
+type OrgLink struct {
+	ID         string `json:",omitempty"` // organization ID
+	JobTitle   string `json:",omitempty"`
+	Department string `json:",omitempty"`
+}
+
+// Role describes the job title and department, such as "CTO, Engineering"
+func (l OrgLink) Role() string {
+	var parts []string
+	for _, part := range []string{l.JobTitle, l.Department} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// OrgStore keeps organizations in a file beside the contact data. an empty
+// filename keeps them in memory only
+type OrgStore struct {
+	mu       sync.RWMutex
+	filename string
+	orgs     []Organization
+}
+
+// organizations shared by the handlers
+var orgs = NewOrgStore("")
+
+func NewOrgStore(filename string) *OrgStore {
+	return &OrgStore{filename: filename}
+}
+
+func (o *OrgStore) Load() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.filename == "" {
+		return nil
+	}
+	var loaded []Organization
+	if err := loadJSONFile(o.filename, &loaded); err != nil {
+		return err
+	}
+	o.orgs = loaded
+	return nil
+}
+
+// save writes the organizations file, the caller holding the lock
+func (o *OrgStore) save() error {
+	if o.filename == "" {
+		return nil
+	}
+	return saveJSONFile(o.filename, o.orgs)
+}
+
+// Save writes the organizations file again, used when the data key changes
+func (o *OrgStore) Save() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.save()
+}
+
+// List returns the organizations sorted by name
+func (o *OrgStore) List() []Organization {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	list := append([]Organization(nil), o.orgs...)
+	sort.Slice(list, func(i, k int) bool {
+		return strings.ToLower(list[i].Name) < strings.ToLower(list[k].Name)
+	})
+	return list
+}
+
+func (o *OrgStore) Find(id string) (Organization, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	for _, org := range o.orgs {
+		if org.ID == id {
+			return org, nil
+		}
+	}
+	return Organization{}, fmt.Errorf("No organization found with id %s", id)
+}
+
+// Put adds an organization without an ID or replaces the one with its ID
+func (o *OrgStore) Put(org Organization) (Organization, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	org.Name = strings.TrimSpace(org.Name)
+	if org.Name == "" {
+		return Organization{}, errors.New("Organization name is required")
+	}
+	org.Domain = normalizeDomain(org.Domain)
+	org.UpdatedAt = time.Now()
+
+	before := append([]Organization(nil), o.orgs...)
+	if org.ID == "" {
+		id, err := genID()
+		if err != nil {
+			return Organization{}, err
+		}
+		org.ID, org.CreatedAt = id, org.UpdatedAt
+		o.orgs = append(o.orgs, org)
+	} else {
+		found := false
+		for i := range o.orgs {
+			if o.orgs[i].ID == org.ID {
+				org.CreatedAt = o.orgs[i].CreatedAt
+				o.orgs[i] = org
+				found = true
+			}
+		}
+		if !found {
+			return Organization{}, fmt.Errorf("No organization found with id %s", org.ID)
+		}
+	}
+	if err := o.save(); err != nil {
+		o.orgs = before
+		return Organization{}, err
+	}
+	return org, nil
+}
+
+func (o *OrgStore) Delete(id string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for i, org := range o.orgs {
+		if org.ID == id {
+			before := append([]Organization(nil), o.orgs...)
+			o.orgs = append(o.orgs[:i:i], o.orgs[i+1:]...)
+			if err := o.save(); err != nil {
+				o.orgs = before
+				return err
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("No organization found with id %s", id)
+}
+
+// ForEmail returns the organization whose domain matches an email address,
+// subdomains included, so a@mail.acme.com matches acme.com
+func (o *OrgStore) ForEmail(email string) (Organization, bool) {
+	_, domain, ok := strings.Cut(strings.ToLower(strings.TrimSpace(email)), "@")
+	if !ok || domain == "" {
+		return Organization{}, false
+	}
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	for _, org := range o.orgs {
+		if org.Domain != "" && (domain == org.Domain || strings.HasSuffix(domain, "."+org.Domain)) {
+			return org, true
+		}
+	}
+	return Organization{}, false
+}
+
+// normalizeDomain turns "https://www.Acme.com/" or "@acme.com" into acme.com
+func normalizeDomain(domain string) string {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	if u, err := url.Parse(domain); err == nil && u.Host != "" {
+		domain = u.Host
+	}
+	domain = strings.TrimPrefix(domain, "@")
+	domain = strings.TrimPrefix(domain, "www.")
+	return strings.TrimSuffix(domain, "/")
+}
+
+// orgName returns the name of an organization for display, its ID when it
+// no longer exists
+func orgName(id string) string {
+	if id == "" {
+		return ""
+	}
+	if org, err := orgs.Find(id); err == nil {
+		return org.Name
+	}
+	return id
+}
+
+// members returns the contacts outside the trash linked to an organization
+func (c Contacts) members(orgID string) Contacts {
+	var out Contacts
+	for _, contact := range c.active() {
+		if contact.Organization.ID == orgID {
+			out = append(out, contact)
+		}
+	}
+	return out
+}
+
+var orgFieldHTML = `{{define "org-field"}}
+<div id="org-field">
+    <select name="Organization" class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline">
+        <option value="">None</option>
+        {{range organizations}}<option value="{{.ID}}" {{if eq .ID $.ID}}selected{{end}}>{{.Name}}</option>{{end}}
+    </select>
+    {{if .Suggested}}<p class="text-xs text-green-700 mt-1">Suggested from the email domain {{.Suggested}}</p>{{end}}
+</div>
+{{end}}`
+
+// orgChoice is the organization picked in a contact modal. Suggested is
+// the domain an organization was suggested for
+type orgChoice struct {
+	ID        string
+	Suggested string
+}
+
+var orgFuncs = template.FuncMap{
+	"organizations": func() []Organization { return orgs.List() },
+	"orgChoice":     func(id string) orgChoice { return orgChoice{ID: id} },
+}
+
+// orgLinkFromForm reads the organization picker, job title and department
+// of the add and edit modals
+func orgLinkFromForm(r *http.Request) (OrgLink, error) {
+	link := OrgLink{
+		ID:         r.FormValue("Organization"),
+		JobTitle:   strings.TrimSpace(r.FormValue("JobTitle")),
+		Department: strings.TrimSpace(r.FormValue("Department")),
+	}
+	if link.ID != "" {
+		if _, err := orgs.Find(link.ID); err != nil {
+			return OrgLink{}, err
+		}
+	}
+	return link, nil
+}
+
+// suggestOrg re-renders the organization picker of a contact modal when an
+// email changes, picking the organization whose domain matches the first
+// email as long as none has been picked yet
+func suggestOrg(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	choice := orgChoice{ID: r.FormValue("Organization")}
+	if choice.ID == "" {
+		//only a suggestion, so emails that cannot be read suggest nothing
+		emails, _ := valuesFromForm(r, "Email")
+		for _, email := range emails {
+			if org, ok := orgs.ForEmail(email.Value); ok {
+				choice = orgChoice{ID: org.ID, Suggested: org.Domain}
+				break
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "text/html")
+	if err := valueRowTemplate.ExecuteTemplate(w, "org-field", choice); err != nil {
+		fmt.Printf("Error rendering organization field: %v\n", err)
+	}
+}
+
+var orgsModalHTML = `
+<div id="contact-modal" class="fixed inset-0 bg-gray-600 bg-opacity-50 overflow-y-auto h-full w-full">
+    <div class="relative top-20 mx-auto p-5 border w-full max-w-2xl shadow-lg rounded-md bg-white">
+        <div class="flex justify-end">
+            <button hx-target="#contact-modal" hx-swap="outerHTML" hx-get="/modal/close" class="text-gray-400 hover:text-gray-600">&times;</button>
+        </div>
+        <h3 class="text-xl font-bold mb-4">Companies</h3>
+        {{if not .Orgs}}
+        <div class="p-4 mb-4 bg-gray-100 text-gray-500 rounded-lg">No companies yet.</div>
+        {{end}}
+        {{range .Orgs}}
+        <button class="w-full flex justify-between items-center p-3 mb-2 border rounded-lg hover:border-blue-500 hover:bg-blue-50 transition-colors text-left"
+            hx-get="/organizations/{{.ID}}"
+            hx-target="#modal-container"
+            hx-swap="innerHTML">
+            <span>
+                <strong class="text-gray-800">{{.Name}}</strong>
+                {{if .Domain}}<span class="ml-2 text-sm text-gray-500">{{.Domain}}</span>{{end}}
+            </span>
+            {{$n := index $.Counts .ID}}
+            <span class="text-sm text-gray-500">{{$n}} {{if eq $n 1}}person{{else}}people{{end}}</span>
+        </button>
+        {{end}}
+        <h4 class="text-lg font-bold mt-6 mb-2">New company</h4>
+        {{template "org-form" .New}}
+    </div>
+</div>
+`
+
+var orgModalHTML = `
+<div id="contact-modal" class="fixed inset-0 bg-gray-600 bg-opacity-50 overflow-y-auto h-full w-full">
+    <div class="relative top-20 mx-auto p-5 border w-full max-w-2xl shadow-lg rounded-md bg-white">
+        <div class="flex justify-between">
+            <button hx-get="/organizations" hx-target="#modal-container" hx-swap="innerHTML" class="text-sm text-blue-600 hover:underline">&larr; All companies</button>
+            <button hx-target="#contact-modal" hx-swap="outerHTML" hx-get="/modal/close" class="text-gray-400 hover:text-gray-600">&times;</button>
+        </div>
+        {{with .Org}}
+        <h3 class="text-xl font-bold mt-2">{{.Name}}</h3>
+        <div class="text-sm text-gray-600 mb-4">
+            {{if .Domain}}<div>{{.Domain}}</div>{{end}}
+            {{if .Phone}}<div>{{.Phone}}</div>{{end}}
+            {{if not .Address.Empty}}<address class="not-italic">{{range .Address.Lines}}{{.}}<br>{{end}}</address>{{end}}
+            {{if .Notes}}<p class="mt-2 whitespace-pre-line">{{.Notes}}</p>{{end}}
+        </div>
+        {{end}}
+        <h4 class="text-lg font-bold mb-2">People</h4>
+        {{if not .Members}}
+        <div class="p-4 mb-4 bg-gray-100 text-gray-500 rounded-lg">Nobody is linked to this company yet.</div>
+        {{end}}
+        <table class="w-full text-sm mb-4">
+            {{range .Members}}
+            <tr class="border-t">
+                <td class="py-1 pr-2 font-medium text-gray-800">{{.FirstName}} {{.LastName}}</td>
+                <td class="py-1 pr-2 text-gray-600">{{.Organization.Role}}</td>
+                <td class="py-1 pr-2 text-gray-600">{{.PrimaryEmail}}</td>
+                <td class="py-1 text-gray-600">{{.PrimaryPhone}}</td>
+            </tr>
+            {{end}}
+        </table>
+        <details class="mb-2">
+            <summary class="cursor-pointer text-sm text-blue-600">Edit company</summary>
+            <div class="mt-2">{{template "org-form" .Org}}</div>
+        </details>
+        {{if not .Members}}
+        <button class="px-3 py-1 text-sm rounded-lg border border-gray-300 hover:border-red-500 hover:bg-red-50 transition-colors"
+            hx-delete="/organizations/{{.Org.ID}}"
+            hx-target="#modal-container"
+            hx-swap="innerHTML"
+            hx-confirm="Delete this company?">
+            Delete company
+        </button>
+        {{end}}
+    </div>
+</div>
+`
+
+var orgFormHTML = `{{define "org-form"}}
+<form {{if .ID}}hx-put="/organizations/{{.ID}}"{{else}}hx-post="/organizations"{{end}}
+      hx-target="#modal-container"
+      hx-swap="innerHTML">
+    <div class="grid grid-cols-2 gap-2 mb-2">
+        <input name="Name" value="{{.Name}}" placeholder="Name" required class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline">
+        <input name="Domain" value="{{.Domain}}" placeholder="Email domain, e.g. acme.com" class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline">
+        <input name="Phone" value="{{.Phone}}" placeholder="Phone" type="tel" class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline">
+    </div>
+    {{template "address-rows" (orgAddress .Address)}}
+    <textarea name="Notes" rows="2" placeholder="Notes" class="shadow appearance-none border rounded w-full py-2 px-3 mt-2 text-gray-700 leading-tight focus:outline-none focus:shadow-outline">{{.Notes}}</textarea>
+    <div class="flex justify-end mt-2">
+        <button type="submit" class="bg-blue-600 text-white font-bold py-2 px-4 rounded-lg shadow-md hover:bg-blue-700 transition-colors duration-300">{{if .ID}}Save Company{{else}}Add Company{{end}}</button>
+    </div>
+</form>
+{{end}}`
+
+func orgTemplate(name, html string) *template.Template {
+	tmpl := modalTemplate(name, html).Funcs(template.FuncMap{
+		"orgAddress": func(a Address) []Address {
+			if a.Empty() {
+				return nil
+			}
+			return []Address{a}
+		},
+	})
+	return template.Must(tmpl.Parse(orgFormHTML))
+}
+
+// organizationsView lists every organization with a form to add one
+func organizationsView(w http.ResponseWriter, r *http.Request) {
+	counts := map[string]int{}
+	for _, contact := range store.List() {
+		counts[contact.Organization.ID]++
+	}
+	w.Header().Set("Content-Type", "text/html")
+	err := orgTemplate("orgs-modal", orgsModalHTML).Execute(w, map[string]any{
+		"Orgs":   orgs.List(),
+		"Counts": counts,
+		"New":    Organization{},
+	})
+	if err != nil {
+		fmt.Printf("Error rendering organizations: %v\n", err)
+	}
+}
+
+// renderOrganization shows the company page of an organization
+func renderOrganization(w http.ResponseWriter, org Organization) {
+	w.Header().Set("Content-Type", "text/html")
+	err := orgTemplate("org-modal", orgModalHTML).Execute(w, map[string]any{
+		"Org":     org,
+		"Members": store.List().members(org.ID),
+	})
+	if err != nil {
+		fmt.Printf("Error rendering organization %s: %v\n", org.ID, err)
+	}
+}
+
+func organizationView(w http.ResponseWriter, r *http.Request) {
+	org, err := orgs.Find(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Organization not found", http.StatusNotFound)
+		return
+	}
+	renderOrganization(w, org)
+}
+
+// orgFromForm reads the add and edit company form
+func orgFromForm(r *http.Request) (Organization, error) {
+	if err := r.ParseForm(); err != nil {
+		return Organization{}, err
+	}
+	addresses, err := addressesFromForm(r)
+	if err != nil {
+		return Organization{}, err
+	}
+	org := Organization{
+		Name:   r.FormValue("Name"),
+		Domain: r.FormValue("Domain"),
+		Phone:  strings.TrimSpace(r.FormValue("Phone")),
+		Notes:  strings.TrimSpace(r.FormValue("Notes")),
+	}
+	if len(addresses) > 0 {
+		org.Address = addresses[0]
+	}
+	return org, nil
+}
+
+// saveOrganization adds an organization, or updates the one in the URL
+func saveOrganization(w http.ResponseWriter, r *http.Request) {
+	org, err := orgFromForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	org.ID = mux.Vars(r)["id"]
+	org, err = orgs.Put(org)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fmt.Printf("Organization saved: %s (%s)\n", org.Name, org.ID)
+	renderOrganization(w, org)
+}
+
+// deleteOrganization removes an organization nobody outside the trash is
+// linked to, unlinking the contacts in the trash so a restore finds none
+func deleteOrganization(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, err := orgs.Find(id); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	n, err := store.Unlink(currentUser(r), "organization", func(contacts Contacts) error {
+		if len(contacts.members(id)) > 0 {
+			return inUseError("Unlink everyone from this company first")
+		}
+		return nil
+	}, func(c *Contact) bool {
+		if c.Organization.ID != id {
+			return false
+		}
+		c.Organization.ID = ""
+		return true
+	}, func() error {
+		return orgs.Delete(id)
+	})
+	var inUse inUseError
+	if errors.As(err, &inUse) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Printf("Organization %s deleted, unlinked from %d contacts in the trash\n", id, n)
+	organizationsView(w, r)
+}
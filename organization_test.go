@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestNormalizeDomain(t *testing.T) {
+	tests := map[string]string{
+		"acme.com":               "acme.com",
+		" https://www.Acme.com/": "acme.com",
+		"@acme.com":              "acme.com",
+		"mail.acme.com":          "mail.acme.com",
+		"":                       "",
+	}
+	for in, want := range tests {
+		if got := normalizeDomain(in); got != want {
+			t.Errorf("normalizeDomain(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestOrgStore(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "AFcb.organizations.json")
+	o := NewOrgStore(filename)
+	if err := o.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := o.Put(Organization{Name: " "}); err == nil {
+		t.Error("saved an organization without a name")
+	}
+	acme, err := o.Put(Organization{Name: " Acme ", Domain: "https://www.acme.com/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := o.Put(Organization{Name: "Initech"}); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := NewOrgStore(filename)
+	if err := reloaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		email string
+		want  string
+	}{
+		{email: "wile@acme.com", want: acme.ID},
+		{email: "Wile@Mail.Acme.com", want: acme.ID},
+		{email: "wile@notacme.com"},
+		{email: "not an email"},
+	}
+	for _, tt := range tests {
+		org, ok := reloaded.ForEmail(tt.email)
+		if ok != (tt.want != "") || org.ID != tt.want {
+			t.Errorf("ForEmail(%q) = %q, %v, want %q", tt.email, org.ID, ok, tt.want)
+		}
+	}
+
+	if err := reloaded.Delete(acme.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reloaded.Find(acme.ID); err == nil {
+		t.Error("found a deleted organization")
+	}
+	if len(reloaded.List()) != 1 {
+		t.Errorf("listed %d organizations, want 1", len(reloaded.List()))
+	}
+}
+
+func TestDeleteOrganization(t *testing.T) {
+	old := orgs
+	orgs = NewOrgStore("")
+	t.Cleanup(func() { orgs = old })
+	acme, err := orgs.Put(Organization{Name: "Acme"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	initech, err := orgs.Put(Organization{Name: "Initech"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := useTestStore(t,
+		Contact{ID: "ann", Version: 1, FirstName: "Ann", Organization: OrgLink{ID: acme.ID}, DeletedAt: time.Now()},
+		Contact{ID: "bob", Version: 1, FirstName: "Bob", Organization: OrgLink{ID: initech.ID}},
+	)
+
+	tests := []struct {
+		id   string
+		want int
+	}{
+		{id: initech.ID, want: http.StatusConflict},
+		{id: acme.ID, want: http.StatusOK},
+		{id: acme.ID, want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		req := mux.SetURLVars(httptest.NewRequest(http.MethodDelete, "/organizations/"+tt.id, nil), map[string]string{"id": tt.id})
+		rec := httptest.NewRecorder()
+		deleteOrganization(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("deleting %s: status %d, want %d", tt.id, rec.Code, tt.want)
+		}
+	}
+
+	//the contact in the trash is unlinked so a restore finds no company
+	ann, err := s.contacts.Find("ann")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ann.Organization.ID != "" {
+		t.Errorf("trashed contact still linked to %s", ann.Organization.ID)
+	}
+	if revs := s.History("ann"); revs[len(revs)-1].Action != "organization" {
+		t.Errorf("unlink recorded as %q, want organization", revs[len(revs)-1].Action)
+	}
+	if _, err := orgs.Find(initech.ID); err != nil {
+		t.Error("organization in use was deleted")
+	}
+}
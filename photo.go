@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// largest photo upload accepted, and the most pixels decoded from one so a
+// small file cannot expand into a huge image
+const (
+	maxPhotoBytes  = 10 << 20
+	maxPhotoPixels = 50_000_000
+)
+
+// square sizes every photo is cut to, in pixels. the card shows the small
+// one and the detail view the large one
+var photoSizes = []int{96, 256}
+
+var photoIDRegex = regexp.MustCompile(`^[a-z0-9]+$`)
+
+// PhotoStore keeps thumbnails as JPEG files in a directory beside the
+// contact data, sealed like the data when a key is set. an empty dir keeps
+// them in memory only. a new upload gets a new ID rather than overwriting,
+// so photos can be cached for good and old revisions keep their photo
+type PhotoStore struct {
+	mu  sync.RWMutex
+	dir string
+	mem map[string][]byte
+}
+
+// photos shared by the handlers
+var photos = NewPhotoStore("")
+
+func NewPhotoStore(dir string) *PhotoStore {
+	return &PhotoStore{dir: dir, mem: map[string][]byte{}}
+}
+
+// photoDir is where the photos of the backend named by spec are kept
+func photoDir(spec string) string {
+	return strings.TrimSuffix(sidecarPath(spec, "photos"), ".json")
+}
+
+func photoName(id string, size int) string {
+	return id + "-" + strconv.Itoa(size) + ".jpg"
+}
+
+// PhotoUpload is an uploaded photo cut to every thumbnail size but not
+// saved yet, so an edit that is turned away leaves nothing behind
+type PhotoUpload struct {
+	ID    string
+	files map[string][]byte
+}
+
+// Prepare decodes an uploaded JPEG, PNG or GIF and cuts it to every
+// thumbnail size under a new ID, ready for Save. re-encoding drops any
+// metadata the upload carried, such as the location it was taken at
+func (p *PhotoStore) Prepare(data []byte) (*PhotoUpload, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.New("Photo must be a JPEG, PNG or GIF image")
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, errors.New("Photo is empty")
+	}
+	if config.Width*config.Height > maxPhotoPixels {
+		return nil, errors.New("Photo is too large, use one under 50 megapixels")
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("Photo could not be read: %w", err)
+	}
+
+	id, err := genID()
+	if err != nil {
+		return nil, err
+	}
+	upload := &PhotoUpload{ID: id, files: map[string][]byte{}}
+	for _, size := range photoSizes {
+		var out bytes.Buffer
+		if err := jpeg.Encode(&out, thumbnail(img, size), &jpeg.Options{Quality: 85}); err != nil {
+			return nil, err
+		}
+		upload.files[photoName(id, size)] = out.Bytes()
+	}
+	return upload, nil
+}
+
+// Save writes a prepared upload, taking back what was written if it fails
+func (p *PhotoStore) Save(upload *PhotoUpload) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.dir == "" {
+		for name, data := range upload.files {
+			p.mem[name] = data
+		}
+		return nil
+	}
+	if err := os.MkdirAll(p.dir, 0700); err != nil {
+		return err
+	}
+	for name, data := range upload.files {
+		if err := writeDataFile(filepath.Join(p.dir, name), data); err != nil {
+			p.delete(upload.ID)
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes every thumbnail of a photo, used once no contact needs it
+func (p *PhotoStore) Delete(id string) error {
+	if !photoIDRegex.MatchString(id) {
+		return fmt.Errorf("invalid photo id %q", id)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.delete(id)
+}
+
+// delete does the work of Delete, the caller holding the lock
+func (p *PhotoStore) delete(id string) error {
+	for _, size := range photoSizes {
+		if p.dir == "" {
+			delete(p.mem, photoName(id, size))
+			continue
+		}
+		err := os.Remove(filepath.Join(p.dir, photoName(id, size)))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Has reports whether a photo is still kept
+func (p *PhotoStore) Has(id string) bool {
+	_, err := p.Get(id, photoSizes[0])
+	return err == nil
+}
+
+// Get returns a thumbnail as JPEG
+func (p *PhotoStore) Get(id string, size int) ([]byte, error) {
+	if !photoIDRegex.MatchString(id) {
+		return nil, fmt.Errorf("invalid photo id %q", id)
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.dir == "" {
+		data, ok := p.mem[photoName(id, size)]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return data, nil
+	}
+	return readDataFile(filepath.Join(p.dir, photoName(id, size)))
+}
+
+// Reseal writes every photo again under the current data key
+func (p *PhotoStore) Reseal() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.dir == "" {
+		return nil
+	}
+	entries, err := os.ReadDir(p.dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".jpg" {
+			continue
+		}
+		path := filepath.Join(p.dir, entry.Name())
+		data, err := readDataFile(path)
+		if err != nil {
+			return err
+		}
+		if err := writeDataFile(path, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// thumbnail crops the middle square of img and scales it to size, averaging
+// the source pixels each thumbnail pixel covers. transparent parts come out
+// white since JPEG has no transparency. an empty image gives a white square
+func thumbnail(img image.Image, size int) *image.RGBA {
+	b := img.Bounds()
+	side := min(b.Dx(), b.Dy())
+	if side <= 0 || size <= 0 {
+		dst := image.NewRGBA(image.Rect(0, 0, max(size, 0), max(size, 0)))
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		return dst
+	}
+	crop := image.Rect(0, 0, side, side).Add(image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2))
+
+	src := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(src, src.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(src, src.Bounds(), img, crop.Min, draw.Over)
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		y0 := y * side / size
+		y1 := max(y0+1, (y+1)*side/size)
+		for x := 0; x < size; x++ {
+			x0 := x * side / size
+			x1 := max(x0+1, (x+1)*side/size)
+			var r, g, bl, n int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					r += int(row[sx*4])
+					g += int(row[sx*4+1])
+					bl += int(row[sx*4+2])
+					n++
+				}
+			}
+			i := y*dst.Stride + x*4
+			dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2], dst.Pix[i+3] = uint8(r/n), uint8(g/n), uint8(bl/n), 255
+		}
+	}
+	return dst
+}
+
+// photoFromForm reads the photo upload of the add and edit modals. it
+// returns the ID of a new upload along with the upload to save, "" when
+// Remove Photo is ticked, and ok false when the photo is left as it is
+func photoFromForm(r *http.Request) (id string, upload *PhotoUpload, ok bool, err error) {
+	if r.FormValue("RemovePhoto") != "" {
+		return "", nil, true, nil
+	}
+	if text, sent := r.Form["Photo"]; sent {
+		//merge modal, which sends the photo ID rather than a file
+		id = strings.Join(text, "")
+		if id != "" && !photoIDRegex.MatchString(id) {
+			return "", nil, false, fmt.Errorf("invalid photo id %q", id)
+		}
+		return id, nil, true, nil
+	}
+	if r.MultipartForm == nil {
+		return "", nil, false, nil
+	}
+	file, _, err := r.FormFile("PhotoFile")
+	if errors.Is(err, http.ErrMissingFile) {
+		return "", nil, false, nil
+	}
+	if err != nil {
+		return "", nil, false, err
+	}
+	defer file.Close()
+	var data bytes.Buffer
+	if _, err := data.ReadFrom(file); err != nil {
+		return "", nil, false, err
+	}
+	if data.Len() == 0 {
+		return "", nil, false, nil
+	}
+	upload, err = photos.Prepare(data.Bytes())
+	if err != nil {
+		return "", nil, false, err
+	}
+	return upload.ID, upload, true, nil
+}
+
+// parseContactForm reads a contact form, which is multipart when it
+// carries a photo
+func parseContactForm(w http.ResponseWriter, r *http.Request) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxPhotoBytes+1<<20)
+	err := r.ParseMultipartForm(maxPhotoBytes)
+	if errors.Is(err, http.ErrNotMultipart) {
+		return nil
+	}
+	return err
+}
+
+// servePhoto sends a thumbnail. a photo never changes once saved, so it can
+// be cached for as long as browsers like
+func servePhoto(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	size, err := strconv.Atoi(vars["size"])
+	if err != nil || !containsSize(size) {
+		http.Error(w, "Invalid photo size", http.StatusNotFound)
+		return
+	}
+	data, err := photos.Get(vars["id"], size)
+	if err != nil {
+		http.Error(w, "Photo not found", http.StatusNotFound)
+		return
+	}
+	sum := sha256.Sum256(data)
+	tag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	w.Header().Set("ETag", tag)
+	w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	if r.Header.Get("If-None-Match") == tag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Write(data)
+}
+
+func containsSize(size int) bool {
+	for _, s := range photoSizes {
+		if s == size {
+			return true
+		}
+	}
+	return false
+}
+
+// typeHex is the fill of an initials avatar for each contact type colour,
+// the 500 shade of the Tailwind palette
+var typeHex = map[string]string{
+	"gray":   "#6b7280",
+	"red":    "#ef4444",
+	"orange": "#f97316",
+	"yellow": "#eab308",
+	"green":  "#22c55e",
+	"teal":   "#14b8a6",
+	"blue":   "#3b82f6",
+	"indigo": "#6366f1",
+	"purple": "#a855f7",
+	"pink":   "#ec4899",
+}
+
+// Initials are the first letters of the first and last name, "?" with
+// neither
+func (c Contact) Initials() string {
+	var initials string
+	for _, name := range []string{c.FirstName, c.LastName} {
+		name = strings.TrimLeftFunc(name, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+		if r, _ := utf8.DecodeRuneInString(name); r != utf8.RuneError {
+			initials += string(unicode.ToUpper(r))
+		}
+	}
+	if initials == "" {
+		return "?"
+	}
+	return initials
+}
+
+var avatarHTML = `{{define "avatar"}}
+{{if .Contact.Photo}}
+<img src="/photos/{{.Contact.Photo}}/{{.Size}}" alt="" width="{{.Px}}" height="{{.Px}}" loading="lazy" class="avatar rounded-full flex-shrink-0">
+{{else}}
+<svg class="avatar flex-shrink-0" width="{{.Px}}" height="{{.Px}}" viewBox="0 0 64 64" role="img" aria-label="{{.Contact.Initials}}">
+    <circle cx="32" cy="32" r="32" fill="{{avatarFill .Contact.ContactType}}"/>
+    <text x="32" y="32" dy=".35em" text-anchor="middle" font-family="system-ui, sans-serif" font-size="26" font-weight="600" fill="#fff">{{.Contact.Initials}}</text>
+</svg>
+{{end}}
+{{end}}`
+
+// avatarView is what the avatar template is given: the contact, the
+// thumbnail size to load and the size to show it at
+type avatarView struct {
+	Contact Contact
+	Size    int
+	Px      int
+}
+
+var avatarFuncs = template.FuncMap{
+	"avatarFill": func(contactType string) string {
+		if fill, ok := typeHex[contactTypes.Lookup(contactType).Colour]; ok {
+			return fill
+		}
+		return typeHex["gray"]
+	},
+	"avatar": func(c Contact, size, px int) avatarView {
+		return avatarView{Contact: c, Size: size, Px: px}
+	},
+}
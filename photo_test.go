@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"path/filepath"
+	"testing"
+)
+
+func TestThumbnail(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+	white := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	tests := []struct {
+		name   string
+		bounds image.Rectangle
+		size   int
+		centre color.RGBA
+	}{
+		{name: "empty width", bounds: image.Rect(0, 0, 0, 5), size: 96, centre: white},
+		{name: "empty height", bounds: image.Rect(0, 0, 5, 0), size: 96, centre: white},
+		{name: "empty", bounds: image.Rect(3, 3, 3, 3), size: 96, centre: white},
+		{name: "one pixel", bounds: image.Rect(0, 0, 1, 1), size: 96, centre: red},
+		{name: "wide", bounds: image.Rect(0, 0, 300, 200), size: 96, centre: red},
+		{name: "off the origin", bounds: image.Rect(-10, 40, 3, 47), size: 256, centre: red},
+		{name: "smaller than the thumbnail", bounds: image.Rect(0, 0, 7, 3), size: 256, centre: red},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := image.NewRGBA(tt.bounds)
+			for y := tt.bounds.Min.Y; y < tt.bounds.Max.Y; y++ {
+				for x := tt.bounds.Min.X; x < tt.bounds.Max.X; x++ {
+					img.Set(x, y, red)
+				}
+			}
+			got := thumbnail(img, tt.size)
+			if got.Bounds() != image.Rect(0, 0, tt.size, tt.size) {
+				t.Fatalf("thumbnail is %v, want %dx%d", got.Bounds(), tt.size, tt.size)
+			}
+			if c := got.RGBAAt(tt.size/2, tt.size/2); c != tt.centre {
+				t.Errorf("centre is %v, want %v", c, tt.centre)
+			}
+		})
+	}
+}
+
+func TestPreparePhoto(t *testing.T) {
+	var picture bytes.Buffer
+	if err := png.Encode(&picture, image.NewRGBA(image.Rect(0, 0, 40, 30))); err != nil {
+		t.Fatal(err)
+	}
+	//a GIF header claiming a screen 0 pixels wide and 5 high
+	emptyGIF := []byte("GIF89a\x00\x00\x05\x00\x00\x00\x00;")
+
+	tests := []struct {
+		name string
+		data []byte
+		err  bool
+	}{
+		{name: "png", data: picture.Bytes()},
+		{name: "empty gif", data: emptyGIF, err: true},
+		{name: "not an image", data: []byte("hello"), err: true},
+		{name: "nothing", data: nil, err: true},
+	}
+	for _, tt := range tests {
+		upload, err := photos.Prepare(tt.data)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: prepared a photo, want an error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(upload.files) != len(photoSizes) {
+			t.Errorf("%s: cut %d sizes, want %d", tt.name, len(upload.files), len(photoSizes))
+		}
+	}
+}
+
+func TestPhotoStoreSaveDelete(t *testing.T) {
+	var picture bytes.Buffer
+	if err := png.Encode(&picture, image.NewRGBA(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{"", filepath.Join(t.TempDir(), "AFcb.photos")} {
+		p := NewPhotoStore(dir)
+		upload, err := p.Prepare(picture.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Has(upload.ID) {
+			t.Errorf("store %q has a photo before it was saved", dir)
+		}
+		if err := p.Save(upload); err != nil {
+			t.Fatal(err)
+		}
+		for _, size := range photoSizes {
+			if _, err := p.Get(upload.ID, size); err != nil {
+				t.Errorf("store %q: %v", dir, err)
+			}
+		}
+		if err := p.Delete(upload.ID); err != nil {
+			t.Fatal(err)
+		}
+		if p.Has(upload.ID) {
+			t.Errorf("store %q still has a deleted photo", dir)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// kinds of relationship, each read as "<contact> <kind> <other>" and kept
+// on both contacts, the other one holding the inverse
+var relationInverse = map[string]string{
+	"spouse of":             "spouse of",
+	"partner of":            "partner of",
+	"parent of":             "child of",
+	"child of":              "parent of",
+	"sibling of":            "sibling of",
+	"manager of":            "reports to",
+	"reports to":            "manager of",
+	"assistant to":          "assisted by",
+	"assisted by":           "assistant to",
+	"emergency contact for": "has emergency contact",
+	"has emergency contact": "emergency contact for",
+	"colleague of":          "colleague of",
+	"friend of":             "friend of",
+}
+
+// relationTypes lists the kinds of relationship for the picker
+func relationTypes() []string {
+	types := make([]string, 0, len(relationInverse))
+	for kind := range relationInverse {
+		types = append(types, kind)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Relation ties a contact to another one, such as "manager of" Bob
+type Relation struct {
+	Type string
+	ID   string // the other contact
+}
+
+// withRelation returns relations with r added, leaving the slice passed in
+// untouched
+func withRelation(relations []Relation, r Relation) []Relation {
+	if hasRelation(relations, r) {
+		return relations
+	}
+	return append(append([]Relation(nil), relations...), r)
+}
+
+// withoutRelations returns the relations drop is false for, nil when none
+// are left
+func withoutRelations(relations []Relation, drop func(r Relation) bool) []Relation {
+	var kept []Relation
+	for _, r := range relations {
+		if !drop(r) {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+// formatRelations writes relations with the other contact's ID rather than
+// name, as it runs while the store is locked
+func formatRelations(relations []Relation) string {
+	parts := make([]string, len(relations))
+	for i, r := range relations {
+		parts[i] = r.Type + " " + r.ID
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Related is a relation with the contact it points at
+type Related struct {
+	Type    string
+	Contact Contact
+}
+
+// Related lists the contacts this one is related to, leaving out those in
+// the trash or gone
+func (c Contact) Related() []Related {
+	var out []Related
+	for _, r := range c.Relations {
+		other, err := store.Find(r.ID)
+		if err != nil || other.InTrash() {
+			continue
+		}
+		out = append(out, Related{Type: r.Type, Contact: other})
+	}
+	return out
+}
+
+// otherContacts lists the contacts outside the trash other than id, by name,
+// for the relationship picker
+func otherContacts(id string) Contacts {
+	var out Contacts
+	for _, contact := range store.List() {
+		if contact.ID != id {
+			out = append(out, contact)
+		}
+	}
+	sortByName(out)
+	return out
+}
+
+// hasRelation reports whether relations holds r
+func hasRelation(relations []Relation, r Relation) bool {
+	for _, existing := range relations {
+		if existing == r {
+			return true
+		}
+	}
+	return false
+}
+
+// inverseChanges works out what keeps relationships two-sided once the
+// contacts ids have been changed or removed in c: each of their
+// relationships gets its inverse on the other contact, relationships
+// pointing at them that they do not hold the inverse of are dropped, and
+// so are their relationships to contacts that are gone. it returns the
+// contacts it changes, in the order of c
+func (c Contacts) inverseChanges(ids []string) Contacts {
+	index := map[string]int{}
+	for i, contact := range c {
+		index[contact.ID] = i
+	}
+	work := map[string]Contact{}
+	get := func(id string) (Contact, bool) {
+		if contact, ok := work[id]; ok {
+			return contact, true
+		}
+		if i, ok := index[id]; ok {
+			return c[i], true
+		}
+		return Contact{}, false
+	}
+
+	for _, id := range ids {
+		x, exists := get(id)
+		if exists {
+			kept := withoutRelations(x.Relations, func(r Relation) bool {
+				_, ok := index[r.ID]
+				return !ok || r.ID == id
+			})
+			if len(kept) != len(x.Relations) {
+				x.Relations = kept
+				work[id] = x
+			}
+			for _, r := range x.Relations {
+				y, _ := get(r.ID)
+				inverse := Relation{Type: relationInverse[r.Type], ID: id}
+				if !hasRelation(y.Relations, inverse) {
+					y.Relations = withRelation(y.Relations, inverse)
+					work[y.ID] = y
+				}
+			}
+		}
+		for _, contact := range c {
+			y, _ := get(contact.ID)
+			if y.ID == id {
+				continue
+			}
+			kept := withoutRelations(y.Relations, func(r Relation) bool {
+				if r.ID != id {
+					return false
+				}
+				return !exists || !hasRelation(x.Relations, Relation{Type: relationInverse[r.Type], ID: y.ID})
+			})
+			if len(kept) != len(y.Relations) {
+				y.Relations = kept
+				work[y.ID] = y
+			}
+		}
+	}
+
+	var out Contacts
+	for _, contact := range c {
+		if changed, ok := work[contact.ID]; ok {
+			out = append(out, changed)
+		}
+	}
+	return out
+}
+
+// relink keeps relationships two-sided once the contacts ids have been
+// saved or removed in c, saving what it changes through tx in the same
+// transaction. contacts among ids are fixed in place, the others are
+// versioned and returned as they were and are, for the caller to record
+// once the commit succeeds. callers hold the store lock
+func relink(c *Contacts, tx StorageTx, ids []string, by string) (before, after Contacts, err error) {
+	own := map[string]bool{}
+	for _, id := range ids {
+		own[id] = true
+	}
+	for _, contact := range c.inverseChanges(ids) {
+		if !own[contact.ID] {
+			old, _ := c.Find(contact.ID)
+			contact = nextVersion(old, contact)
+			contact.touch(by)
+			before, after = append(before, old), append(after, contact)
+		}
+		c.put(contact)
+		if err := tx.Put(contact); err != nil {
+			return nil, nil, err
+		}
+	}
+	return before, after, nil
+}
+
+// changeRelations applies fn to the relationships of contact id and saves
+// them along with the inverses on the other contacts, all under one hold
+// of the lock so neither side can be deleted in between
+func (s *ContactStore) changeRelations(id, by string, fn func(c Contact) ([]Relation, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	before, err := s.find(id)
+	if err != nil {
+		return err
+	}
+	relations, err := fn(before)
+	if err != nil {
+		return err
+	}
+	if slices.Equal(relations, before.Relations) {
+		return nil
+	}
+	contact := nextVersion(before, before)
+	contact.Relations = relations
+	contact.touch(by)
+	var others, othersAfter Contacts
+	err = s.mutate(func(c *Contacts, tx StorageTx) error {
+		c.put(contact)
+		if err := tx.Put(contact); err != nil {
+			return err
+		}
+		var err error
+		others, othersAfter, err = relink(c, tx, []string{id}, by)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	s.record(&before, &contact, by, "relation")
+	for i := range othersAfter {
+		s.record(&others[i], &othersAfter[i], by, "relation")
+	}
+	return nil
+}
+
+// Relate records that contact id is kind of other, adding the inverse to
+// other, in one change
+func (s *ContactStore) Relate(id, kind, other, by string) error {
+	if _, ok := relationInverse[kind]; !ok {
+		return fmt.Errorf("Invalid relationship: %s", kind)
+	}
+	if id == other {
+		return errors.New("A contact cannot be related to itself")
+	}
+	return s.changeRelations(id, by, func(c Contact) ([]Relation, error) {
+		related, err := s.contacts.Find(other)
+		if err != nil {
+			return nil, err
+		}
+		if related.InTrash() {
+			return nil, fmt.Errorf("Contact %s is in the trash", other)
+		}
+		return withRelation(c.Relations, Relation{Type: kind, ID: other}), nil
+	})
+}
+
+// Unrelate removes a relationship from both contacts
+func (s *ContactStore) Unrelate(id, kind, other, by string) error {
+	drop := Relation{Type: kind, ID: other}
+	return s.changeRelations(id, by, func(c Contact) ([]Relation, error) {
+		if !hasRelation(c.Relations, drop) {
+			return nil, errors.New("No such relationship")
+		}
+		return withoutRelations(c.Relations, func(r Relation) bool { return r == drop }), nil
+	})
+}
+
+// relationsDetail renders the detail view after a relationship changes,
+// refreshing the cards of both contacts
+func relationsDetail(w http.ResponseWriter, id, other string) {
+	contact, err := store.Find(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	renderDetail(w, contact, true)
+	if related, err := store.Find(other); err == nil && !related.InTrash() {
+		renderCardOOB(w, related)
+	}
+}
+
+// addRelation relates the contact in the URL to another one
+func addRelation(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	kind, other := r.FormValue("Type"), r.FormValue("Other")
+	if err := store.Relate(id, kind, other, currentUser(r)); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fmt.Printf("Contact %s is now %s %s\n", id, kind, other)
+	relationsDetail(w, id, other)
+}
+
+// deleteRelation removes a relationship of the contact in the URL
+func deleteRelation(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	kind, other := r.URL.Query().Get("type"), r.URL.Query().Get("other")
+	if err := store.Unrelate(id, kind, other, currentUser(r)); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	fmt.Printf("Contact %s is no longer %s %s\n", id, kind, other)
+	relationsDetail(w, id, other)
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// sameRelations compares relations, treating nil and empty alike
+func sameRelations(a, b []Relation) bool {
+	return len(a) == 0 && len(b) == 0 || reflect.DeepEqual(a, b)
+}
+
+func TestInverseChanges(t *testing.T) {
+	rel := func(kind, id string) Relation { return Relation{Type: kind, ID: id} }
+	contact := func(id string, relations ...Relation) Contact {
+		return Contact{ID: id, Relations: relations}
+	}
+	tests := []struct {
+		name     string
+		contacts Contacts
+		ids      []string
+		want     Contacts
+	}{
+		{
+			name:     "in step",
+			contacts: Contacts{contact("a", rel("parent of", "b")), contact("b", rel("child of", "a"))},
+			ids:      []string{"a"},
+		},
+		{
+			name:     "inverse added",
+			contacts: Contacts{contact("a", rel("manager of", "b")), contact("b")},
+			ids:      []string{"a"},
+			want:     Contacts{contact("b", rel("reports to", "a"))},
+		},
+		{
+			name:     "stale inverse dropped",
+			contacts: Contacts{contact("a"), contact("b", rel("child of", "a"), rel("sibling of", "c")), contact("c", rel("sibling of", "b"))},
+			ids:      []string{"a"},
+			want:     Contacts{contact("b", rel("sibling of", "c"))},
+		},
+		{
+			name:     "kind changed",
+			contacts: Contacts{contact("a", rel("spouse of", "b")), contact("b", rel("partner of", "a"))},
+			ids:      []string{"a"},
+			want:     Contacts{contact("b", rel("spouse of", "a"))},
+		},
+		{
+			name:     "removed contact",
+			contacts: Contacts{contact("b", rel("child of", "a")), contact("c", rel("friend of", "a"), rel("friend of", "b"))},
+			ids:      []string{"a"},
+			want:     Contacts{contact("b"), contact("c", rel("friend of", "b"))},
+		},
+		{
+			name:     "relations to missing contacts and itself dropped",
+			contacts: Contacts{contact("a", rel("friend of", "gone"), rel("friend of", "a"))},
+			ids:      []string{"a"},
+			want:     Contacts{contact("a")},
+		},
+		{
+			name:     "several changed together",
+			contacts: Contacts{contact("a", rel("parent of", "c")), contact("b", rel("parent of", "c")), contact("c")},
+			ids:      []string{"a", "b"},
+			want:     Contacts{contact("c", rel("child of", "a"), rel("child of", "b"))},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.contacts.inverseChanges(tt.ids)
+			if len(got) != len(tt.want) {
+				t.Fatalf("changed %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if got[i].ID != tt.want[i].ID || !sameRelations(got[i].Relations, tt.want[i].Relations) {
+					t.Errorf("changed %+v, want %+v", got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRelationsKeptInStep(t *testing.T) {
+	ann := Contact{ID: "ann", Version: 1, FirstName: "Ann"}
+	bob := Contact{ID: "bob", Version: 1, FirstName: "Bob"}
+	want := func(t *testing.T, s *ContactStore, id string, relations ...Relation) {
+		t.Helper()
+		contact, err := s.contacts.Find(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameRelations(contact.Relations, relations) {
+			t.Errorf("%s has %+v, want %+v", id, contact.Relations, relations)
+		}
+	}
+	annIsParent := Relation{Type: "parent of", ID: "bob"}
+	bobIsChild := Relation{Type: "child of", ID: "ann"}
+
+	tests := []struct {
+		name  string
+		apply func(t *testing.T, s *ContactStore) error
+		ann   []Relation
+		bob   []Relation
+		gone  bool // bob is no longer there
+	}{
+		{
+			name:  "relate",
+			apply: func(t *testing.T, s *ContactStore) error { return nil },
+			ann:   []Relation{annIsParent},
+			bob:   []Relation{bobIsChild},
+		},
+		{
+			name: "relate again",
+			apply: func(t *testing.T, s *ContactStore) error {
+				return s.Relate("bob", "child of", "ann", "af")
+			},
+			ann: []Relation{annIsParent},
+			bob: []Relation{bobIsChild},
+		},
+		{
+			name: "unrelate",
+			apply: func(t *testing.T, s *ContactStore) error {
+				return s.Unrelate("bob", "child of", "ann", "af")
+			},
+		},
+		{
+			name: "restore an older revision",
+			apply: func(t *testing.T, s *ContactStore) error {
+				restored, err := s.Restore("ann", 1, "af")
+				if err == nil && restored.FirstName != "Ann" {
+					t.Errorf("restored name %q, want Ann", restored.FirstName)
+				}
+				return err
+			},
+			ann: []Relation{annIsParent},
+			bob: []Relation{bobIsChild},
+		},
+		{
+			name: "restore a snapshot without the relationship on one side",
+			apply: func(t *testing.T, s *ContactStore) error {
+				ann := ann
+				ann.Relations = []Relation{{Type: "manager of", ID: "bob"}}
+				return s.ReplaceAll(Contacts{ann, bob}, "af", "snapshot")
+			},
+			ann: []Relation{{Type: "manager of", ID: "bob"}},
+			bob: []Relation{{Type: "reports to", ID: "ann"}},
+		},
+		{
+			name: "pick the file's side of a conflict",
+			apply: func(t *testing.T, s *ContactStore) error {
+				onDisk := ann
+				s.conflicts = []Conflict{{ID: "ann", OnDisk: &onDisk}}
+				return s.ResolveConflict("ann", true, "af")
+			},
+		},
+		{
+			name: "trash keeps the relationship for an undo",
+			apply: func(t *testing.T, s *ContactStore) error {
+				_, err := s.Delete("bob", "af")
+				return err
+			},
+			ann: []Relation{annIsParent},
+			bob: []Relation{bobIsChild},
+		},
+		{
+			name: "purge",
+			apply: func(t *testing.T, s *ContactStore) error {
+				if _, err := s.Delete("bob", "af"); err != nil {
+					return err
+				}
+				return s.Purge("bob")
+			},
+			gone: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t, ann, bob)
+			if _, err := s.Change("ann", "af", "update", func(c *Contact) error {
+				c.FirstName = "Annie"
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Relate("ann", "parent of", "bob", "af"); err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.apply(t, s); err != nil {
+				t.Fatal(err)
+			}
+			want(t, s, "ann", tt.ann...)
+			if _, err := s.contacts.Find("bob"); tt.gone != (err != nil) {
+				t.Fatalf("finding bob returned %v", err)
+			}
+			if !tt.gone {
+				want(t, s, "bob", tt.bob...)
+			}
+		})
+	}
+}
+
+func TestRelateChecks(t *testing.T) {
+	tests := []struct {
+		name         string
+		id, kind, to string
+		trashed      bool
+		err          bool
+	}{
+		{name: "valid", id: "ann", kind: "sibling of", to: "bob"},
+		{name: "unknown kind", id: "ann", kind: "rival of", to: "bob", err: true},
+		{name: "itself", id: "ann", kind: "friend of", to: "ann", err: true},
+		{name: "missing contact", id: "ann", kind: "friend of", to: "zed", err: true},
+		{name: "trashed contact", id: "ann", kind: "friend of", to: "bob", trashed: true, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t, Contact{ID: "ann", Version: 1}, Contact{ID: "bob", Version: 1})
+			if tt.trashed {
+				if _, err := s.Delete("bob", "af"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := s.Relate(tt.id, tt.kind, tt.to, "af")
+			if (err != nil) != tt.err {
+				t.Fatalf("Relate returned %v, want an error: %v", err, tt.err)
+			}
+			bob, _ := s.contacts.Find("bob")
+			if tt.err && len(bob.Relations) > 0 {
+				t.Errorf("a refused relationship left %+v behind", bob.Relations)
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// how often the data file is checked for outside changes, 0 turns it off
+var watchInterval = 2 * time.Second
+
+// watcher is implemented by backends whose data can be changed on disk by
+// something other than this server, such as a sync tool or a text editor
+type watcher interface {
+	// Sync picks up outside changes since the data was last read or written.
+	// changed is false when there were none
+	Sync() (contacts Contacts, conflicts []Conflict, changed bool, err error)
+}
+
+// Conflict is a contact changed both here and on disk since the file was
+// last read. the book keeps our version until someone picks one
+type Conflict struct {
+	ID         string
+	Mine       *Contact // nil when we removed the contact
+	OnDisk     *Contact // nil when it was removed from the file
+	DetectedAt time.Time
+}
+
+// name of the contact from whichever side still has it
+func (c Conflict) Name() string {
+	contact := c.Mine
+	if contact == nil {
+		contact = c.OnDisk
+	}
+	return contact.FirstName + " " + contact.LastName
+}
+
+// changes lists the fields that differ, Old being ours and New the file's
+func (c Conflict) Changes() []FieldChange {
+	var mine, onDisk Contact
+	if c.Mine != nil {
+		mine = *c.Mine
+	}
+	if c.OnDisk != nil {
+		onDisk = *c.OnDisk
+	}
+	return diffContacts(mine, onDisk)
+}
+
+// sameContact compares contacts by their saved form, so times that only
+// differ in their monotonic reading still match
+func sameContact(a, b Contact) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(ja, jb)
+}
+
+// mergeContacts does a three way merge of contacts as last read from disk
+// (base), as we have them now (ours) and as they are on disk now (theirs).
+// a contact changed on only one side takes that side. one changed on both
+// sides keeps ours and is reported as a conflict. the result follows the
+// order of the file with our new contacts after it
+func mergeContacts(base, ours, theirs Contacts) (Contacts, []Conflict) {
+	byID := func(c Contacts) map[string]Contact {
+		m := make(map[string]Contact, len(c))
+		for _, contact := range c {
+			m[contact.ID] = contact
+		}
+		return m
+	}
+	baseByID, oursByID := byID(base), byID(ours)
+	now := time.Now()
+
+	merged := Contacts{}
+	var conflicts []Conflict
+	conflict := func(mine, onDisk *Contact) {
+		id := ""
+		if mine != nil {
+			id = mine.ID
+		} else {
+			id = onDisk.ID
+		}
+		conflicts = append(conflicts, Conflict{ID: id, Mine: mine, OnDisk: onDisk, DetectedAt: now})
+	}
+
+	seen := map[string]bool{}
+	for _, t := range theirs {
+		seen[t.ID] = true
+		o, inOurs := oursByID[t.ID]
+		b, inBase := baseByID[t.ID]
+		switch {
+		case !inOurs && !inBase:
+			//added on disk
+			merged = append(merged, t)
+		case !inOurs:
+			//we removed it, which stands unless the file changed it too
+			if !sameContact(t, b) {
+				conflict(nil, &t)
+			}
+		case !inBase || (!sameContact(o, b) && !sameContact(t, b)):
+			merged = append(merged, o)
+			if !sameContact(o, t) {
+				conflict(&o, &t)
+			}
+		case !sameContact(o, b):
+			merged = append(merged, o)
+		default:
+			//hand edits leave the version alone, move it on so edit
+			//forms opened before the reload are turned away
+			if !sameContact(t, b) && t.Version <= b.Version {
+				t.Version = b.Version + 1
+			}
+			merged = append(merged, t)
+		}
+	}
+	for _, o := range ours {
+		if seen[o.ID] {
+			continue
+		}
+		b, inBase := baseByID[o.ID]
+		switch {
+		case !inBase:
+			//added here
+			merged = append(merged, o)
+		case !sameContact(o, b):
+			//removed from the file but changed here
+			merged = append(merged, o)
+			conflict(&o, nil)
+		}
+	}
+	return merged, conflicts
+}
+
+// readDisk reads a data file as it is on disk now along with its hash.
+// ok is false when the file does not exist
+func readDisk(filename string) ([]byte, [sha256.Size]byte, bool, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, [sha256.Size]byte{}, false, nil
+		}
+		return nil, [sha256.Size]byte{}, false, fmt.Errorf("failed to read %s: %w", filename, err)
+	}
+	return data, sha256.Sum256(data), true, nil
+}
+
+var conflictBannerHTML = `
+<div id="conflict-banner" hx-get="/conflicts/banner?seen={{.Reloads}}" hx-trigger="every 5s" hx-swap="outerHTML">
+    {{if .Reloaded}}
+    <div hx-get="/contacts" hx-target="#contact-list" hx-swap="innerHTML" hx-trigger="load"></div>
+    {{end}}
+    {{if .Conflicts}}
+    <div class="flex justify-between items-center mb-6 p-4 rounded-lg bg-yellow-100 border border-yellow-300 text-yellow-800">
+        <span>{{len .Conflicts}} contact{{if gt (len .Conflicts) 1}}s were{{else}} was{{end}} changed both here and in the data file. We kept the version here for now.</span>
+        <button class="ml-4 px-3 py-1 rounded-lg bg-yellow-600 text-white font-bold hover:bg-yellow-700 transition-colors"
+            hx-get="/conflicts"
+            hx-target="#modal-container"
+            hx-swap="innerHTML">
+            Review
+        </button>
+    </div>
+    {{end}}
+</div>
+`
+
+var conflictBanner = template.Must(template.New("conflict-banner").Parse(conflictBannerHTML))
+
+var conflictsModalHTML = `
+<div id="contact-modal" class="fixed inset-0 bg-gray-600 bg-opacity-50 overflow-y-auto h-full w-full">
+    <div class="relative top-20 mx-auto p-5 border w-full max-w-2xl shadow-lg rounded-md bg-white">
+        <div class="flex justify-end">
+            <button hx-target="#contact-modal" hx-swap="outerHTML" hx-get="/modal/close" class="text-gray-400 hover:text-gray-600">&times;</button>
+        </div>
+        <h3 class="text-xl font-bold mb-1">Conflicting changes</h3>
+        <p class="text-sm text-gray-500 mb-4">These contacts were changed here and in the data file at the same time.</p>
+        {{if not .}}
+        <div class="p-4 bg-gray-100 text-gray-500 rounded-lg">No conflicts left.</div>
+        {{end}}
+        {{range .}}
+        <div class="p-3 mb-3 border rounded-lg" id="conflict-{{.ID}}">
+            <div class="flex justify-between items-center mb-2">
+                <strong class="text-gray-800">{{.Name}}</strong>
+                <span class="text-xs text-gray-500">{{.DetectedAt.Format "2 Jan 2006 15:04"}}</span>
+            </div>
+            {{if not .Mine}}
+            <p class="text-sm text-gray-600 mb-2">Removed here but changed in the file.</p>
+            {{else if not .OnDisk}}
+            <p class="text-sm text-gray-600 mb-2">Changed here but removed from the file.</p>
+            {{end}}
+            <table class="w-full text-sm mb-3">
+                <tr class="text-left text-gray-500"><th class="pr-2">Field</th><th class="pr-2">Here</th><th>In file</th></tr>
+                {{range .Changes}}
+                <tr>
+                    <td class="pr-2 text-gray-500">{{.Field}}</td>
+                    <td class="pr-2 text-gray-800">{{.Old}}</td>
+                    <td class="text-gray-800">{{.New}}</td>
+                </tr>
+                {{end}}
+            </table>
+            <div class="space-x-2">
+                <button class="px-3 py-1 text-sm rounded-lg border border-gray-300 hover:border-blue-500 hover:bg-blue-50 transition-colors"
+                    hx-post="/conflicts/{{.ID}}/resolve?keep=mine"
+                    hx-target="#conflict-{{.ID}}"
+                    hx-swap="outerHTML">
+                    Keep mine
+                </button>
+                <button class="px-3 py-1 text-sm rounded-lg border border-gray-300 hover:border-blue-500 hover:bg-blue-50 transition-colors"
+                    hx-post="/conflicts/{{.ID}}/resolve?keep=file"
+                    hx-target="#conflict-{{.ID}}"
+                    hx-swap="outerHTML">
+                    Use file version
+                </button>
+            </div>
+        </div>
+        {{end}}
+    </div>
+</div>
+`
+
+var conflictsModal = template.Must(template.New("conflicts-modal").Parse(conflictsModalHTML))
+
+// conflictBannerView shows outstanding conflicts and refreshes the contact
+// list when contacts were reloaded since the page last looked
+func conflictBannerView(w http.ResponseWriter, r *http.Request) {
+	reloads := store.Reloads()
+	seen, seenErr := strconv.Atoi(r.URL.Query().Get("seen"))
+	w.Header().Set("Content-Type", "text/html")
+	err := conflictBanner.Execute(w, map[string]any{
+		"Reloads":   reloads,
+		"Reloaded":  seenErr == nil && seen != reloads,
+		"Conflicts": store.Conflicts(),
+	})
+	if err != nil {
+		fmt.Printf("Error rendering conflict banner: %v\n", err)
+	}
+}
+
+func conflictsView(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html")
+	if err := conflictsModal.Execute(w, store.Conflicts()); err != nil {
+		fmt.Printf("Error rendering conflicts: %v\n", err)
+	}
+}
+
+// resolveConflict keeps our version or takes the one from the file, then
+// refreshes the contact list out of band
+func resolveConflict(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	useFile := r.URL.Query().Get("keep") == "file"
+	if err := store.ResolveConflict(id, useFile, currentUser(r)); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	fmt.Printf("Conflict on contact %s resolved\n", id)
+
+	w.Header().Set("Content-Type", "text/html")
+	renderContactListOOB(w)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMergeContacts(t *testing.T) {
+	c := func(id, name string, version int) Contact {
+		return Contact{ID: id, FirstName: name, Version: version}
+	}
+	base := Contacts{c("a", "Ann", 1), c("b", "Bob", 1), c("d", "Dan", 1)}
+
+	tests := []struct {
+		name          string
+		ours, theirs  Contacts
+		want          Contacts
+		wantConflicts []string
+	}{
+		{
+			name:   "nothing changed",
+			ours:   base,
+			theirs: base,
+			want:   base,
+		},
+		{
+			name:   "changed on disk only",
+			ours:   base,
+			theirs: Contacts{c("a", "Anna", 1), c("b", "Bob", 1), c("d", "Dan", 1)},
+			//hand edits move the version on
+			want: Contacts{c("a", "Anna", 2), c("b", "Bob", 1), c("d", "Dan", 1)},
+		},
+		{
+			name:   "changed here only",
+			ours:   Contacts{c("a", "Ann", 1), c("b", "Bobby", 2), c("d", "Dan", 1)},
+			theirs: base,
+			want:   Contacts{c("a", "Ann", 1), c("b", "Bobby", 2), c("d", "Dan", 1)},
+		},
+		{
+			name:          "changed on both sides",
+			ours:          Contacts{c("a", "Annie", 2), c("b", "Bob", 1), c("d", "Dan", 1)},
+			theirs:        Contacts{c("a", "Anna", 1), c("b", "Bob", 1), c("d", "Dan", 1)},
+			want:          Contacts{c("a", "Annie", 2), c("b", "Bob", 1), c("d", "Dan", 1)},
+			wantConflicts: []string{"a"},
+		},
+		{
+			name:   "changed the same way on both sides",
+			ours:   Contacts{c("a", "Anna", 1), c("b", "Bob", 1), c("d", "Dan", 1)},
+			theirs: Contacts{c("a", "Anna", 1), c("b", "Bob", 1), c("d", "Dan", 1)},
+			want:   Contacts{c("a", "Anna", 1), c("b", "Bob", 1), c("d", "Dan", 1)},
+		},
+		{
+			name:   "added on each side",
+			ours:   append(base.clone(), c("e", "Eve", 1)),
+			theirs: append(base.clone(), c("f", "Fay", 1)),
+			want:   append(base.clone(), c("f", "Fay", 1), c("e", "Eve", 1)),
+		},
+		{
+			name:   "removed on disk",
+			ours:   base,
+			theirs: Contacts{c("a", "Ann", 1), c("d", "Dan", 1)},
+			want:   Contacts{c("a", "Ann", 1), c("d", "Dan", 1)},
+		},
+		{
+			name:          "removed on disk but changed here",
+			ours:          Contacts{c("a", "Ann", 1), c("b", "Bobby", 2), c("d", "Dan", 1)},
+			theirs:        Contacts{c("a", "Ann", 1), c("d", "Dan", 1)},
+			want:          Contacts{c("a", "Ann", 1), c("d", "Dan", 1), c("b", "Bobby", 2)},
+			wantConflicts: []string{"b"},
+		},
+		{
+			name:          "removed here but changed on disk",
+			ours:          Contacts{c("a", "Ann", 1), c("d", "Dan", 1)},
+			theirs:        Contacts{c("a", "Ann", 1), c("b", "Bobby", 1), c("d", "Dan", 1)},
+			want:          Contacts{c("a", "Ann", 1), c("d", "Dan", 1)},
+			wantConflicts: []string{"b"},
+		},
+		{
+			name:   "file order wins",
+			ours:   base,
+			theirs: Contacts{c("d", "Dan", 1), c("a", "Ann", 1), c("b", "Bob", 1)},
+			want:   Contacts{c("d", "Dan", 1), c("a", "Ann", 1), c("b", "Bob", 1)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, conflicts := mergeContacts(base, tt.ours, tt.theirs)
+			if len(got) != len(tt.want) {
+				t.Fatalf("merged %d contacts, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if !sameContact(got[i], tt.want[i]) {
+					t.Errorf("contact %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+			var ids []string
+			for _, conflict := range conflicts {
+				ids = append(ids, conflict.ID)
+			}
+			if !slices.Equal(ids, tt.wantConflicts) {
+				t.Errorf("conflicts %v, want %v", ids, tt.wantConflicts)
+			}
+		})
+	}
+}
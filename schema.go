@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// version of the contact data format written by this build
+const schemaVersion = 3
+
+// contactsFile is the envelope written to AFcb.json. version 0 files are a
+// bare JSON array of contacts with no envelope
+type contactsFile struct {
+	Version  int
+	Meta     fileMeta
+	Contacts Contacts
+}
+
+type fileMeta struct {
+	App     string
+	SavedAt time.Time
+	Count   int
+}
+
+// migration upgrades contact records by one schema version. apply reports
+// whether it changed the record and is nil when only the envelope changes
+type migration struct {
+	Description string
+	Apply       func(record map[string]any) bool
+}
+
+// migrations[i] upgrades records from version i to i+1. each must leave a
+// record already in the newer form unchanged, as history runs them all
+var migrations = []migration{
+	{Description: "wrap bare contact array in a versioned envelope"},
+	{Description: "start every contact at version 1", Apply: func(record map[string]any) bool {
+		if _, ok := record["Version"]; ok {
+			return false
+		}
+		record["Version"] = 1
+		return true
+	}},
+	{Description: "turn single Email and Phone into labeled lists", Apply: func(record map[string]any) bool {
+		emails := splitValue(record, "Email", "Emails", "other")
+		phones := splitValue(record, "Phone", "Phones", "mobile")
+		return emails || phones
+	}},
+}
+
+// splitValue moves a single value field into a one item labeled list
+func splitValue(record map[string]any, field, list, label string) bool {
+	value, ok := record[field]
+	if !ok {
+		return false
+	}
+	delete(record, field)
+	if s, _ := value.(string); s != "" {
+		record[list] = []any{map[string]any{"Label": label, "Value": s, "Primary": true}}
+	}
+	return true
+}
+
+// migrationReport describes what upgrading data to schemaVersion changes
+type migrationReport struct {
+	From  int
+	To    int
+	Steps []string
+}
+
+func (r migrationReport) needed() bool {
+	return r.From < r.To
+}
+
+// migrateRecords upgrades raw contact records in place from version from
+func migrateRecords(records []map[string]any, from int) (migrationReport, error) {
+	if from > schemaVersion {
+		return migrationReport{}, fmt.Errorf("data is schema version %d but this AFcb only understands up to %d, upgrade AFcb first", from, schemaVersion)
+	}
+	report := migrationReport{From: from, To: schemaVersion}
+	for v := from; v < schemaVersion; v++ {
+		m := migrations[v]
+		changed := 0
+		if m.Apply != nil {
+			for _, record := range records {
+				if m.Apply(record) {
+					changed++
+				}
+			}
+		}
+		report.Steps = append(report.Steps, fmt.Sprintf("v%d -> v%d: %s (%d of %d contacts changed)", v, v+1, m.Description, changed, len(records)))
+	}
+	return report, nil
+}
+
+// decodeContactsFile reads contact file data of any known version and
+// upgrades it to schemaVersion in memory
+func decodeContactsFile(data []byte) (Contacts, migrationReport, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, migrationReport{}, errors.New("file is empty")
+	}
+
+	var records []map[string]any
+	version := 0
+	if data[0] == '[' {
+		if err := json.Unmarshal(data, &records); err != nil {
+			return nil, migrationReport{}, fmt.Errorf("Failed to unmarshal contacts data: %w", err)
+		}
+	} else {
+		var env struct {
+			Version  int
+			Contacts []map[string]any
+		}
+		if err := json.Unmarshal(data, &env); err != nil {
+			return nil, migrationReport{}, fmt.Errorf("Failed to unmarshal contacts data: %w", err)
+		}
+		if env.Version < 1 {
+			return nil, migrationReport{}, errors.New("contacts file has no schema version")
+		}
+		version, records = env.Version, env.Contacts
+	}
+
+	report, err := migrateRecords(records, version)
+	if err != nil {
+		return nil, migrationReport{}, err
+	}
+	contacts, err := recordsToContacts(records)
+	if err != nil {
+		return nil, migrationReport{}, err
+	}
+	return contacts, report, nil
+}
+
+func recordsToContacts(records []map[string]any) (Contacts, error) {
+	data, err := json.Marshal(records)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal migrated contacts: %w", err)
+	}
+	contacts := Contacts{}
+	if err := json.Unmarshal(data, &contacts); err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal migrated contacts: %w", err)
+	}
+	return contacts, nil
+}
+
+// decodeContact reads a single contact record written at version, as kept
+// by the dir and journal backends
+func decodeContact(raw json.RawMessage, version int) (Contact, error) {
+	var record map[string]any
+	if err := json.Unmarshal(raw, &record); err != nil {
+		return Contact{}, fmt.Errorf("Failed to unmarshal contact: %w", err)
+	}
+	if _, err := migrateRecords([]map[string]any{record}, version); err != nil {
+		return Contact{}, err
+	}
+	contacts, err := recordsToContacts([]map[string]any{record})
+	if err != nil {
+		return Contact{}, err
+	}
+	return contacts[0], nil
+}
+
+// encodeContactsFile writes contacts in the current envelope format
+func encodeContactsFile(c Contacts) ([]byte, error) {
+	if c == nil {
+		c = Contacts{}
+	}
+	return json.MarshalIndent(contactsFile{
+		Version: schemaVersion,
+		Meta: fileMeta{
+			App:     "AFcb",
+			SavedAt: time.Now(),
+			Count:   len(c),
+		},
+		Contacts: c,
+	}, "", " ")
+}
+
+// upgradeFile rewrites an older contact file in the current format, after
+// keeping a copy of the original named for its version
+func upgradeFile(filename string, original []byte, contacts Contacts, report migrationReport) error {
+	backup := fmt.Sprintf("%s.v%d-%s.bak", filename, report.From, time.Now().Format("20060102-150405"))
+	if err := writeDataFile(backup, original); err != nil {
+		return fmt.Errorf("failed to back up %s before upgrade: %w", filename, err)
+	}
+	if err := contacts.SaveToFile(filename); err != nil {
+		return err
+	}
+	fmt.Printf("Upgraded %s from schema v%d to v%d, original kept as %s\n", filename, report.From, report.To, backup)
+	for _, step := range report.Steps {
+		fmt.Println("  " + step)
+	}
+	return nil
+}
+
+// schemaReport works out the upgrade a backend needs without changing it
+func schemaReport(spec string) (migrationReport, error) {
+	storage, err := openStorage(spec)
+	if err != nil {
+		return migrationReport{}, err
+	}
+	switch s := storage.(type) {
+	case *JSONFileStorage:
+		return fileSchemaReport(s.filename)
+	case *JournalStorage:
+		return fileSchemaReport(s.snapshot)
+	case *DirStorage:
+		return s.schemaReport()
+	default:
+		return migrationReport{From: schemaVersion, To: schemaVersion}, nil
+	}
+}
+
+func fileSchemaReport(filename string) (migrationReport, error) {
+	data, err := readDataFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return migrationReport{From: schemaVersion, To: schemaVersion}, nil
+		}
+		return migrationReport{}, err
+	}
+	_, report, err := decodeContactsFile(data)
+	return report, err
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeContactsFile(t *testing.T) {
+	alice := Contact{ID: "alice", Version: 1, FirstName: "Alice",
+		Emails: []ContactValue{{Label: "other", Value: "alice@example.com", Primary: true}},
+		Phones: []ContactValue{{Label: "mobile", Value: "+1 555 1234", Primary: true}},
+	}
+	tests := []struct {
+		name     string
+		data     string
+		wantFrom int
+		want     Contacts
+		err      bool
+	}{
+		{
+			name:     "bare array",
+			data:     `[{"ID": "alice", "FirstName": "Alice", "Email": "alice@example.com", "Phone": "+1 555 1234"}]`,
+			wantFrom: 0,
+			want:     Contacts{alice},
+		},
+		{
+			name:     "version 1",
+			data:     `{"Version": 1, "Contacts": [{"ID": "alice", "FirstName": "Alice", "Email": "alice@example.com", "Phone": "+1 555 1234"}]}`,
+			wantFrom: 1,
+			want:     Contacts{alice},
+		},
+		{
+			name:     "version 2 with a blank phone",
+			data:     `{"Version": 2, "Contacts": [{"ID": "bob", "Version": 4, "Email": "", "Phone": ""}]}`,
+			wantFrom: 2,
+			want:     Contacts{{ID: "bob", Version: 4}},
+		},
+		{
+			name:     "current",
+			data:     `{"Version": 3, "Contacts": [{"ID": "alice", "Version": 1, "FirstName": "Alice", "Emails": [{"Label": "other", "Value": "alice@example.com", "Primary": true}], "Phones": [{"Label": "mobile", "Value": "+1 555 1234", "Primary": true}]}]}`,
+			wantFrom: 3,
+			want:     Contacts{alice},
+		},
+		{name: "newer than this build", data: `{"Version": 99, "Contacts": []}`, err: true},
+		{name: "no version", data: `{"Contacts": []}`, err: true},
+		{name: "empty", data: "  ", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, report, err := decodeContactsFile([]byte(tt.data))
+			if tt.err {
+				if err == nil {
+					t.Fatal("decodeContactsFile succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.From != tt.wantFrom || report.To != schemaVersion || len(report.Steps) != schemaVersion-tt.wantFrom {
+				t.Errorf("report = %+v, want from %d to %d", report, tt.wantFrom, schemaVersion)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decoded %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// migrations must leave records already in a newer form alone, as
+// revisions are upgraded without knowing their version
+func TestMigrationsIdempotent(t *testing.T) {
+	current := Contact{ID: "alice", Version: 7, Emails: []ContactValue{{Label: "work", Value: "a@example.com"}}}
+	raw, err := json.Marshal(current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for from := 0; from <= schemaVersion; from++ {
+		got, err := decodeContact(raw, from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, current) {
+			t.Errorf("from version %d decoded %+v, want %+v", from, got, current)
+		}
+	}
+}
+
+func TestLoadContactsUpgradesInPlace(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "AFcb.json")
+	original := `[{"ID": "alice", "Email": "alice@example.com"}]`
+	if err := os.WriteFile(filename, []byte(original), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var loaded Contacts
+	if err := loaded.LoadContacts(filename); err != nil {
+		t.Fatal(err)
+	}
+	report, err := fileSchemaReport(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.needed() {
+		t.Errorf("file still needs upgrading from version %d", report.From)
+	}
+	backups, _ := filepath.Glob(filename + ".v0-*.bak")
+	if len(backups) != 1 {
+		t.Fatalf("found %d copies of the original, want 1", len(backups))
+	}
+	kept, err := os.ReadFile(backups[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(kept) != original {
+		t.Errorf("original kept as %q, want %q", kept, original)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+)
+
+var settingsModalHTML = `
+<div id="contact-modal" class="fixed inset-0 bg-gray-600 bg-opacity-50 overflow-y-auto h-full w-full">
+    <div class="relative top-20 mx-auto p-5 border w-full max-w-2xl shadow-lg rounded-md bg-white">
+        <div class="flex justify-end">
+            <button hx-target="#contact-modal" hx-swap="outerHTML" hx-get="/modal/close" class="text-gray-400 hover:text-gray-600">&times;</button>
+        </div>
+        <h3 class="text-xl font-bold mb-4">Settings</h3>
+        {{template "type-settings" .}}
+        {{template "field-settings" .}}
+        {{template "action-settings" .}}
+    </div>
+</div>
+`
+
+var settingsModal = template.Must(modalTemplate("settings-modal", settingsModalHTML).Parse(typeSettingsHTML + fieldSettingsHTML + actionSettingsHTML))
+
+// renderSettings shows the settings modal, refreshing the contact list out
+// of band after a change so cards pick it up
+func renderSettings(w http.ResponseWriter, changed bool) {
+	w.Header().Set("Content-Type", "text/html")
+	err := settingsModal.Execute(w, map[string]any{
+		"Types":      contactTypes.List(),
+		"TypeCounts": ofType(store.All()),
+		"New":        ContactTypeDef{Colour: "gray"},
+		"Fields":     customFields.List(),
+		"NewField":   FieldDef{Type: "text"},
+		"Actions":    actionChoices(),
+	})
+	if err != nil {
+		fmt.Printf("Error rendering settings: %v\n", err)
+		return
+	}
+	if changed {
+		renderContactListOOB(w)
+	}
+}
+
+func settingsView(w http.ResponseWriter, r *http.Request) {
+	renderSettings(w, false)
+}
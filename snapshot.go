@@ -0,0 +1,480 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// snapshot settings, set from flags
+var (
+	snapshotDir      = "backups"
+	snapshotInterval = time.Hour
+	snapshotKeep     = "24h,14d,12m"
+)
+
+const snapshotTimeFormat = "20060102-150405"
+
+var snapshotName = regexp.MustCompile(`^snapshot-(\d{8}-\d{6})\.json$`)
+
+// Snapshot is one point in time copy of the contact data, trash included,
+// kept in the same envelope format as AFcb.json
+type Snapshot struct {
+	Name string
+	At   time.Time
+	Size int64
+}
+
+// retentionRule keeps the newest snapshot in each of the last Count
+// periods of Unit: h hourly, d daily, w weekly, m monthly or y yearly
+type retentionRule struct {
+	Unit  byte
+	Count int
+}
+
+// parseRetention reads rules such as "24h,14d,12m"
+func parseRetention(spec string) ([]retentionRule, error) {
+	var rules []retentionRule
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		unit := part[len(part)-1]
+		count, err := strconv.Atoi(part[:len(part)-1])
+		if err != nil || count < 0 || !strings.ContainsRune("hdwmy", rune(unit)) {
+			return nil, fmt.Errorf("invalid retention rule %q, expected a count and one of h, d, w, m or y such as 14d", part)
+		}
+		rules = append(rules, retentionRule{Unit: unit, Count: count})
+	}
+	return rules, nil
+}
+
+// period names the hour, day, week, month or year t falls in
+func (r retentionRule) period(t time.Time) string {
+	switch r.Unit {
+	case 'h':
+		return t.Format("2006010215")
+	case 'd':
+		return t.Format("20060102")
+	case 'w':
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-%d", year, week)
+	case 'm':
+		return t.Format("200601")
+	default:
+		return t.Format("2006")
+	}
+}
+
+// listSnapshots returns the snapshots in dir, newest first
+func listSnapshots(dir string) ([]Snapshot, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read directory %s: %w", dir, err)
+	}
+	var snapshots []Snapshot
+	for _, entry := range entries {
+		m := snapshotName.FindStringSubmatch(entry.Name())
+		if m == nil || entry.IsDir() {
+			continue
+		}
+		at, err := time.ParseInLocation(snapshotTimeFormat, m[1], time.Local)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, Snapshot{Name: entry.Name(), At: at, Size: info.Size()})
+	}
+	sort.Slice(snapshots, func(i, k int) bool {
+		return snapshots[i].At.After(snapshots[k].At)
+	})
+	return snapshots, nil
+}
+
+// snapshotPath returns the file of a snapshot, rejecting other names
+func snapshotPath(dir, name string) (string, error) {
+	if !snapshotName.MatchString(name) {
+		return "", fmt.Errorf("invalid snapshot name %q", name)
+	}
+	return filepath.Join(dir, name), nil
+}
+
+func readSnapshot(dir, name string) (Contacts, error) {
+	path, err := snapshotPath(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := readDataFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("No snapshot found named %s", name)
+		}
+		return nil, err
+	}
+	contacts, _, err := parseContacts(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return contacts, nil
+}
+
+// writeSnapshot saves contacts as a new snapshot. unless force is set it
+// is skipped when nothing changed since the newest one
+func writeSnapshot(dir string, contacts Contacts, force bool) (Snapshot, bool, error) {
+	snapshots, err := listSnapshots(dir)
+	if err != nil {
+		return Snapshot{}, false, err
+	}
+	if !force && len(snapshots) > 0 {
+		if latest, err := readSnapshot(dir, snapshots[0].Name); err == nil && sameContacts(latest, contacts) {
+			return snapshots[0], false, nil
+		}
+	}
+
+	now := time.Now()
+	//names have one second resolution, so wait out a clash with the newest
+	if len(snapshots) > 0 && !now.Truncate(time.Second).After(snapshots[0].At) {
+		now = snapshots[0].At.Add(time.Second)
+	}
+	name := "snapshot-" + now.Format(snapshotTimeFormat) + ".json"
+	data, err := encodeContactsFile(contacts)
+	if err != nil {
+		return Snapshot{}, false, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return Snapshot{}, false, fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+	if err := writeDataFile(filepath.Join(dir, name), data); err != nil {
+		return Snapshot{}, false, err
+	}
+	return Snapshot{Name: name, At: now.Truncate(time.Second), Size: int64(len(data))}, true, nil
+}
+
+func sameContacts(a, b Contacts) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(ja, jb)
+}
+
+// snapshotsToKeep applies the retention rules to snapshots sorted newest
+// first. the newest snapshot is always kept
+func snapshotsToKeep(snapshots []Snapshot, rules []retentionRule) map[string]bool {
+	keep := map[string]bool{}
+	if len(snapshots) > 0 {
+		keep[snapshots[0].Name] = true
+	}
+	for _, rule := range rules {
+		seen := map[string]bool{}
+		for _, snapshot := range snapshots {
+			if len(seen) == rule.Count {
+				break
+			}
+			period := rule.period(snapshot.At)
+			if seen[period] {
+				continue
+			}
+			seen[period] = true
+			keep[snapshot.Name] = true
+		}
+	}
+	return keep
+}
+
+// pruneSnapshots removes snapshots no retention rule keeps
+func pruneSnapshots(dir string, rules []retentionRule) ([]string, error) {
+	snapshots, err := listSnapshots(dir)
+	if err != nil {
+		return nil, err
+	}
+	keep := snapshotsToKeep(snapshots, rules)
+	var removed []string
+	for _, snapshot := range snapshots {
+		if keep[snapshot.Name] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, snapshot.Name)); err != nil && !os.IsNotExist(err) {
+			return removed, fmt.Errorf("failed to remove snapshot %s: %w", snapshot.Name, err)
+		}
+		removed = append(removed, snapshot.Name)
+	}
+	return removed, nil
+}
+
+// resealSnapshots writes every snapshot again under the current data key
+func resealSnapshots(dir string) error {
+	snapshots, err := listSnapshots(dir)
+	if err != nil {
+		return err
+	}
+	for _, snapshot := range snapshots {
+		path := filepath.Join(dir, snapshot.Name)
+		data, err := readDataFile(path)
+		if err != nil {
+			return err
+		}
+		if err := writeDataFile(path, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// contactChange is a contact restoring a snapshot would change
+type contactChange struct {
+	Contact Contact
+	Changes []FieldChange
+}
+
+// snapshotDiff is what restoring a snapshot would do to the current data
+type snapshotDiff struct {
+	Added   Contacts
+	Removed Contacts
+	Changed []contactChange
+}
+
+func (d snapshotDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+func diffSnapshot(current, snapshot Contacts) snapshotDiff {
+	var diff snapshotDiff
+	now := map[string]Contact{}
+	for _, contact := range current {
+		now[contact.ID] = contact
+	}
+	for _, contact := range snapshot {
+		before, ok := now[contact.ID]
+		delete(now, contact.ID)
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, contact)
+		case !sameContact(before, contact):
+			diff.Changed = append(diff.Changed, contactChange{Contact: contact, Changes: diffContacts(before, contact)})
+		}
+	}
+	for _, contact := range current {
+		if _, ok := now[contact.ID]; ok {
+			diff.Removed = append(diff.Removed, contact)
+		}
+	}
+	return diff
+}
+
+// takeSnapshot writes a snapshot of the store. old snapshots are only
+// pruned on schedule, so one taken by hand or before a restore does not
+// push out the snapshot it was meant to sit beside
+func takeSnapshot(force bool) (Snapshot, bool, error) {
+	return writeSnapshot(snapshotDir, store.All(), force)
+}
+
+// pruneOldSnapshots applies snapshotKeep to the snapshot directory
+func pruneOldSnapshots() error {
+	rules, err := parseRetention(snapshotKeep)
+	if err != nil {
+		return err
+	}
+	removed, err := pruneSnapshots(snapshotDir, rules)
+	if len(removed) > 0 {
+		fmt.Printf("Removed %d snapshots past retention %s\n", len(removed), snapshotKeep)
+	}
+	return err
+}
+
+// startSnapshots takes a snapshot every snapshotInterval in the background,
+// starting now if the newest one is older than that
+func startSnapshots() {
+	if snapshotInterval <= 0 {
+		return
+	}
+	take := func() {
+		snapshot, written, err := takeSnapshot(false)
+		if err != nil {
+			fmt.Printf("Error taking snapshot: %v\n", err)
+			return
+		}
+		if written {
+			fmt.Printf("Saved snapshot %s\n", filepath.Join(snapshotDir, snapshot.Name))
+		}
+		if err := pruneOldSnapshots(); err != nil {
+			fmt.Printf("Error pruning snapshots: %v\n", err)
+		}
+	}
+	if snapshots, err := listSnapshots(snapshotDir); err != nil || len(snapshots) == 0 || time.Since(snapshots[0].At) >= snapshotInterval {
+		take()
+	}
+	go func() {
+		for range time.Tick(snapshotInterval) {
+			take()
+		}
+	}()
+}
+
+var snapshotsModalHTML = `
+<div id="contact-modal" class="fixed inset-0 bg-gray-600 bg-opacity-50 overflow-y-auto h-full w-full">
+    <div class="relative top-20 mx-auto p-5 border w-full max-w-2xl shadow-lg rounded-md bg-white">
+        <div class="flex justify-end">
+            <button hx-target="#contact-modal" hx-swap="outerHTML" hx-get="/modal/close" class="text-gray-400 hover:text-gray-600">&times;</button>
+        </div>
+        {{if .Restored}}
+        <div hx-get="/contacts" hx-target="#contact-list" hx-swap="innerHTML" hx-trigger="load"></div>
+        {{end}}
+        <div class="flex justify-between items-center mb-1">
+            <h3 class="text-xl font-bold">Snapshots</h3>
+            <button class="px-3 py-1 text-sm rounded-lg bg-blue-600 text-white font-bold hover:bg-blue-700 transition-colors"
+                hx-post="/admin/snapshots"
+                hx-target="#contact-modal"
+                hx-swap="outerHTML">
+                Take snapshot now
+            </button>
+        </div>
+        <p class="text-sm text-gray-500 mb-4">Kept in {{.Dir}}, retention {{.Keep}}.</p>
+        {{if .Message}}
+        <div class="p-3 mb-4 bg-green-100 text-green-800 rounded-lg">{{.Message}}</div>
+        {{end}}
+        {{if not .Snapshots}}
+        <div class="p-4 bg-gray-100 text-gray-500 rounded-lg">No snapshots yet.</div>
+        {{end}}
+        {{range .Snapshots}}
+        <div class="flex justify-between items-center p-3 mb-2 border rounded-lg">
+            <div>
+                <strong class="text-gray-800">{{.At.Format "2 Jan 2006 15:04:05"}}</strong>
+                <span class="block text-xs text-gray-500">{{.Name}} &middot; {{.Size}} bytes</span>
+            </div>
+            <div class="space-x-2">
+                <button class="px-3 py-1 text-sm rounded-lg border border-gray-300 hover:border-blue-500 hover:bg-blue-50 transition-colors"
+                    hx-get="/admin/snapshots/{{.Name}}"
+                    hx-target="#snapshot-preview"
+                    hx-swap="innerHTML">
+                    Preview
+                </button>
+                <button class="px-3 py-1 text-sm rounded-lg border border-gray-300 hover:border-red-500 hover:bg-red-50 transition-colors"
+                    hx-post="/admin/snapshots/{{.Name}}/restore"
+                    hx-target="#contact-modal"
+                    hx-swap="outerHTML"
+                    hx-confirm="Replace all contacts with this snapshot? A snapshot of the current data is taken first.">
+                    Restore
+                </button>
+            </div>
+        </div>
+        {{end}}
+        <div id="snapshot-preview" class="mt-4"></div>
+    </div>
+</div>
+`
+
+var snapshotsModal = template.Must(template.New("snapshots-modal").Parse(snapshotsModalHTML))
+
+var snapshotPreviewHTML = `
+<h4 class="font-semibold text-gray-800 mb-2">Restoring {{.Name}} would</h4>
+{{if .Diff.Empty}}
+<div class="p-3 bg-gray-100 text-gray-500 rounded-lg">change nothing, it matches the current data.</div>
+{{end}}
+{{range .Diff.Added}}
+<div class="p-2 mb-1 rounded bg-green-50 text-green-800 text-sm">bring back {{.FirstName}} {{.LastName}}</div>
+{{end}}
+{{range .Diff.Removed}}
+<div class="p-2 mb-1 rounded bg-red-50 text-red-800 text-sm">remove {{.FirstName}} {{.LastName}}</div>
+{{end}}
+{{range .Diff.Changed}}
+<div class="p-2 mb-1 rounded bg-yellow-50 text-sm">
+    <span class="text-yellow-800">change {{.Contact.FirstName}} {{.Contact.LastName}}</span>
+    <ul class="mt-1">
+        {{range .Changes}}
+        <li><span class="text-gray-500">{{.Field}}:</span> <span class="line-through text-red-600">{{.Old}}</span> <span class="text-green-700">{{.New}}</span></li>
+        {{end}}
+    </ul>
+</div>
+{{end}}
+`
+
+var snapshotPreview = template.Must(template.New("snapshot-preview").Parse(snapshotPreviewHTML))
+
+func renderSnapshots(w http.ResponseWriter, message string, restored bool) {
+	snapshots, err := listSnapshots(snapshotDir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	err = snapshotsModal.Execute(w, map[string]any{
+		"Dir":       snapshotDir,
+		"Keep":      snapshotKeep,
+		"Snapshots": snapshots,
+		"Message":   message,
+		"Restored":  restored,
+	})
+	if err != nil {
+		fmt.Printf("Error rendering snapshots: %v\n", err)
+	}
+}
+
+func snapshotsView(w http.ResponseWriter, r *http.Request) {
+	renderSnapshots(w, "", false)
+}
+
+func createSnapshot(w http.ResponseWriter, r *http.Request) {
+	snapshot, _, err := takeSnapshot(true)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Printf("Saved snapshot %s\n", snapshot.Name)
+	renderSnapshots(w, "Saved "+snapshot.Name+".", false)
+}
+
+// previewSnapshot shows what restoring a snapshot would change
+func previewSnapshot(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	contacts, err := readSnapshot(snapshotDir, name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	err = snapshotPreview.Execute(w, map[string]any{
+		"Name": name,
+		"Diff": diffSnapshot(store.All(), contacts),
+	})
+	if err != nil {
+		fmt.Printf("Error rendering snapshot preview: %v\n", err)
+	}
+}
+
+// restoreSnapshot replaces every contact with a snapshot, after taking a
+// snapshot of the current data so the restore can itself be undone
+func restoreSnapshot(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	contacts, err := readSnapshot(snapshotDir, name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if _, _, err := takeSnapshot(false); err != nil {
+		http.Error(w, "Not restoring, failed to snapshot the current data first: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := store.ReplaceAll(contacts, currentUser(r), "snapshot"); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Printf("Restored snapshot %s\n", name)
+	renderSnapshots(w, "Restored "+name+".", true)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseRetention(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []retentionRule
+		err  bool
+	}{
+		{spec: "24h,14d,12m", want: []retentionRule{{'h', 24}, {'d', 14}, {'m', 12}}},
+		{spec: " 4w , 2y ,", want: []retentionRule{{'w', 4}, {'y', 2}}},
+		{spec: ""},
+		{spec: "14", err: true},
+		{spec: "14x", err: true},
+		{spec: "-1d", err: true},
+		{spec: "d", err: true},
+	}
+	for _, tt := range tests {
+		got, err := parseRetention(tt.spec)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseRetention(%q) = %v, want an error", tt.spec, got)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("parseRetention(%q) = %v, %v, want %v", tt.spec, got, err, tt.want)
+		}
+	}
+}
+
+func TestSnapshotsToKeep(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 30, 0, 0, time.Local)
+	at := func(d time.Duration) Snapshot {
+		t := now.Add(-d)
+		return Snapshot{Name: t.Format(snapshotTimeFormat), At: t}
+	}
+	//newest first, as listSnapshots returns them
+	snapshots := []Snapshot{
+		at(0),
+		at(10 * time.Minute),
+		at(1 * time.Hour),
+		at(2*time.Hour + 10*time.Minute),
+		at(26 * time.Hour),
+		at(27 * time.Hour),
+		at(5 * 24 * time.Hour),
+		at(40 * 24 * time.Hour),
+		at(400 * 24 * time.Hour),
+	}
+	names := func(indexes ...int) []string {
+		var out []string
+		for _, i := range indexes {
+			out = append(out, snapshots[i].Name)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name      string
+		snapshots []Snapshot
+		rules     string
+		want      []string
+	}{
+		{name: "no snapshots", rules: "24h"},
+		{name: "no rules keeps the newest", snapshots: snapshots, want: names(0)},
+		{name: "zero count keeps the newest", snapshots: snapshots, rules: "0d", want: names(0)},
+		{name: "hourly", snapshots: snapshots, rules: "3h", want: names(0, 2, 3)},
+		{name: "daily", snapshots: snapshots, rules: "3d", want: names(0, 4, 6)},
+		{name: "monthly", snapshots: snapshots, rules: "12m", want: names(0, 7, 8)},
+		{name: "yearly", snapshots: snapshots, rules: "5y", want: names(0, 8)},
+		{name: "combined", snapshots: snapshots, rules: "2h,2d,2m", want: names(0, 2, 4, 7)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := parseRetention(tt.rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keep := snapshotsToKeep(tt.snapshots, rules)
+			var got []string
+			for _, snapshot := range tt.snapshots {
+				if keep[snapshot.Name] {
+					got = append(got, snapshot.Name)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("kept %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteSnapshotSkipsUnchanged(t *testing.T) {
+	dir := t.TempDir()
+	contacts := Contacts{{ID: "alice", FirstName: "Alice"}}
+	tests := []struct {
+		name     string
+		contacts Contacts
+		force    bool
+		written  bool
+		count    int
+	}{
+		{name: "first", contacts: contacts, written: true, count: 1},
+		{name: "unchanged", contacts: contacts, written: false, count: 1},
+		{name: "forced", contacts: contacts, force: true, written: true, count: 2},
+		{name: "changed", contacts: Contacts{{ID: "alice", FirstName: "Alicia"}}, written: true, count: 3},
+	}
+	for _, tt := range tests {
+		_, written, err := writeSnapshot(dir, tt.contacts, tt.force)
+		if err != nil {
+			t.Fatal(err)
+		}
+		snapshots, err := listSnapshots(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if written != tt.written || len(snapshots) != tt.count {
+			t.Errorf("%s: written %v with %d snapshots, want %v with %d", tt.name, written, len(snapshots), tt.written, tt.count)
+		}
+	}
+	snapshots, _ := listSnapshots(dir)
+	latest, err := readSnapshot(dir, snapshots[0].Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest[0].FirstName != "Alicia" {
+		t.Errorf("newest snapshot holds %q, want Alicia", latest[0].FirstName)
+	}
+}
+
+func TestSnapshotRestoreKeepsPhoto(t *testing.T) {
+	//the command points the shared stores at the data it restores into
+	oldStore, oldPhotos, oldOrgs, oldTypes, oldFields := store, photos, orgs, contactTypes, customFields
+	oldGroups, oldUsage, oldActions, oldDir := groups, usage, quickActions, snapshotDir
+	t.Cleanup(func() {
+		store, photos, orgs, contactTypes, customFields = oldStore, oldPhotos, oldOrgs, oldTypes, oldFields
+		groups, usage, quickActions, snapshotDir = oldGroups, oldUsage, oldActions, oldDir
+	})
+
+	dir := t.TempDir()
+	spec := "json:" + filepath.Join(dir, "AFcb.json")
+	backups := filepath.Join(dir, "backups")
+	var picture bytes.Buffer
+	if err := png.Encode(&picture, image.NewRGBA(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+	saved := NewPhotoStore(photoDir(spec))
+	upload, err := saved.Prepare(picture.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := saved.Save(upload); err != nil {
+		t.Fatal(err)
+	}
+	storage := NewJSONFileStorage(filepath.Join(dir, "AFcb.json"))
+	if err := storage.Put(Contact{ID: "ann", Version: 1, FirstName: "Ann", Photo: upload.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := snapshotCommand([]string{"create", "-storage", spec, "-dir", backups}); err != nil {
+		t.Fatal(err)
+	}
+	snapshots, err := listSnapshots(backups)
+	if err != nil || len(snapshots) != 1 {
+		t.Fatalf("took %d snapshots, %v", len(snapshots), err)
+	}
+	if err := storage.Put(Contact{ID: "ann", Version: 2, FirstName: "Ann"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := snapshotCommand([]string{"restore", "-storage", spec, "-dir", backups, snapshots[0].Name}); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := NewJSONFileStorage(filepath.Join(dir, "AFcb.json")).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored) != 1 || restored[0].Photo != upload.ID {
+		t.Errorf("restored %+v, want ann with photo %s", restored, upload.ID)
+	}
+}
@@ -14,3 +14,35 @@ function copyEmail(elementID) {
       console.error("Failed to copy email: ", err);
     });
 }
+
+//a stale edit answers 409 with a merge modal, swap it in instead of failing
+document.addEventListener("htmx:beforeSwap", (event) => {
+  if (event.detail.xhr.status === 409) {
+    event.detail.shouldSwap = true;
+    event.detail.isError = false;
+  }
+});
+
+//copy the lines of a hidden list, such as the phone numbers of a group
+function copyLines(elementID) {
+  const element = document.getElementById(elementID);
+  if (!element) return;
+
+  navigator.clipboard
+    .writeText(element.textContent.trim())
+    .then(() => {
+      console.log("Copied");
+    })
+    .catch((err) => {
+      console.error("Failed to copy: ", err);
+    });
+}
+
+//count a quick action on a card toward the frequently contacted view. a
+//beacon still goes out when the action leaves the page
+function recordUse(contactID, action) {
+  navigator.sendBeacon(
+    "/contacts/" + contactID + "/used",
+    new URLSearchParams({ action: action }),
+  );
+}
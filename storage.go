@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Storage persists contacts for a ContactStore. backends are picked at
+// startup with a spec such as "json:AFcb.json", "journal:AFcb.json",
+// "dir:contacts" or "memory"
+type Storage interface {
+	//load reads every contact from the backend, recovering if it can
+	Load() (Contacts, error)
+	Get(id string) (Contact, error)
+	Put(contact Contact) error
+	Delete(id string) error
+	List() (Contacts, error)
+	//transaction stages changes made through tx and commits them together
+	//when fn returns nil, or discards them when fn returns an error
+	Transaction(fn func(tx StorageTx) error) error
+}
+
+// rewriter is implemented by backends that can write all their data out
+// again, used to encrypt it or change its key in place
+type rewriter interface {
+	Rewrite() error
+}
+
+// StorageTx is the view of a backend inside a transaction
+type StorageTx interface {
+	Get(id string) (Contact, error)
+	Put(contact Contact) error
+	Delete(id string) error
+}
+
+// openStorage creates the backend named by spec, "kind:path"
+func openStorage(spec string) (Storage, error) {
+	kind, path, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "json":
+		if path == "" {
+			path = dataFile
+		}
+		return NewJSONFileStorage(path), nil
+	case "journal":
+		if path == "" {
+			path = dataFile
+		}
+		return NewJournalStorage(path), nil
+	case "dir":
+		if path == "" {
+			path = "contacts"
+		}
+		return NewDirStorage(path), nil
+	case "memory":
+		return NewMemoryStorage(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q, expected json, journal, dir or memory", kind)
+	}
+}
+
+// sidecarPath returns where a companion file such as the revision history
+// lives for the backend named by spec: next to a json or journal file, as a
+// dotfile inside a dir, or "" for memory so nothing is written to disk
+func sidecarPath(spec, name string) string {
+	kind, path, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "json", "journal":
+		if path == "" {
+			path = dataFile
+		}
+		return strings.TrimSuffix(path, filepath.Ext(path)) + "." + name + ".json"
+	case "dir":
+		if path == "" {
+			path = "contacts"
+		}
+		return filepath.Join(path, "."+name+".json")
+	default:
+		return ""
+	}
+}
+
+// sidecarStore is a store kept in a companion file found with sidecarPath
+type sidecarStore interface {
+	Load() error
+	//save writes the file again, used when the data key changes
+	Save() error
+}
+
+// sidecarNames lists the companion files of every sidecar store, as passed
+// to sidecarPath
+var sidecarNames = []string{"history", "organizations", "types", "fields", "groups", "usage", "actions"}
+
+// stagedTx records puts and deletes on top of a backend until commit
+type stagedTx struct {
+	get     func(id string) (Contact, error)
+	puts    map[string]Contact
+	order   []string
+	deletes map[string]bool
+}
+
+func newStagedTx(get func(id string) (Contact, error)) *stagedTx {
+	return &stagedTx{
+		get:     get,
+		puts:    map[string]Contact{},
+		deletes: map[string]bool{},
+	}
+}
+
+func (t *stagedTx) Get(id string) (Contact, error) {
+	if t.deletes[id] {
+		return Contact{}, fmt.Errorf("No contact found with id %s", id)
+	}
+	if contact, ok := t.puts[id]; ok {
+		return contact, nil
+	}
+	return t.get(id)
+}
+
+func (t *stagedTx) Put(contact Contact) error {
+	if contact.ID == "" {
+		return errors.New("cannot store contact without ID")
+	}
+	if _, ok := t.puts[contact.ID]; !ok {
+		t.order = append(t.order, contact.ID)
+	}
+	t.puts[contact.ID] = contact
+	delete(t.deletes, contact.ID)
+	return nil
+}
+
+func (t *stagedTx) Delete(id string) error {
+	if _, err := t.Get(id); err != nil {
+		return err
+	}
+	delete(t.puts, id)
+	t.deletes[id] = true
+	return nil
+}
+
+// changed returns staged puts in the order they were first made
+func (t *stagedTx) changed() Contacts {
+	var out Contacts
+	for _, id := range t.order {
+		if contact, ok := t.puts[id]; ok {
+			out = append(out, contact)
+		}
+	}
+	return out
+}
+
+// apply returns a copy of contacts with the staged changes applied
+func (t *stagedTx) apply(contacts Contacts) Contacts {
+	out := contacts.clone()
+	for _, contact := range t.changed() {
+		out.put(contact)
+	}
+	for id := range t.deletes {
+		out.Delete(id)
+	}
+	return out
+}
+
+// MemoryStorage keeps contacts in memory only, for tests and demos
+type MemoryStorage struct {
+	mu       sync.Mutex
+	contacts Contacts
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{contacts: Contacts{}}
+}
+
+func (m *MemoryStorage) Load() (Contacts, error) {
+	return m.List()
+}
+
+func (m *MemoryStorage) Get(id string) (Contact, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.contacts.Find(id)
+}
+
+func (m *MemoryStorage) Put(contact Contact) error {
+	return m.Transaction(func(tx StorageTx) error {
+		return tx.Put(contact)
+	})
+}
+
+func (m *MemoryStorage) Delete(id string) error {
+	return m.Transaction(func(tx StorageTx) error {
+		return tx.Delete(id)
+	})
+}
+
+func (m *MemoryStorage) List() (Contacts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.contacts.clone(), nil
+}
+
+func (m *MemoryStorage) Transaction(fn func(tx StorageTx) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx := newStagedTx(m.contacts.Find)
+	if err := fn(tx); err != nil {
+		return err
+	}
+	m.contacts = tx.apply(m.contacts)
+	return nil
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DirStorage keeps one JSON file per contact, named by contact ID, so a
+// change touches a single small file and diffs cleanly under version
+// control. contacts are listed in ID order. the schema version of the
+// files is kept in a .schema.json dotfile beside them
+type DirStorage struct {
+	mu      sync.Mutex
+	dir     string
+	version int
+}
+
+func NewDirStorage(dir string) *DirStorage {
+	return &DirStorage{dir: dir, version: schemaVersion}
+}
+
+func (d *DirStorage) versionPath() string {
+	return filepath.Join(d.dir, ".schema.json")
+}
+
+// schema version the files on disk were written with, 0 before versioning
+func (d *DirStorage) readVersion() (int, error) {
+	var marker struct{ Version int }
+	if err := loadJSONFile(d.versionPath(), &marker); err != nil {
+		return 0, err
+	}
+	return marker.Version, nil
+}
+
+// path of the file for a contact id, rejecting ids that would escape dir
+func (d *DirStorage) path(id string) (string, error) {
+	if id == "" || strings.HasPrefix(id, ".") || strings.ContainsAny(id, `/\`) {
+		return "", fmt.Errorf("invalid contact id %q", id)
+	}
+	return filepath.Join(d.dir, id+".json"), nil
+}
+
+func (d *DirStorage) Load() (Contacts, error) {
+	if err := os.MkdirAll(d.dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %w", d.dir, err)
+	}
+	version, err := d.readVersion()
+	if err != nil {
+		return nil, err
+	}
+	if version > schemaVersion {
+		return nil, fmt.Errorf("%s is schema version %d but this AFcb only understands up to %d, upgrade AFcb first", d.dir, version, schemaVersion)
+	}
+
+	d.mu.Lock()
+	d.version = version
+	d.mu.Unlock()
+
+	contacts, err := d.List()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Successfully loaded %d contacts from %s\n", len(contacts), d.dir)
+
+	if version < schemaVersion {
+		if err := d.upgrade(contacts, version); err != nil {
+			return nil, err
+		}
+	}
+	return contacts, nil
+}
+
+// upgrade copies the old files into a backup directory, then rewrites every
+// contact in the current schema and records the new version
+func (d *DirStorage) upgrade(contacts Contacts, from int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(contacts) > 0 {
+		backup := filepath.Join(d.dir, fmt.Sprintf(".backup-v%d-%s", from, time.Now().Format("20060102-150405")))
+		if err := os.MkdirAll(backup, 0755); err != nil {
+			return fmt.Errorf("failed to create backup directory %s: %w", backup, err)
+		}
+		for _, contact := range contacts {
+			path, err := d.path(contact.ID)
+			if err != nil {
+				return err
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("failed to read file %s: %w", path, err)
+			}
+			if err := writeFileAtomic(filepath.Join(backup, filepath.Base(path)), data, dataFilePerm); err != nil {
+				return fmt.Errorf("failed to back up %s before upgrade: %w", path, err)
+			}
+		}
+		fmt.Printf("Backed up %d contact files from schema v%d to %s\n", len(contacts), from, backup)
+	}
+
+	for _, contact := range contacts {
+		if err := d.write(contact); err != nil {
+			return err
+		}
+	}
+	if err := saveJSONFile(d.versionPath(), map[string]int{"Version": schemaVersion}); err != nil {
+		return err
+	}
+	d.version = schemaVersion
+	if len(contacts) > 0 {
+		fmt.Printf("Upgraded %s from schema v%d to v%d\n", d.dir, from, schemaVersion)
+	}
+	return nil
+}
+
+// schemaReport works out what an upgrade would change without writing
+func (d *DirStorage) schemaReport() (migrationReport, error) {
+	version, err := d.readVersion()
+	if err != nil {
+		return migrationReport{}, err
+	}
+	entries, err := os.ReadDir(d.dir)
+	if err != nil && !os.IsNotExist(err) {
+		return migrationReport{}, fmt.Errorf("failed to read directory %s: %w", d.dir, err)
+	}
+	var records []map[string]any
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || filepath.Ext(name) != ".json" {
+			continue
+		}
+		var record map[string]any
+		if err := loadJSONFile(filepath.Join(d.dir, name), &record); err != nil {
+			return migrationReport{}, err
+		}
+		records = append(records, record)
+	}
+	return migrateRecords(records, version)
+}
+
+func (d *DirStorage) Get(id string) (Contact, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.read(id)
+}
+
+func (d *DirStorage) read(id string) (Contact, error) {
+	path, err := d.path(id)
+	if err != nil {
+		return Contact{}, err
+	}
+	data, err := readDataFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Contact{}, fmt.Errorf("No contact found with id %s", id)
+		}
+		return Contact{}, fmt.Errorf("failed to read file %s: %w", path, err)
+	}
+	contact, err := decodeContact(data, d.version)
+	if err != nil {
+		return Contact{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return contact, nil
+}
+
+// write saves one contact file atomically
+func (d *DirStorage) write(contact Contact) error {
+	path, err := d.path(contact.ID)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(contact, "", " ")
+	if err != nil {
+		return fmt.Errorf("Failed to marshal contact %s: %w", contact.ID, err)
+	}
+	if err := writeDataFile(path, data); err != nil {
+		return fmt.Errorf("Failed to write file %s: %w", path, err)
+	}
+	return nil
+}
+
+func (d *DirStorage) Put(contact Contact) error {
+	return d.Transaction(func(tx StorageTx) error {
+		return tx.Put(contact)
+	})
+}
+
+func (d *DirStorage) Delete(id string) error {
+	return d.Transaction(func(tx StorageTx) error {
+		return tx.Delete(id)
+	})
+}
+
+func (d *DirStorage) List() (Contacts, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Contacts{}, nil
+		}
+		return nil, fmt.Errorf("failed to read directory %s: %w", d.dir, err)
+	}
+
+	contacts := Contacts{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || filepath.Ext(name) != ".json" {
+			continue
+		}
+		contact, err := d.read(strings.TrimSuffix(name, ".json"))
+		if err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, contact)
+	}
+	sort.Slice(contacts, func(i, k int) bool {
+		return contacts[i].ID < contacts[k].ID
+	})
+	return contacts, nil
+}
+
+// rewrite writes every contact file again under the current data key
+func (d *DirStorage) Rewrite() error {
+	contacts, err := d.List()
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, contact := range contacts {
+		if err := d.write(contact); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// transaction writes each changed contact file atomically, then removes
+// deleted ones. a crash part way leaves every file whole, though only
+// some of the changes may have landed
+func (d *DirStorage) Transaction(fn func(tx StorageTx) error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx := newStagedTx(d.read)
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(d.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", d.dir, err)
+	}
+	for _, contact := range tx.changed() {
+		if err := d.write(contact); err != nil {
+			return err
+		}
+	}
+	for id := range tx.deletes {
+		path, err := d.path(id)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+	}
+	return nil
+}
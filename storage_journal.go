@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// thresholds past which the compactor folds the journal into the snapshot
+var (
+	journalMaxSize int64         = 1 << 20
+	journalMaxAge  time.Duration = time.Hour
+)
+
+// journalRecord is one line of the journal
+type journalRecord struct {
+	Op      string // create, update or delete
+	ID      string
+	Contact *Contact `json:",omitempty"`
+	Time    time.Time
+	Version int // schema version of Contact, 0 before versioning
+}
+
+// JournalStorage keeps a snapshot file such as AFcb.json plus an append-only
+// journal next to it. each commit appends one record per change instead of
+// rewriting the snapshot, and a background compactor folds the journal
+// back into the snapshot once it grows too large or too old
+type JournalStorage struct {
+	mu       sync.Mutex
+	snapshot string
+	journal  string
+	contacts Contacts
+	file     *os.File
+	size     int64
+	oldest   time.Time
+}
+
+func NewJournalStorage(snapshot string) *JournalStorage {
+	return &JournalStorage{
+		snapshot: snapshot,
+		journal:  snapshot + ".journal",
+		contacts: Contacts{},
+	}
+}
+
+// load reads the snapshot and replays the journal on top of it. replaying
+// is idempotent, so a crash between writing a snapshot and truncating the
+// journal only replays changes the snapshot already holds
+func (j *JournalStorage) Load() (Contacts, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	loaded := Contacts{}
+	if err := loaded.LoadContacts(j.snapshot); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(j.journal)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read journal %s: %w", j.journal, err)
+	}
+
+	var good int64
+	replayed := 0
+	j.oldest = time.Time{}
+	for good < int64(len(data)) {
+		line, _, found := bytes.Cut(data[good:], []byte("\n"))
+		if !found {
+			//an append cut short by a crash, that change was never committed
+			fmt.Printf("WARNING: dropping incomplete last record in %s\n", j.journal)
+			break
+		}
+		plain, err := openData(line)
+		if err != nil {
+			return nil, fmt.Errorf("journal %s record at byte %d: %w", j.journal, good, err)
+		}
+		var raw struct {
+			journalRecord
+			Contact json.RawMessage
+		}
+		if err := json.Unmarshal(plain, &raw); err != nil {
+			return nil, fmt.Errorf("journal %s is damaged at byte %d: %w", j.journal, good, err)
+		}
+		//records from older versions are upgraded as they are replayed
+		rec := raw.journalRecord
+		if len(raw.Contact) > 0 && string(raw.Contact) != "null" {
+			contact, err := decodeContact(raw.Contact, rec.Version)
+			if err != nil {
+				return nil, fmt.Errorf("journal %s record at byte %d: %w", j.journal, good, err)
+			}
+			rec.Contact = &contact
+		}
+		applyJournalRecord(&loaded, rec)
+		if j.oldest.IsZero() {
+			j.oldest = rec.Time
+		}
+		good += int64(len(line)) + 1
+		replayed++
+	}
+
+	if j.file != nil {
+		j.file.Close()
+	}
+	j.file, err = os.OpenFile(j.journal, os.O_CREATE|os.O_WRONLY|os.O_APPEND, dataFilePerm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal %s: %w", j.journal, err)
+	}
+	//cut off a torn record so new appends start on a clean line
+	if err := j.file.Truncate(good); err != nil {
+		return nil, fmt.Errorf("failed to truncate journal %s: %w", j.journal, err)
+	}
+	j.size = good
+	j.contacts = loaded
+
+	fmt.Printf("Replayed %d journal records from %s\n", replayed, j.journal)
+	return j.contacts.clone(), nil
+}
+
+func applyJournalRecord(c *Contacts, rec journalRecord) {
+	switch rec.Op {
+	case "create", "update":
+		if rec.Contact != nil {
+			c.put(*rec.Contact)
+		}
+	case "delete":
+		c.Delete(rec.ID)
+	}
+}
+
+func (j *JournalStorage) Get(id string) (Contact, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.contacts.Find(id)
+}
+
+func (j *JournalStorage) Put(contact Contact) error {
+	return j.Transaction(func(tx StorageTx) error {
+		return tx.Put(contact)
+	})
+}
+
+func (j *JournalStorage) Delete(id string) error {
+	return j.Transaction(func(tx StorageTx) error {
+		return tx.Delete(id)
+	})
+}
+
+func (j *JournalStorage) List() (Contacts, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.contacts.clone(), nil
+}
+
+// transaction appends every staged change in a single synced write
+func (j *JournalStorage) Transaction(fn func(tx StorageTx) error) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return fmt.Errorf("journal %s is not open, load it first", j.journal)
+	}
+
+	tx := newStagedTx(j.contacts.Find)
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var buf bytes.Buffer
+	for _, contact := range tx.changed() {
+		op := "create"
+		if _, err := j.contacts.Find(contact.ID); err == nil {
+			op = "update"
+		}
+		if err := appendJournalRecord(&buf, journalRecord{Op: op, ID: contact.ID, Contact: &contact, Time: now, Version: schemaVersion}); err != nil {
+			return err
+		}
+	}
+	for id := range tx.deletes {
+		if err := appendJournalRecord(&buf, journalRecord{Op: "delete", ID: id, Time: now, Version: schemaVersion}); err != nil {
+			return err
+		}
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+
+	if _, err := j.file.Write(buf.Bytes()); err != nil {
+		//drop whatever part of the write landed so the journal stays whole
+		j.file.Truncate(j.size)
+		return fmt.Errorf("failed to append to journal %s: %w", j.journal, err)
+	}
+	if err := j.file.Sync(); err != nil {
+		//the records may still reach disk, so take them back out rather
+		//than have a change the caller was told failed come back on load
+		j.file.Truncate(j.size)
+		return fmt.Errorf("failed to sync journal %s: %w", j.journal, err)
+	}
+
+	j.size += int64(buf.Len())
+	if j.oldest.IsZero() {
+		j.oldest = now
+	}
+	j.contacts = tx.apply(j.contacts)
+	return nil
+}
+
+func appendJournalRecord(buf *bytes.Buffer, rec journalRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal journal record: %w", err)
+	}
+	//sealed records are compact JSON too, so each stays on one line
+	data, err = sealData(data)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt journal record: %w", err)
+	}
+	buf.Write(data)
+	buf.WriteByte('\n')
+	return nil
+}
+
+// needsCompaction reports whether the journal passed its size or age limit
+func (j *JournalStorage) needsCompaction() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.size == 0 {
+		return false
+	}
+	return j.size >= journalMaxSize || time.Since(j.oldest) >= journalMaxAge
+}
+
+// compact writes the current contacts as a new snapshot, then empties the journal
+func (j *JournalStorage) Compact() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil || j.size == 0 {
+		return nil
+	}
+	return j.compact()
+}
+
+// rewrite writes a fresh snapshot under the current data key and empties
+// the journal, even when there is nothing to compact
+func (j *JournalStorage) Rewrite() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return fmt.Errorf("journal %s is not open, load it first", j.journal)
+	}
+	return j.compact()
+}
+
+// compact does the work of Compact, callers hold the lock
+func (j *JournalStorage) compact() error {
+	if err := j.contacts.SaveToFile(j.snapshot); err != nil {
+		return err
+	}
+	if err := j.file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate journal %s: %w", j.journal, err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync journal %s: %w", j.journal, err)
+	}
+
+	fmt.Printf("Compacted journal %s (%d bytes) into %s\n", j.journal, j.size, j.snapshot)
+	j.size = 0
+	j.oldest = time.Time{}
+	return nil
+}
+
+// StartCompactor checks the journal every interval and compacts it in the
+// background when it passes journalMaxSize or journalMaxAge
+func (j *JournalStorage) StartCompactor(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if !j.needsCompaction() {
+				continue
+			}
+			if err := j.Compact(); err != nil {
+				fmt.Printf("Error compacting journal: %v\n", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJournalReplay(t *testing.T) {
+	tests := []struct {
+		name   string
+		damage func(t *testing.T, j *JournalStorage)
+		want   []string
+	}{
+		{
+			name:   "clean",
+			damage: func(t *testing.T, j *JournalStorage) {},
+			want:   []string{"bob", "carol"},
+		},
+		{
+			name: "torn last record",
+			damage: func(t *testing.T, j *JournalStorage) {
+				appendFile(t, j.journal, `{"Op":"create","ID":"dave","Contact":{"ID":"da`)
+			},
+			want: []string{"bob", "carol"},
+		},
+		{
+			name: "compacted",
+			damage: func(t *testing.T, j *JournalStorage) {
+				if err := j.Compact(); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: []string{"bob", "carol"},
+		},
+		{
+			//a crash between writing the snapshot and emptying the journal
+			name: "replayed over its own snapshot",
+			damage: func(t *testing.T, j *JournalStorage) {
+				data, err := os.ReadFile(j.journal)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := j.Compact(); err != nil {
+					t.Fatal(err)
+				}
+				appendFile(t, j.journal, string(data))
+			},
+			want: []string{"bob", "carol"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := NewJournalStorage(filepath.Join(t.TempDir(), "AFcb.json"))
+			if _, err := j.Load(); err != nil {
+				t.Fatal(err)
+			}
+			for _, id := range []string{"alice", "bob", "carol"} {
+				if err := j.Put(Contact{ID: id, FirstName: id}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := j.Delete("alice"); err != nil {
+				t.Fatal(err)
+			}
+			tt.damage(t, j)
+
+			reopened := NewJournalStorage(j.snapshot)
+			got, err := reopened.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("replayed %d contacts, want %d", len(got), len(tt.want))
+			}
+			for _, id := range tt.want {
+				if _, err := got.Find(id); err != nil {
+					t.Errorf("contact %s is missing after replay", id)
+				}
+			}
+			//appends after a torn record start on a clean line
+			if err := reopened.Put(Contact{ID: "erin"}); err != nil {
+				t.Fatal(err)
+			}
+			again, err := NewJournalStorage(j.snapshot).Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := again.Find("erin"); err != nil {
+				t.Error("contact added after reopening is missing")
+			}
+		})
+	}
+}
+
+func appendFile(t *testing.T, filename, data string) {
+	t.Helper()
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
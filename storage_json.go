@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sync"
+)
+
+// JSONFileStorage keeps every contact in a single JSON file such as
+// AFcb.json. each commit rewrites the whole file atomically. the file may
+// also be changed by hand or by a sync tool while the server runs, so its
+// hash is kept to notice when it is no longer what we last read or wrote
+type JSONFileStorage struct {
+	mu         sync.Mutex
+	filename   string
+	contacts   Contacts
+	hash       [sha256.Size]byte
+	unreadable [sha256.Size]byte // hash of an outside change already reported as unreadable
+	pending    []Conflict        // conflicts merged by a commit, not yet synced
+	merged     bool              // a commit merged outside changes, not yet synced
+}
+
+func NewJSONFileStorage(filename string) *JSONFileStorage {
+	return &JSONFileStorage{
+		filename: filename,
+		contacts: Contacts{},
+	}
+}
+
+func (j *JSONFileStorage) Load() (Contacts, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	loaded := Contacts{}
+	if err := loaded.LoadContacts(j.filename); err != nil {
+		return nil, err
+	}
+	j.contacts = loaded
+	j.remember()
+	return j.contacts.clone(), nil
+}
+
+// remember the hash of the file as we last read or wrote it
+func (j *JSONFileStorage) remember() {
+	if _, hash, ok, err := readDisk(j.filename); err == nil && ok {
+		j.hash = hash
+	}
+}
+
+// changedOnDisk reads the file again when it differs from what we last read
+// or wrote. a missing file is not a change, it is written again on the next
+// commit rather than taken as every contact being removed
+func (j *JSONFileStorage) changedOnDisk() (Contacts, [sha256.Size]byte, bool, error) {
+	data, hash, ok, err := readDisk(j.filename)
+	if err != nil || !ok || hash == j.hash {
+		return nil, hash, false, err
+	}
+	plain, err := openData(data)
+	if err == nil {
+		var theirs Contacts
+		theirs, _, err = parseContacts(plain)
+		if err == nil {
+			return theirs, hash, true, nil
+		}
+	}
+	return nil, hash, false, fmt.Errorf("%s was changed outside AFcb and cannot be read, fix or restore it: %w", j.filename, err)
+}
+
+// Sync reloads the file when it was changed outside AFcb. nothing changed
+// here since the last read or write, so the file wins apart from conflicts
+// already found by a commit
+func (j *JSONFileStorage) Sync() (Contacts, []Conflict, bool, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.merged {
+		conflicts := j.pending
+		j.merged, j.pending = false, nil
+		return j.contacts.clone(), conflicts, true, nil
+	}
+
+	theirs, hash, changed, err := j.changedOnDisk()
+	if err != nil {
+		//report an unreadable edit once rather than on every check
+		if hash == j.unreadable {
+			return nil, nil, false, nil
+		}
+		j.unreadable = hash
+		return nil, nil, false, err
+	}
+	if !changed {
+		return nil, nil, false, nil
+	}
+	merged, conflicts := mergeContacts(j.contacts, j.contacts, theirs)
+	j.contacts = merged
+	j.hash = hash
+	return merged.clone(), conflicts, true, nil
+}
+
+func (j *JSONFileStorage) Get(id string) (Contact, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.contacts.Find(id)
+}
+
+func (j *JSONFileStorage) Put(contact Contact) error {
+	return j.Transaction(func(tx StorageTx) error {
+		return tx.Put(contact)
+	})
+}
+
+func (j *JSONFileStorage) Delete(id string) error {
+	return j.Transaction(func(tx StorageTx) error {
+		return tx.Delete(id)
+	})
+}
+
+func (j *JSONFileStorage) List() (Contacts, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.contacts.clone(), nil
+}
+
+// rewrite saves the whole file again under the current data key
+func (j *JSONFileStorage) Rewrite() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.contacts.SaveToFile(j.filename); err != nil {
+		return err
+	}
+	j.remember()
+	return nil
+}
+
+func (j *JSONFileStorage) Transaction(fn func(tx StorageTx) error) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	tx := newStagedTx(j.contacts.Find)
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	updated := tx.apply(j.contacts)
+
+	//merge outside edits made since the last read instead of overwriting them
+	theirs, _, changed, err := j.changedOnDisk()
+	if err != nil {
+		return err
+	}
+	var conflicts []Conflict
+	if changed {
+		updated, conflicts = mergeContacts(j.contacts, updated, theirs)
+	}
+
+	//one write for the whole transaction
+	if err := updated.SaveToFile(j.filename); err != nil {
+		return err
+	}
+	j.contacts = updated
+	j.remember()
+	if changed {
+		j.merged = true
+		j.pending = append(j.pending, conflicts...)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestStorageRoundTrip(t *testing.T) {
+	alice := Contact{ID: "alice", FirstName: "Alice", Emails: []ContactValue{{Label: "work", Value: "alice@example.com", Primary: true}}}
+	bob := Contact{ID: "bob", FirstName: "Bob", Phones: []ContactValue{{Label: "mobile", Value: "+1 555 1234;ext=2"}}}
+	carol := Contact{ID: "carol", FirstName: "Carol", LastName: "\"the\" Carol"}
+
+	tests := []struct {
+		kind    string
+		durable bool
+	}{
+		{kind: "json", durable: true},
+		{kind: "journal", durable: true},
+		{kind: "dir", durable: true},
+		{kind: "memory"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			spec := tt.kind
+			if tt.durable {
+				spec += ":" + filepath.Join(t.TempDir(), "AFcb.json")
+			}
+			storage, err := openStorage(spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := storage.Load(); err != nil {
+				t.Fatal(err)
+			}
+			for _, contact := range []Contact{alice, bob} {
+				if err := storage.Put(contact); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := storage.Delete("alice"); err != nil {
+				t.Fatal(err)
+			}
+
+			//a failed transaction leaves nothing behind
+			failed := errors.New("failed")
+			err = storage.Transaction(func(tx StorageTx) error {
+				if err := tx.Put(carol); err != nil {
+					return err
+				}
+				return failed
+			})
+			if err != failed {
+				t.Fatalf("Transaction returned %v, want %v", err, failed)
+			}
+			if _, err := storage.Get("carol"); err == nil {
+				t.Fatal("contact put in a failed transaction was kept")
+			}
+
+			err = storage.Transaction(func(tx StorageTx) error {
+				if err := tx.Put(carol); err != nil {
+					return err
+				}
+				changed, err := tx.Get("bob")
+				if err != nil {
+					return err
+				}
+				changed.LastName = "Builder"
+				return tx.Put(changed)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := Contacts{bob, carol}
+			want[0].LastName = "Builder"
+			if !tt.durable {
+				got, _ := storage.List()
+				assertContacts(t, got, want)
+				return
+			}
+			reopened, _ := openStorage(spec)
+			got, err := reopened.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertContacts(t, got, want)
+		})
+	}
+}
@@ -0,0 +1,836 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ContactStore owns the contact list and guards it with a lock so that
+// handlers running on separate goroutines never race on the slice
+type ContactStore struct {
+	mu       sync.RWMutex
+	contacts Contacts
+	storage  Storage
+	history  *HistoryStore
+
+	conflicts []Conflict
+	reloads   int // bumped each time outside changes are picked up
+}
+
+// create new store persisted through the given backend, recording every
+// change in history
+func NewContactStore(storage Storage, history *HistoryStore) *ContactStore {
+	return &ContactStore{
+		contacts: Contacts{},
+		storage:  storage,
+		history:  history,
+	}
+}
+
+// load contacts from storage, replacing whatever is in memory
+func (s *ContactStore) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	loaded, err := s.storage.Load()
+	if err != nil {
+		return err
+	}
+	if err := s.history.Load(); err != nil {
+		return err
+	}
+	s.contacts = loaded
+	return s.backfill()
+}
+
+// backfill gives contacts saved before creation and update times were kept
+// the times of their first and last revisions, or the time of this load
+// when history has none. callers hold the lock
+func (s *ContactStore) backfill() error {
+	now := time.Now()
+	var filled Contacts
+	for _, contact := range s.contacts {
+		if !contact.CreatedAt.IsZero() {
+			continue
+		}
+		contact.CreatedAt, contact.UpdatedAt = now, now
+		var timed []Revision
+		for _, rev := range s.history.List(contact.ID) {
+			if !rev.At.IsZero() {
+				timed = append(timed, rev)
+			}
+		}
+		if len(timed) > 0 {
+			first, last := timed[0], timed[len(timed)-1]
+			contact.CreatedAt = first.At
+			if first.Action == "create" {
+				contact.CreatedBy = first.By
+			}
+			contact.UpdatedAt, contact.UpdatedBy = last.At, last.By
+		}
+		filled = append(filled, contact)
+	}
+	if len(filled) == 0 {
+		return nil
+	}
+	err := s.mutate(func(c *Contacts, tx StorageTx) error {
+		for _, contact := range filled {
+			c.put(contact)
+			if err := tx.Put(contact); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to backfill created and updated times: %w", err)
+	}
+	fmt.Printf("Backfilled created and updated times of %d contacts\n", len(filled))
+	return nil
+}
+
+// record adds a revision to history. the change itself is already saved,
+// so failing to record it is logged rather than undone
+func (s *ContactStore) record(before, after *Contact, by, action string) {
+	if err := s.history.Record(before, after, by, action); err != nil {
+		fmt.Printf("Error recording history: %v\n", err)
+	}
+}
+
+// mutate applies fn to the contact list inside a storage transaction. fn
+// records what it changed through tx; if the commit fails the in-memory
+// list is rolled back so it keeps matching storage
+func (s *ContactStore) mutate(fn func(c *Contacts, tx StorageTx) error) error {
+	before := s.contacts.clone()
+	err := s.storage.Transaction(func(tx StorageTx) error {
+		return fn(&s.contacts, tx)
+	})
+	if err != nil {
+		s.contacts = before
+		return err
+	}
+	//the commit may have merged in outside changes
+	if err := s.sync(); err != nil {
+		fmt.Printf("Error reloading contacts: %v\n", err)
+	}
+	return nil
+}
+
+// sync picks up contacts changed outside AFcb, such as a hand edited or
+// synced AFcb.json, recording what changed in history and keeping any
+// conflicts for someone to resolve. callers hold the lock
+func (s *ContactStore) sync() error {
+	w, ok := s.storage.(watcher)
+	if !ok {
+		return nil
+	}
+	reloaded, conflicts, changed, err := w.Sync()
+	if err != nil || !changed {
+		return err
+	}
+
+	old := map[string]Contact{}
+	for _, contact := range s.contacts {
+		old[contact.ID] = contact
+	}
+	n := 0
+	for _, contact := range reloaded {
+		before, ok := old[contact.ID]
+		delete(old, contact.ID)
+		switch {
+		case !ok:
+			s.record(nil, &contact, "", "reload")
+		case !sameContact(before, contact):
+			s.record(&before, &contact, "", "reload")
+		default:
+			continue
+		}
+		n++
+	}
+	for _, before := range old {
+		s.record(&before, nil, "", "reload")
+		n++
+	}
+
+	s.contacts = reloaded
+	for _, conflict := range conflicts {
+		s.dropConflict(conflict.ID)
+		s.conflicts = append(s.conflicts, conflict)
+	}
+	s.reloads++
+	fmt.Printf("Reloaded contacts changed outside AFcb: %d changed, %d conflicts\n", n, len(conflicts))
+	return nil
+}
+
+// watch checks storage for outside changes every interval in the background
+func (s *ContactStore) Watch(interval time.Duration) {
+	if _, ok := s.storage.(watcher); !ok || interval <= 0 {
+		return
+	}
+	go func() {
+		for range time.Tick(interval) {
+			s.mu.Lock()
+			err := s.sync()
+			s.mu.Unlock()
+			if err != nil {
+				fmt.Printf("Error reloading contacts: %v\n", err)
+			}
+		}
+	}()
+}
+
+// reloads counts how often outside changes were picked up, so pages can
+// tell when their list is stale
+func (s *ContactStore) Reloads() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.reloads
+}
+
+// conflicts returns a copy of the unresolved conflicts, oldest first
+func (s *ContactStore) Conflicts() []Conflict {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Conflict(nil), s.conflicts...)
+}
+
+func (s *ContactStore) dropConflict(id string) {
+	for i, conflict := range s.conflicts {
+		if conflict.ID == id {
+			s.conflicts = append(s.conflicts[:i], s.conflicts[i+1:]...)
+			return
+		}
+	}
+}
+
+// resolveConflict settles a conflict. keeping ours needs no change since
+// the book already has it; using the file puts its version back, or
+// removes the contact for good if the file had removed it
+func (s *ContactStore) ResolveConflict(id string, useFile bool, by string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var conflict *Conflict
+	for i := range s.conflicts {
+		if s.conflicts[i].ID == id {
+			conflict = &s.conflicts[i]
+			break
+		}
+	}
+	if conflict == nil {
+		return fmt.Errorf("No conflict found for contact %s", id)
+	}
+	if !useFile {
+		s.dropConflict(id)
+		return nil
+	}
+
+	current, err := s.contacts.Find(id)
+	exists := err == nil
+	if conflict.OnDisk == nil && !exists {
+		s.dropConflict(id)
+		return nil
+	}
+	var onDisk *Contact
+	if conflict.OnDisk != nil {
+		contact := nextVersion(current, *conflict.OnDisk)
+		contact.touch(by)
+		onDisk = &contact
+	}
+	var others, othersAfter Contacts
+	err = s.mutate(func(c *Contacts, tx StorageTx) error {
+		if onDisk == nil {
+			if err := c.Delete(id); err != nil {
+				return err
+			}
+			if err := tx.Delete(id); err != nil {
+				return err
+			}
+		} else {
+			c.put(*onDisk)
+			if err := tx.Put(*onDisk); err != nil {
+				return err
+			}
+		}
+		var err error
+		others, othersAfter, err = relink(c, tx, []string{id}, by)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	s.dropConflict(id)
+	if onDisk != nil {
+		*onDisk, _ = s.contacts.Find(id)
+	}
+	for i := range othersAfter {
+		s.record(&others[i], &othersAfter[i], by, "relation")
+	}
+
+	switch {
+	case onDisk == nil:
+		if err := s.history.Forget(id); err != nil {
+			fmt.Printf("Error removing history of %s: %v\n", id, err)
+		}
+		if err := usage.Forget(id); err != nil {
+			fmt.Printf("Error removing usage of %s: %v\n", id, err)
+		}
+	case exists:
+		s.record(&current, onDisk, by, "resolve")
+	default:
+		s.record(nil, onDisk, by, "resolve")
+	}
+	return nil
+}
+
+// find returns a contact outside the trash, treating trashed ones as not found
+func (s *ContactStore) find(id string) (Contact, error) {
+	contact, err := s.contacts.Find(id)
+	if err != nil {
+		return Contact{}, err
+	}
+	if contact.InTrash() {
+		return Contact{}, fmt.Errorf("No contact found with id %s", id)
+	}
+	return contact, nil
+}
+
+func (s *ContactStore) Find(id string) (Contact, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.find(id)
+}
+
+// list returns a copy of all contacts outside the trash in insertion order
+func (s *ContactStore) List() Contacts {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.contacts.active()
+}
+
+// all returns a copy of every contact, trash included
+func (s *ContactStore) All() Contacts {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.contacts.clone()
+}
+
+// recent returns up to limit contacts outside the trash, most recently
+// added first, or most recently updated first when byUpdate is set
+func (s *ContactStore) Recent(byUpdate bool, limit int) Contacts {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	contacts := s.contacts.active()
+	when := func(c Contact) time.Time {
+		if byUpdate {
+			return c.UpdatedAt
+		}
+		return c.CreatedAt
+	}
+	sort.SliceStable(contacts, func(i, k int) bool {
+		return when(contacts[i]).After(when(contacts[k]))
+	})
+	if len(contacts) > limit {
+		contacts = contacts[:limit]
+	}
+	return contacts
+}
+
+func (s *ContactStore) Search(keyword string) Contacts {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.contacts.Search(keyword).clone()
+}
+
+// New adds a contact, saving upload as its photo when it has a new one
+func (s *ContactStore) New(draft Contact, upload *PhotoUpload, by string) (Contact, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if upload != nil {
+		if err := photos.Save(upload); err != nil {
+			return Contact{}, err
+		}
+	}
+	var contact Contact
+	err := s.mutate(func(c *Contacts, tx StorageTx) error {
+		var err error
+		contact, err = c.New(draft, by)
+		if err != nil {
+			return err
+		}
+		return tx.Put(contact)
+	})
+	if err != nil {
+		if upload != nil {
+			s.dropPhotos(upload.ID)
+		}
+		return Contact{}, err
+	}
+	s.record(nil, &contact, by, "create")
+	return contact, nil
+}
+
+// staleVersionError is returned when an edit was made against an older
+// version of a contact than the one saved
+type staleVersionError struct {
+	Current Contact
+}
+
+func (e *staleVersionError) Error() string {
+	return fmt.Sprintf("contact %s was changed by someone else, it is now at version %d", e.Current.ID, e.Current.Version)
+}
+
+// nextVersion moves a contact's version past that of before
+func nextVersion(before, contact Contact) Contact {
+	contact.Version = max(before.Version, contact.Version) + 1
+	return contact
+}
+
+// update applies an edit and returns the contact as saved. a version
+// above 0 must match the saved one, or a staleVersionError is returned
+func (s *ContactStore) Update(id string, version int, update ContactUpdate, by string) (Contact, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	before, err := s.find(id)
+	if err != nil {
+		return Contact{}, err
+	}
+	if version > 0 && version != before.Version {
+		return Contact{}, &staleVersionError{Current: before}
+	}
+	//a new photo is saved only once the version is known to match
+	if update.Upload != nil {
+		if err := photos.Save(update.Upload); err != nil {
+			return Contact{}, err
+		}
+	}
+	var contact Contact
+	err = s.mutate(func(c *Contacts, tx StorageTx) error {
+		if err := c.Update(id, update, by); err != nil {
+			return err
+		}
+		var err error
+		contact, err = c.Find(id)
+		if err != nil {
+			return err
+		}
+		contact = nextVersion(before, contact)
+		c.put(contact)
+		return tx.Put(contact)
+	})
+	if err != nil {
+		if update.Upload != nil {
+			s.dropPhotos(update.Upload.ID)
+		}
+		return Contact{}, err
+	}
+	s.record(&before, &contact, by, "update")
+	if before.Photo != contact.Photo {
+		s.dropPhotos(before.Photo)
+	}
+	return contact, nil
+}
+
+// Change applies fn to a copy of a contact outside the trash and saves it
+// as a new version, recording it in history under action
+func (s *ContactStore) Change(id, by, action string, fn func(c *Contact) error) (Contact, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	before, err := s.find(id)
+	if err != nil {
+		return Contact{}, err
+	}
+	contact := nextVersion(before, before)
+	if err := fn(&contact); err != nil {
+		return Contact{}, err
+	}
+	contact.touch(by)
+	err = s.mutate(func(c *Contacts, tx StorageTx) error {
+		c.put(contact)
+		return tx.Put(contact)
+	})
+	if err != nil {
+		return Contact{}, err
+	}
+	s.record(&before, &contact, by, action)
+	return contact, nil
+}
+
+// ChangeAll applies fn to a copy of every contact, trash included, and
+// saves those it reports as changed in one transaction, recording each in
+// history under action. it returns how many contacts changed
+func (s *ContactStore) ChangeAll(by, action string, fn func(c *Contact) bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.changeAll(by, action, fn)
+}
+
+// changeAll does the work of ChangeAll, callers hold the lock
+func (s *ContactStore) changeAll(by, action string, fn func(c *Contact) bool) (int, error) {
+	var before, after Contacts
+	for _, contact := range s.contacts {
+		changed := contact
+		if !fn(&changed) {
+			continue
+		}
+		changed = nextVersion(contact, changed)
+		changed.touch(by)
+		before, after = append(before, contact), append(after, changed)
+	}
+	if len(after) == 0 {
+		return 0, nil
+	}
+	err := s.mutate(func(c *Contacts, tx StorageTx) error {
+		for _, contact := range after {
+			c.put(contact)
+			if err := tx.Put(contact); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for i := range after {
+		s.record(&before[i], &after[i], by, action)
+	}
+	return len(after), nil
+}
+
+// inUseError is returned by an Unlink check that refuses to take something
+// away while contacts still point at it
+type inUseError string
+
+func (e inUseError) Error() string {
+	return string(e)
+}
+
+// Unlink takes away something contacts point at, such as a group or an
+// organization. check sees every contact, trash included, and may refuse
+// with an inUseError. fn then unlinks the contacts as ChangeAll does, and
+// remove takes the thing away once they are saved, all under one hold of
+// the lock so no contact can be pointed back at it in between. check and
+// fn may be nil
+func (s *ContactStore) Unlink(by, action string, check func(contacts Contacts) error, fn func(c *Contact) bool, remove func() error) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if check != nil {
+		if err := check(s.contacts.clone()); err != nil {
+			return 0, err
+		}
+	}
+	n := 0
+	if fn != nil {
+		var err error
+		if n, err = s.changeAll(by, action, fn); err != nil {
+			return 0, err
+		}
+	}
+	return n, remove()
+}
+
+// delete moves a contact to the trash, where it can be restored until purged
+func (s *ContactStore) Delete(id, by string) (Contact, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	before, err := s.find(id)
+	if err != nil {
+		return Contact{}, err
+	}
+	contact := nextVersion(before, before)
+	contact.DeletedAt = time.Now()
+	contact.touch(by)
+	err = s.mutate(func(c *Contacts, tx StorageTx) error {
+		c.put(contact)
+		return tx.Put(contact)
+	})
+	if err != nil {
+		return Contact{}, err
+	}
+	s.record(&before, &contact, by, "delete")
+	return contact, nil
+}
+
+// trash returns a copy of the contacts in the trash, most recently deleted first
+func (s *ContactStore) Trash() Contacts {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	trashed := s.contacts.trashed()
+	sort.SliceStable(trashed, func(i, k int) bool {
+		return trashed[i].DeletedAt.After(trashed[k].DeletedAt)
+	})
+	return trashed
+}
+
+// undelete takes a contact back out of the trash
+func (s *ContactStore) Undelete(id, by string) (Contact, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	before, err := s.contacts.Find(id)
+	if err != nil {
+		return Contact{}, err
+	}
+	if !before.InTrash() {
+		return Contact{}, fmt.Errorf("contact %s is not in the trash", id)
+	}
+	contact := nextVersion(before, before)
+	contact.DeletedAt = time.Time{}
+	contact.touch(by)
+	err = s.mutate(func(c *Contacts, tx StorageTx) error {
+		c.put(contact)
+		return tx.Put(contact)
+	})
+	if err != nil {
+		return Contact{}, err
+	}
+	s.record(&before, &contact, by, "undelete")
+	return contact, nil
+}
+
+// purge permanently removes a trashed contact along with its history
+func (s *ContactStore) Purge(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	contact, err := s.contacts.Find(id)
+	if err != nil {
+		return err
+	}
+	if !contact.InTrash() {
+		return fmt.Errorf("contact %s is not in the trash", id)
+	}
+	return s.purge([]string{id})
+}
+
+// purgeExpired permanently removes contacts trashed longer than retention ago
+func (s *ContactStore) PurgeExpired(retention time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := time.Now().Add(-retention)
+	var ids []string
+	for _, contact := range s.contacts.trashed() {
+		if contact.DeletedAt.Before(cutoff) {
+			ids = append(ids, contact.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	return len(ids), s.purge(ids)
+}
+
+// purge removes contacts in one transaction, callers hold the lock
+func (s *ContactStore) purge(ids []string) error {
+	var photoIDs []string
+	for _, id := range ids {
+		if contact, err := s.contacts.Find(id); err == nil {
+			photoIDs = append(photoIDs, contact.Photo)
+		}
+		for _, rev := range s.history.List(id) {
+			photoIDs = append(photoIDs, rev.Contact.Photo)
+		}
+	}
+	var before, after Contacts
+	err := s.mutate(func(c *Contacts, tx StorageTx) error {
+		for _, id := range ids {
+			if err := c.Delete(id); err != nil {
+				return err
+			}
+			if err := tx.Delete(id); err != nil {
+				return err
+			}
+		}
+		//relationships pointing at the purged contacts go with them
+		var err error
+		before, after, err = relink(c, tx, ids, "")
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for i := range after {
+		s.record(&before[i], &after[i], "", "relation")
+	}
+	for _, id := range ids {
+		if err := s.history.Forget(id); err != nil {
+			fmt.Printf("Error removing history of %s: %v\n", id, err)
+		}
+		if err := usage.Forget(id); err != nil {
+			fmt.Printf("Error removing usage of %s: %v\n", id, err)
+		}
+	}
+	s.dropPhotos(photoIDs...)
+	return nil
+}
+
+// dropPhotos deletes the given photos that no contact in the book has,
+// callers hold the lock
+func (s *ContactStore) dropPhotos(ids ...string) {
+	kept := map[string]bool{}
+	for _, contact := range s.contacts {
+		kept[contact.Photo] = true
+	}
+	for _, id := range ids {
+		if id == "" || kept[id] {
+			continue
+		}
+		kept[id] = true
+		if err := photos.Delete(id); err != nil {
+			fmt.Printf("Error removing photo %s: %v\n", id, err)
+		}
+	}
+}
+
+// keptPhoto clears the photo of a contact brought back from history or a
+// snapshot when the photo has since been deleted
+func keptPhoto(contact Contact) Contact {
+	if contact.Photo != "" && !photos.Has(contact.Photo) {
+		contact.Photo = ""
+	}
+	return contact
+}
+
+// replaceAll makes the book hold exactly the given contacts, as when
+// restoring a snapshot, recording each difference in history
+func (s *ContactStore) ReplaceAll(contacts Contacts, by, action string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old := map[string]Contact{}
+	for _, contact := range s.contacts {
+		old[contact.ID] = contact
+	}
+	keep := map[string]bool{}
+	var ids []string
+	contacts = contacts.clone()
+	for i, contact := range contacts {
+		contact = keptPhoto(contact)
+		contacts[i] = contact
+		keep[contact.ID] = true
+		before, ok := old[contact.ID]
+		if ok && !sameContact(before, contact) {
+			contacts[i] = nextVersion(before, contact)
+			contacts[i].touch(by)
+		}
+		if !ok || !sameContact(before, contact) {
+			ids = append(ids, contact.ID)
+		}
+	}
+	for id := range old {
+		if !keep[id] {
+			ids = append(ids, id)
+		}
+	}
+	var others, othersAfter Contacts
+	err := s.mutate(func(c *Contacts, tx StorageTx) error {
+		for _, contact := range *c {
+			if !keep[contact.ID] {
+				if err := tx.Delete(contact.ID); err != nil {
+					return err
+				}
+			}
+		}
+		for _, contact := range contacts {
+			if before, ok := old[contact.ID]; !ok || !sameContact(before, contact) {
+				if err := tx.Put(contact); err != nil {
+					return err
+				}
+			}
+		}
+		*c = contacts.clone()
+		var err error
+		others, othersAfter, err = relink(c, tx, ids, by)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, contact := range s.contacts.clone() {
+		before, ok := old[contact.ID]
+		if !ok {
+			s.record(nil, &contact, by, action)
+		} else {
+			s.record(&before, &contact, by, action)
+		}
+	}
+	for _, before := range old {
+		if !keep[before.ID] {
+			s.record(&before, nil, by, action)
+		}
+	}
+	for i := range othersAfter {
+		s.record(&others[i], &othersAfter[i], by, "relation")
+	}
+	return nil
+}
+
+// history returns the revisions of a contact, oldest first
+func (s *ContactStore) History(id string) []Revision {
+	return s.history.List(id)
+}
+
+// restore puts a contact's details back to the values of an earlier
+// revision, recording the restore as a new revision. only the fields in
+// restoredFields are put back, so the timeline, groups, photo and
+// relationships stay as they are
+func (s *ContactStore) Restore(id string, rev int, by string) (Contact, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	revision, err := s.history.Get(id, rev)
+	if err != nil {
+		return Contact{}, err
+	}
+	before, err := s.find(id)
+	if err != nil {
+		return Contact{}, err
+	}
+	update := ContactUpdate{Contact: revision.Contact}
+	for _, change := range diffContacts(before, revision.Contact) {
+		if slices.Contains(restoredFields, change.Field) {
+			update.Fields = append(update.Fields, change.Field)
+		}
+	}
+	var contact Contact
+	var others, othersAfter Contacts
+	err = s.mutate(func(c *Contacts, tx StorageTx) error {
+		if err := c.Update(id, update, by); err != nil {
+			return err
+		}
+		var err error
+		contact, err = c.Find(id)
+		if err != nil {
+			return err
+		}
+		contact = nextVersion(before, contact)
+		c.put(contact)
+		if err := tx.Put(contact); err != nil {
+			return err
+		}
+		others, othersAfter, err = relink(c, tx, []string{id}, by)
+		return err
+	})
+	if err != nil {
+		return Contact{}, err
+	}
+	contact, _ = s.contacts.Find(id)
+	s.record(&before, &contact, by, "restore")
+	for i := range othersAfter {
+		s.record(&others[i], &othersAfter[i], by, "relation")
+	}
+	return contact, nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestStore returns a store over memory storage holding contacts
+func newTestStore(t *testing.T, contacts ...Contact) *ContactStore {
+	t.Helper()
+	storage := NewMemoryStorage()
+	for _, contact := range contacts {
+		if err := storage.Put(contact); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := NewContactStore(storage, NewHistoryStore(""))
+	if err := s.Load(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestStoreParallelMutations(t *testing.T) {
+	s := newTestStore(t)
+	const workers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c, err := s.New(Contact{
+				ContactType: "Work",
+				FirstName:   fmt.Sprintf("First%d", i),
+				LastName:    "Last",
+				Emails:      []ContactValue{{Label: "work", Value: fmt.Sprintf("p%d@example.com", i), Primary: true}},
+				Phones:      []ContactValue{{Label: "mobile", Value: "555", Primary: true}},
+			}, nil, "af")
+			if err != nil {
+				errs <- err
+				return
+			}
+			if _, err := s.Update(c.ID, 0, ContactUpdate{Contact: Contact{LastName: fmt.Sprintf("Last%d", i)}, Fields: []string{"LastName"}}, "af"); err != nil {
+				errs <- err
+				return
+			}
+			s.List()
+			s.Search("First")
+			//every other worker deletes what it made
+			if i%2 == 0 {
+				if _, err := s.Delete(c.ID, "af"); err != nil {
+					errs <- err
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	contacts := s.List()
+	if len(contacts) != workers/2 {
+		t.Fatalf("kept %d contacts, want %d", len(contacts), workers/2)
+	}
+	for _, c := range contacts {
+		if c.LastName != "Last"+c.FirstName[len("First"):] {
+			t.Errorf("%s has last name %q, an update was lost", c.FirstName, c.LastName)
+		}
+	}
+
+	//storage holds what is in memory
+	stored, err := s.storage.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContacts(t, stored, s.contacts)
+}
+
+func TestRecent(t *testing.T) {
+	now := time.Now()
+	at := func(id string, created, updated time.Duration) Contact {
+		return Contact{ID: id, Version: 1, FirstName: id, CreatedAt: now.Add(-created), UpdatedAt: now.Add(-updated)}
+	}
+	trashed := at("dan", 0, 0)
+	trashed.DeletedAt = now
+	s := newTestStore(t,
+		at("ann", 72*time.Hour, time.Hour),
+		at("bob", 24*time.Hour, 48*time.Hour),
+		at("cat", 48*time.Hour, 3*time.Hour),
+		trashed,
+	)
+	ids := func(contacts Contacts) []string {
+		var out []string
+		for _, c := range contacts {
+			out = append(out, c.ID)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name     string
+		byUpdate bool
+		limit    int
+		want     []string
+	}{
+		{name: "recently added", limit: 2, want: []string{"bob", "cat"}},
+		{name: "recently updated", byUpdate: true, limit: 5, want: []string{"ann", "cat", "bob"}},
+		{name: "none", limit: 0},
+	}
+	for _, tt := range tests {
+		if got := ids(s.Recent(tt.byUpdate, tt.limit)); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	//an edit moves a contact to the front of the updated view only
+	if _, err := s.Update("bob", 1, ContactUpdate{Contact: Contact{LastName: "Builder"}, Fields: []string{"LastName"}}, "af"); err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(s.Recent(true, 1)); !slices.Equal(got, []string{"bob"}) {
+		t.Errorf("after an edit recently updated is %v, want [bob]", got)
+	}
+	if got := ids(s.Recent(false, 1)); !slices.Equal(got, []string{"bob"}) {
+		t.Errorf("after an edit recently added is %v, want [bob]", got)
+	}
+	bob, _ := s.Find("bob")
+	if bob.UpdatedBy != "af" || !bob.CreatedAt.Equal(now.Add(-24*time.Hour)) {
+		t.Errorf("edited contact created %v, updated by %q", bob.CreatedAt, bob.UpdatedBy)
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// normalizeTags trims tags, collapses inner spaces and drops blanks and
+// duplicates, which are matched ignoring case. the first spelling is kept
+func normalizeTags(tags []string) []string {
+	var out []string
+	for _, tag := range tags {
+		tag = strings.Join(strings.Fields(tag), " ")
+		if tag == "" || hasTag(out, tag) {
+			continue
+		}
+		out = append(out, tag)
+	}
+	return out
+}
+
+// parseTags reads a comma separated list of tags
+func parseTags(text string) []string {
+	return normalizeTags(strings.Split(text, ","))
+}
+
+func formatTags(tags []string) string {
+	return strings.Join(tags, ", ")
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasTags reports whether the contact carries every one of tags
+func (c Contact) HasTags(tags []string) bool {
+	for _, tag := range tags {
+		if !hasTag(c.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// withTags returns the contacts carrying every one of tags
+func (c Contacts) withTags(tags []string) Contacts {
+	if len(tags) == 0 {
+		return c
+	}
+	var out Contacts
+	for _, contact := range c {
+		if contact.HasTags(tags) {
+			out = append(out, contact)
+		}
+	}
+	return out
+}
+
+// TagCount is a tag with the number of contacts carrying it
+type TagCount struct {
+	Name  string
+	Count int
+}
+
+// tagCounts lists the tags used by contacts, sorted by name
+func tagCounts(contacts Contacts) []TagCount {
+	counts := map[string]int{}
+	names := map[string]string{}
+	for _, contact := range contacts {
+		for _, tag := range contact.Tags {
+			key := strings.ToLower(tag)
+			if _, ok := names[key]; !ok {
+				names[key] = tag
+			}
+			counts[key]++
+		}
+	}
+	var out []TagCount
+	for key, name := range names {
+		out = append(out, TagCount{Name: name, Count: counts[key]})
+	}
+	sort.Slice(out, func(i, k int) bool {
+		return strings.ToLower(out[i].Name) < strings.ToLower(out[k].Name)
+	})
+	return out
+}
+
+// retag renames a tag on every contact, merging it into to when a contact
+// already has that tag. an empty to deletes the tag
+func retag(from, to string) func(c *Contact) bool {
+	return func(c *Contact) bool {
+		if !hasTag(c.Tags, from) {
+			return false
+		}
+		var tags []string
+		for _, tag := range c.Tags {
+			if strings.EqualFold(tag, from) {
+				tag = to
+			}
+			tags = append(tags, tag)
+		}
+		c.Tags = normalizeTags(tags)
+		return true
+	}
+}
+
+// tagsFromForm reads the tag chips of the add and edit modals along with
+// anything left in the tag input. a comma separated Tags field, as sent by
+// the merge modal, is read in place of the chips
+func tagsFromForm(r *http.Request) []string {
+	if text, ok := r.Form["Tags"]; ok {
+		return parseTags(strings.Join(text, ","))
+	}
+	return normalizeTags(append(r.Form["Tag"], strings.Split(r.FormValue("TagInput"), ",")...))
+}
+
+var tagInputHTML = `{{define "tag-chip"}}
+<span class="tag-chip inline-flex items-center px-2 py-1 mr-1 mb-1 rounded-full bg-indigo-100 text-indigo-800 text-xs">
+    <input type="hidden" name="Tag" value="{{.}}">{{.}}
+    <button type="button" hx-get="/modal/close" hx-target="closest .tag-chip" hx-swap="outerHTML" class="ml-1 text-indigo-400 hover:text-red-600" title="Remove">&times;</button>
+</span>
+{{end}}
+{{define "tag-input"}}
+<div id="tag-chips" class="flex flex-wrap">{{range .}}{{template "tag-chip" .}}{{end}}</div>
+<datalist id="tag-list">{{range allTags}}<option value="{{.Name}}"></option>{{end}}</datalist>
+<input name="TagInput" list="tag-list" placeholder="Add a tag" autocomplete="off"
+    class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline"
+    hx-get="/modal/row/tag"
+    hx-trigger="change"
+    hx-target="#tag-chips"
+    hx-swap="beforeend"
+    hx-on:keydown="if(event.key==='Enter'){event.preventDefault();htmx.trigger(this,'change')}"
+    hx-on::after-request="this.value=''">
+{{end}}`
+
+var tagFuncs = template.FuncMap{
+	"allTags": func() []TagCount { return tagCounts(store.List()) },
+}
+
+// tagChipView turns what was typed in the tag input into chips
+func tagChipView(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html")
+	for _, tag := range parseTags(r.FormValue("TagInput")) {
+		if err := valueRowTemplate.ExecuteTemplate(w, "tag-chip", tag); err != nil {
+			fmt.Printf("Error rendering tag chip: %v\n", err)
+		}
+	}
+}
+
+var tagFilterHTML = `
+<div id="tag-filter"
+     hx-get="/tags/filter"
+     hx-include="this"
+     hx-trigger="tagsChanged from:body"
+     hx-swap="outerHTML"
+     {{if .OOB}}hx-swap-oob="true"{{end}}>
+    {{if .Tags}}
+    <form class="flex flex-wrap items-center gap-2 mb-6 text-sm"
+          hx-get="/contacts"
+          hx-trigger="change"
+          hx-target="#contact-list"
+          hx-swap="innerHTML">
+        <span class="text-gray-500">Tags:</span>
+        {{range .Tags}}
+        <label class="inline-flex items-center px-3 py-1 rounded-full bg-white shadow cursor-pointer hover:bg-indigo-50 has-[:checked]:bg-indigo-600 has-[:checked]:text-white">
+            <input type="checkbox" name="tag" value="{{.Name}}" class="hidden" {{if hasTag $.Selected .Name}}checked{{end}}>{{.Name}}
+            <span class="ml-1 opacity-60">{{.Count}}</span>
+        </label>
+        {{end}}
+        <button type="button" class="text-blue-600 hover:underline" hx-get="/tags" hx-target="#modal-container" hx-swap="innerHTML">Manage tags</button>
+    </form>
+    {{end}}
+</div>
+`
+
+var tagFilter = template.Must(template.New("tag-filter").Funcs(template.FuncMap{"hasTag": hasTag}).Parse(tagFilterHTML))
+
+// tagFilterView renders the tag checkboxes above the contact list, keeping
+// the ones ticked
+func tagFilterView(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html")
+	renderTagFilter(w, r.URL.Query()["tag"], false)
+}
+
+func renderTagFilter(w http.ResponseWriter, selected []string, oob bool) {
+	err := tagFilter.Execute(w, map[string]any{
+		"Tags":     tagCounts(store.List()),
+		"Selected": selected,
+		"OOB":      oob,
+	})
+	if err != nil {
+		fmt.Printf("Error rendering tag filter: %v\n", err)
+	}
+}
+
+var tagsModalHTML = `
+<div id="contact-modal" class="fixed inset-0 bg-gray-600 bg-opacity-50 overflow-y-auto h-full w-full">
+    <div class="relative top-20 mx-auto p-5 border w-full max-w-2xl shadow-lg rounded-md bg-white">
+        <div class="flex justify-end">
+            <button hx-target="#contact-modal" hx-swap="outerHTML" hx-get="/modal/close" class="text-gray-400 hover:text-gray-600">&times;</button>
+        </div>
+        <h3 class="text-xl font-bold mb-1">Tags</h3>
+        <p class="text-sm text-gray-500 mb-4">Renaming a tag to one that exists merges the two. Changes apply to every contact, trash included.</p>
+        {{if .Message}}<div class="p-2 mb-4 rounded-lg bg-green-100 text-green-800 text-sm">{{.Message}}</div>{{end}}
+        {{if not .Tags}}
+        <div class="p-4 bg-gray-100 text-gray-500 rounded-lg">No tags yet.</div>
+        {{end}}
+        {{range .Tags}}
+        <div class="flex items-center gap-2 p-2 mb-2 border rounded-lg">
+            <span class="w-40 font-medium text-gray-800">{{.Name}} <span class="text-xs text-gray-500">{{.Count}}</span></span>
+            <form class="flex flex-1 items-center gap-2" hx-post="/tags/rename" hx-target="#modal-container" hx-swap="innerHTML">
+                <input type="hidden" name="From" value="{{.Name}}">
+                <input name="To" list="tag-names" placeholder="Rename or merge into" required class="flex-1 shadow appearance-none border rounded py-1 px-2 text-gray-700 text-sm focus:outline-none focus:shadow-outline">
+                <button type="submit" class="px-3 py-1 text-sm rounded-lg border border-gray-300 hover:border-blue-500 hover:bg-blue-50 transition-colors">Apply</button>
+            </form>
+            <button class="px-3 py-1 text-sm rounded-lg border border-gray-300 hover:border-red-500 hover:bg-red-50 transition-colors"
+                hx-post="/tags/delete"
+                hx-vals='{"From": "{{.Name}}"}'
+                hx-target="#modal-container"
+                hx-swap="innerHTML"
+                hx-confirm="Remove this tag from every contact?">
+                Delete
+            </button>
+        </div>
+        {{end}}
+        <datalist id="tag-names">{{range .Tags}}<option value="{{.Name}}"></option>{{end}}</datalist>
+    </div>
+</div>
+`
+
+var tagsModal = template.Must(template.New("tags-modal").Parse(tagsModalHTML))
+
+// renderTags shows the tag management modal, refreshing the tag filter and
+// contact list out of band after a change
+func renderTags(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "text/html")
+	err := tagsModal.Execute(w, map[string]any{
+		"Tags":    tagCounts(store.All()),
+		"Message": message,
+	})
+	if err != nil {
+		fmt.Printf("Error rendering tags: %v\n", err)
+		return
+	}
+	if message != "" {
+		renderTagFilter(w, nil, true)
+		renderContactListOOB(w)
+	}
+}
+
+func tagsView(w http.ResponseWriter, r *http.Request) {
+	renderTags(w, "")
+}
+
+// renameTag renames or merges a tag on every contact
+func renameTag(w http.ResponseWriter, r *http.Request) {
+	from := strings.TrimSpace(r.FormValue("From"))
+	to := strings.Join(strings.Fields(r.FormValue("To")), " ")
+	if from == "" || to == "" || strings.Contains(to, ",") {
+		http.Error(w, "Tags need a name without commas", http.StatusBadRequest)
+		return
+	}
+	n, err := store.ChangeAll(currentUser(r), "tags", retag(from, to))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Printf("Renamed tag %q to %q on %d contacts\n", from, to, n)
+	renderTags(w, fmt.Sprintf("Renamed %s to %s on %s.", from, to, countContacts(n)))
+}
+
+// deleteTag removes a tag from every contact
+func deleteTag(w http.ResponseWriter, r *http.Request) {
+	from := strings.TrimSpace(r.FormValue("From"))
+	n, err := store.ChangeAll(currentUser(r), "tags", retag(from, ""))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Printf("Deleted tag %q from %d contacts\n", from, n)
+	renderTags(w, fmt.Sprintf("Removed %s from %s.", from, countContacts(n)))
+}
+
+func countContacts(n int) string {
+	if n == 1 {
+		return "1 contact"
+	}
+	return fmt.Sprintf("%d contacts", n)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	got := parseTags(" family ,  close   friends,Family,, work ")
+	want := []string{"family", "close friends", "work"}
+	if !slices.Equal(got, want) {
+		t.Errorf("parseTags = %q, want %q", got, want)
+	}
+}
+
+func TestRetag(t *testing.T) {
+	tests := []struct {
+		name     string
+		tags     []string
+		from, to string
+		want     []string
+		changed  bool
+	}{
+		{name: "rename", tags: []string{"work", "golf"}, from: "Golf", to: "sport", want: []string{"work", "sport"}, changed: true},
+		{name: "merge keeps the existing spelling", tags: []string{"Sport", "golf"}, from: "golf", to: "sport", want: []string{"Sport"}, changed: true},
+		{name: "delete", tags: []string{"golf", "work"}, from: "golf", want: []string{"work"}, changed: true},
+		{name: "not tagged", tags: []string{"work"}, from: "golf", to: "sport", want: []string{"work"}},
+	}
+	for _, tt := range tests {
+		c := Contact{Tags: tt.tags}
+		changed := retag(tt.from, tt.to)(&c)
+		if changed != tt.changed || !slices.Equal(c.Tags, tt.want) {
+			t.Errorf("%s: got %q changed %v, want %q changed %v", tt.name, c.Tags, changed, tt.want, tt.changed)
+		}
+	}
+}
+
+func TestRenameTagAcrossContacts(t *testing.T) {
+	s := newTestStore(t,
+		Contact{ID: "ann", FirstName: "Ann", Tags: []string{"golf"}},
+		Contact{ID: "bob", FirstName: "Bob", Tags: []string{"sport", "Golf"}},
+		Contact{ID: "cat", FirstName: "Cat", Tags: []string{"work"}},
+	)
+	n, err := s.ChangeAll("af", "tags", retag("golf", "sport"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("changed %d contacts, want 2", n)
+	}
+	want := []TagCount{{Name: "sport", Count: 2}, {Name: "work", Count: 1}}
+	if got := tagCounts(s.List()); !slices.Equal(got, want) {
+		t.Errorf("tag counts %v, want %v", got, want)
+	}
+	if revisions := s.History("cat"); len(revisions) != 0 {
+		t.Errorf("untouched contact has %d revisions", len(revisions))
+	}
+}
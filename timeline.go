@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// kinds of interaction on a contact's timeline. notes are context rather
+// than contact, so they do not count towards "last contacted"
+var interactionTypes = []string{"call", "email", "meeting", "message", "note"}
+
+// Interaction is one dated entry on a contact's timeline
+type Interaction struct {
+	ID      string
+	Type    string
+	Date    time.Time // day the interaction took place
+	Summary string
+	Author  string    `json:",omitempty"`
+	AddedAt time.Time `json:",omitzero"`
+}
+
+func (i Interaction) String() string {
+	return fmt.Sprintf("%s %s: %s", i.Date.Format("2006-01-02"), i.Type, i.Summary)
+}
+
+func formatTimeline(timeline []Interaction) string {
+	parts := make([]string, len(timeline))
+	for i, entry := range timeline {
+		parts[i] = entry.String()
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Timeline returns the contact's interactions newest first
+func (c Contact) Timeline() []Interaction {
+	timeline := append([]Interaction(nil), c.Interactions...)
+	sort.SliceStable(timeline, func(i, k int) bool {
+		if timeline[i].Date.Equal(timeline[k].Date) {
+			return timeline[i].AddedAt.After(timeline[k].AddedAt)
+		}
+		return timeline[i].Date.After(timeline[k].Date)
+	})
+	return timeline
+}
+
+// LastContacted is the day of the latest interaction other than a note,
+// zero when there is none
+func (c Contact) LastContacted() time.Time {
+	var last time.Time
+	for _, entry := range c.Interactions {
+		if entry.Type != "note" && entry.Date.After(last) {
+			last = entry.Date
+		}
+	}
+	return last
+}
+
+// daysAgo describes a day relative to today, such as "3 days ago"
+func daysAgo(day time.Time) string {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.Local)
+	switch days := int(today.Sub(day).Hours()+12) / 24; {
+	case days < 0:
+		return "on " + day.Format("2 Jan 2006")
+	case days == 0:
+		return "today"
+	case days == 1:
+		return "yesterday"
+	default:
+		return fmt.Sprintf("%d days ago", days)
+	}
+}
+
+// containsTimeline reports whether any interaction summary holds the lower
+// case keyword
+func containsTimeline(timeline []Interaction, keyword string) bool {
+	for _, entry := range timeline {
+		if strings.Contains(strings.ToLower(entry.Summary), keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+var detailModalHTML = `
+<div id="contact-modal" class="fixed inset-0 bg-gray-600 bg-opacity-50 overflow-y-auto h-full w-full">
+    <div class="relative top-20 mx-auto p-5 border w-full max-w-2xl shadow-lg rounded-md bg-white">
+        <div class="flex justify-end">
+            <button hx-target="#contact-modal" hx-swap="outerHTML" hx-get="/modal/close" class="text-gray-400 hover:text-gray-600">&times;</button>
+        </div>
+        <div class="flex items-center gap-4 mb-4">
+            {{template "avatar" (avatar . 256 96)}}
+            <div>
+                <h3 class="text-xl font-bold">{{.FirstName}} {{.LastName}}</h3>
+                <div class="text-sm text-gray-500">
+                    {{.ContactType}}{{with .Organization}}{{if or .ID .Role}} &middot; {{.Role}}{{if and .ID .Role}} at {{end}}{{orgName .ID}}{{end}}{{end}}
+                    {{with .PrimaryEmail}} &middot; {{.}}{{end}}{{with .PrimaryPhone}} &middot; {{.}}{{end}}
+                </div>
+            </div>
+        </div>
+        {{if .Notes}}
+        <h4 class="text-sm font-bold text-gray-700 mb-1">Notes</h4>
+        <p class="p-3 mb-4 bg-yellow-50 rounded-lg text-sm text-gray-800 whitespace-pre-line">{{.Notes}}</p>
+        {{end}}
+        <h4 class="text-sm font-bold text-gray-700 mb-2">Relationships</h4>
+        {{range .Related}}
+        <div class="flex justify-between items-center mb-1 text-sm">
+            <span>
+                <span class="text-gray-500">{{.Type}}</span>
+                <button class="text-blue-600 hover:underline" hx-get="/contacts/{{.Contact.ID}}/detail" hx-target="#modal-container" hx-swap="innerHTML">{{.Contact.FirstName}} {{.Contact.LastName}}</button>
+            </span>
+            <button class="text-gray-400 hover:text-red-600"
+                hx-delete="/contacts/{{$.ID}}/relations?type={{urlquery .Type}}&amp;other={{.Contact.ID}}"
+                hx-target="#modal-container"
+                hx-swap="innerHTML"
+                hx-confirm="Remove this relationship from both contacts?"
+                title="Remove">&times;</button>
+        </div>
+        {{end}}
+        <form class="flex flex-wrap items-center gap-2 mt-2 mb-4"
+              hx-post="/contacts/{{.ID}}/relations"
+              hx-target="#modal-container"
+              hx-swap="innerHTML">
+            <span class="text-sm text-gray-700">{{.FirstName}} is</span>
+            <select name="Type" class="shadow border rounded py-2 px-2 text-gray-700 text-sm focus:outline-none focus:shadow-outline">
+                {{range relationTypes}}<option value="{{.}}">{{.}}</option>{{end}}
+            </select>
+            <select name="Other" required class="flex-1 shadow border rounded py-2 px-2 text-gray-700 text-sm focus:outline-none focus:shadow-outline">
+                <option value=""></option>
+                {{range otherContacts .ID}}<option value="{{.ID}}">{{.FirstName}} {{.LastName}}</option>{{end}}
+            </select>
+            <button type="submit" class="bg-blue-600 text-white font-bold py-2 px-4 rounded-lg shadow-md hover:bg-blue-700 transition-colors duration-300">Link</button>
+        </form>
+        <h4 class="text-sm font-bold text-gray-700 mb-2">Timeline</h4>
+        <form class="flex flex-wrap items-center gap-2 mb-4"
+              hx-post="/contacts/{{.ID}}/timeline"
+              hx-target="#modal-container"
+              hx-swap="innerHTML">
+            <select name="Type" class="shadow border rounded py-2 px-2 text-gray-700 text-sm focus:outline-none focus:shadow-outline">
+                {{range interactionTypes}}<option value="{{.}}">{{.}}</option>{{end}}
+            </select>
+            <input type="date" name="Date" value="{{today}}" required class="shadow border rounded py-2 px-2 text-gray-700 text-sm focus:outline-none focus:shadow-outline">
+            <input name="Summary" placeholder="What happened?" required class="flex-1 shadow appearance-none border rounded py-2 px-3 text-gray-700 text-sm leading-tight focus:outline-none focus:shadow-outline">
+            <button type="submit" class="bg-blue-600 text-white font-bold py-2 px-4 rounded-lg shadow-md hover:bg-blue-700 transition-colors duration-300">Add</button>
+        </form>
+        {{if not .Timeline}}
+        <div class="p-4 bg-gray-100 text-gray-500 rounded-lg">Nothing recorded yet.</div>
+        {{end}}
+        <ol class="border-l-2 border-blue-200 ml-2">
+            {{range .Timeline}}
+            <li class="ml-4 mb-3">
+                <div class="flex justify-between items-center">
+                    <span class="text-xs text-gray-500">
+                        <span class="font-semibold uppercase text-blue-700">{{.Type}}</span>
+                        {{.Date.Format "Mon 2 Jan 2006"}}{{if .Author}} &middot; {{.Author}}{{end}}
+                    </span>
+                    <button class="text-gray-400 hover:text-red-600 text-sm"
+                        hx-delete="/contacts/{{$.ID}}/timeline/{{.ID}}"
+                        hx-target="#modal-container"
+                        hx-swap="innerHTML"
+                        hx-confirm="Remove this entry?"
+                        title="Remove">&times;</button>
+                </div>
+                <p class="text-gray-800 whitespace-pre-line">{{.Summary}}</p>
+            </li>
+            {{end}}
+        </ol>
+    </div>
+</div>
+`
+
+var detailModal = template.Must(template.New("detail-modal").Funcs(templateFuncs).Funcs(template.FuncMap{
+	"interactionTypes": func() []string { return interactionTypes },
+	"today":            func() string { return time.Now().Format("2006-01-02") },
+	"relationTypes":    relationTypes,
+	"otherContacts":    otherContacts,
+}).Funcs(avatarFuncs).Parse(detailModalHTML + avatarHTML))
+
+// renderDetail shows the detail view of a contact, refreshing its card
+// out of band so "last contacted" stays current
+func renderDetail(w http.ResponseWriter, contact Contact, refreshCard bool) {
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("ETag", etag(contact))
+	if err := detailModal.Execute(w, contact); err != nil {
+		fmt.Printf("Error rendering detail of %s: %v\n", contact.ID, err)
+		return
+	}
+	if refreshCard {
+		renderCardOOB(w, contact)
+	}
+}
+
+func contactDetail(w http.ResponseWriter, r *http.Request) {
+	contact, err := store.Find(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Contact not found", http.StatusNotFound)
+		return
+	}
+	renderDetail(w, contact, false)
+}
+
+// addInteraction records an entry on a contact's timeline
+func addInteraction(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	entry := Interaction{
+		Type:    r.FormValue("Type"),
+		Summary: strings.TrimSpace(r.FormValue("Summary")),
+		Author:  currentUser(r),
+		AddedAt: time.Now(),
+	}
+	if !containsString(interactionTypes, entry.Type) {
+		http.Error(w, "Invalid interaction type: "+entry.Type, http.StatusBadRequest)
+		return
+	}
+	if entry.Summary == "" {
+		http.Error(w, "Summary is required", http.StatusBadRequest)
+		return
+	}
+	date, err := time.ParseInLocation("2006-01-02", r.FormValue("Date"), time.Local)
+	if err != nil {
+		http.Error(w, "Invalid date: "+r.FormValue("Date"), http.StatusBadRequest)
+		return
+	}
+	entry.Date = date
+	if entry.ID, err = genID(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	contact, err := store.Change(id, currentUser(r), "timeline", func(c *Contact) error {
+		c.Interactions = append(append([]Interaction(nil), c.Interactions...), entry)
+		return nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	fmt.Printf("Added %s to timeline of contact %s\n", entry.Type, id)
+	renderDetail(w, contact, true)
+}
+
+// deleteInteraction removes an entry from a contact's timeline
+func deleteInteraction(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	contact, err := store.Change(vars["id"], currentUser(r), "timeline", func(c *Contact) error {
+		var kept []Interaction
+		for _, entry := range c.Interactions {
+			if entry.ID != vars["entry"] {
+				kept = append(kept, entry)
+			}
+		}
+		if len(kept) == len(c.Interactions) {
+			return fmt.Errorf("No timeline entry found with id %s", vars["entry"])
+		}
+		c.Interactions = kept
+		return nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	fmt.Printf("Removed timeline entry %s from contact %s\n", vars["entry"], vars["id"])
+	renderDetail(w, contact, true)
+}
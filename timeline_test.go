@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLastContacted(t *testing.T) {
+	entry := func(kind, date string) Interaction {
+		return Interaction{Type: kind, Date: day(date)}
+	}
+	tests := []struct {
+		name     string
+		timeline []Interaction
+		want     string
+	}{
+		{name: "no interactions"},
+		{name: "notes only", timeline: []Interaction{entry("note", "2025-03-01")}},
+		{
+			name:     "latest contact, not the latest entry",
+			timeline: []Interaction{entry("call", "2025-01-10"), entry("note", "2025-03-01"), entry("meeting", "2025-02-14")},
+			want:     "2025-02-14",
+		},
+		{
+			name:     "added out of order",
+			timeline: []Interaction{entry("email", "2025-02-01"), entry("message", "2024-12-24")},
+			want:     "2025-02-01",
+		},
+	}
+	for _, tt := range tests {
+		got := Contact{Interactions: tt.timeline}.LastContacted()
+		if tt.want == "" {
+			if !got.IsZero() {
+				t.Errorf("%s: last contacted %v, want never", tt.name, got)
+			}
+			continue
+		}
+		if !got.Equal(day(tt.want)) {
+			t.Errorf("%s: last contacted %v, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTimelineNewestFirst(t *testing.T) {
+	now := time.Now()
+	c := Contact{Interactions: []Interaction{
+		{ID: "a", Date: day("2025-01-10"), AddedAt: now},
+		{ID: "b", Date: day("2025-02-01"), AddedAt: now.Add(-time.Hour)},
+		{ID: "c", Date: day("2025-02-01"), AddedAt: now},
+	}}
+	var got string
+	for _, entry := range c.Timeline() {
+		got += entry.ID
+	}
+	if got != "cba" {
+		t.Errorf("timeline order %q, want cba", got)
+	}
+	if c.Interactions[0].ID != "a" {
+		t.Error("Timeline reordered the contact's own interactions")
+	}
+}
+
+func TestDaysAgo(t *testing.T) {
+	today := time.Now()
+	tests := []struct {
+		day  time.Time
+		want string
+	}{
+		{day: today, want: "today"},
+		{day: today.AddDate(0, 0, -1), want: "yesterday"},
+		{day: today.AddDate(0, 0, -40), want: "40 days ago"},
+		{day: today.AddDate(0, 0, 2), want: "on " + today.AddDate(0, 0, 2).Format("2 Jan 2006")},
+	}
+	for _, tt := range tests {
+		if got := daysAgo(tt.day); got != tt.want {
+			t.Errorf("daysAgo(%s) = %q, want %q", tt.day.Format("2006-01-02"), got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// days a contact stays in the trash before it is purged, 0 keeps it forever
+var trashRetentionDays = 30
+
+// toast swapped in place of a deleted card. it removes itself after a while
+var deletedToast = template.Must(template.New("deleted-toast").Parse(`
+<div class="card bg-gray-800 text-white rounded-xl shadow-md p-6 flex justify-between items-center" id="contact-{{.ID}}"
+    hx-get="/modal/close"
+    hx-trigger="load delay:10s"
+    hx-swap="outerHTML">
+    <span>
+        Contact deleted &mdash; {{.FirstName}} {{.LastName}}
+        {{with .Related}}<span class="block text-sm text-gray-300">{{len .}} relationship{{if gt (len .) 1}}s are{{else}} is{{end}} hidden until undone and removed when purged.</span>{{end}}
+    </span>
+    <button class="ml-4 px-3 py-1 rounded-lg bg-white text-gray-800 font-bold hover:bg-gray-200 transition-colors"
+        hx-post="/trash/{{.ID}}/restore"
+        hx-target="#contact-{{.ID}}"
+        hx-swap="outerHTML"
+        hx-trigger="click">
+        Undo
+    </button>
+</div>
+`))
+
+var trashModalHTML = `
+<div id="contact-modal" class="fixed inset-0 bg-gray-600 bg-opacity-50 overflow-y-auto h-full w-full">
+    <div class="relative top-20 mx-auto p-5 border w-full max-w-2xl shadow-lg rounded-md bg-white">
+        <div class="flex justify-end">
+            <button hx-target="#contact-modal" hx-swap="outerHTML" hx-get="/modal/close" class="text-gray-400 hover:text-gray-600">&times;</button>
+        </div>
+        <h3 class="text-xl font-bold mb-1">Trash</h3>
+        <p class="text-sm text-gray-500 mb-4">{{if .Retention}}Contacts are purged {{.Retention}} days after deletion.{{else}}Contacts stay here until purged.{{end}}</p>
+        {{if not .Contacts}}
+        <div class="p-4 bg-gray-100 text-gray-500 rounded-lg">Trash is empty.</div>
+        {{end}}
+        {{range .Contacts}}
+        <div class="flex justify-between items-center p-3 mb-2 border rounded-lg" id="trash-{{.ID}}">
+            <div>
+                <strong class="text-gray-800">{{.FirstName}} {{.LastName}}</strong>
+                <span class="block text-xs text-gray-500">{{.ContactType}} &middot; deleted {{.DeletedAt.Format "2 Jan 2006 15:04"}}</span>
+            </div>
+            <div class="space-x-2">
+                <button class="px-3 py-1 text-sm rounded-lg border border-gray-300 hover:border-blue-500 hover:bg-blue-50 transition-colors"
+                    hx-post="/trash/{{.ID}}/restore?from=trash"
+                    hx-target="#trash-{{.ID}}"
+                    hx-swap="outerHTML">
+                    Restore
+                </button>
+                <button class="px-3 py-1 text-sm rounded-lg border border-gray-300 hover:border-red-500 hover:bg-red-50 transition-colors"
+                    hx-delete="/trash/{{.ID}}"
+                    hx-target="#trash-{{.ID}}"
+                    hx-swap="outerHTML"
+                    hx-confirm="Delete this contact forever? This cannot be undone.">
+                    Delete forever
+                </button>
+            </div>
+        </div>
+        {{end}}
+    </div>
+</div>
+`
+
+var trashModal = template.Must(template.New("trash-modal").Parse(trashModalHTML))
+
+// trashView lists trashed contacts, most recently deleted first
+func trashView(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html")
+	err := trashModal.Execute(w, map[string]any{
+		"Contacts":  store.Trash(),
+		"Retention": trashRetentionDays,
+	})
+	if err != nil {
+		fmt.Printf("Error rendering trash: %v\n", err)
+	}
+}
+
+// undeleteContact restores a trashed contact. from the toast the card takes
+// the toast's place, from the trash view the row is removed and the card is
+// added back to the list out of band
+func undeleteContact(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	contact, err := store.Undelete(id, currentUser(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	fmt.Printf("Contact %s restored from trash\n", id)
+
+	if r.URL.Query().Get("from") != "trash" {
+		renderCard(w, contact)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	fmt.Fprint(w, `<div hx-swap-oob="afterbegin:#contact-list">`)
+	conCard.Execute(w, contact)
+	fmt.Fprint(w, `</div>`)
+}
+
+// purgeContact permanently removes a contact from the trash
+func purgeContact(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if err := store.Purge(id); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	fmt.Printf("Contact %s purged from trash\n", id)
+	w.WriteHeader(http.StatusOK)
+}
+
+// startTrashPurger purges contacts past the retention period now and then
+// every hour in the background
+func startTrashPurger() {
+	if trashRetentionDays <= 0 {
+		return
+	}
+	retention := time.Duration(trashRetentionDays) * 24 * time.Hour
+	purge := func() {
+		n, err := store.PurgeExpired(retention)
+		if err != nil {
+			fmt.Printf("Error purging trash: %v\n", err)
+			return
+		}
+		if n > 0 {
+			fmt.Printf("Purged %d contacts older than %d days from trash\n", n, trashRetentionDays)
+		}
+	}
+	purge()
+	go func() {
+		for range time.Tick(time.Hour) {
+			purge()
+		}
+	}()
+}
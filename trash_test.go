@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+	"time"
+)
+
+func TestTrash(t *testing.T) {
+	s := newTestStore(t, Contact{ID: "alice", FirstName: "Alice"}, Contact{ID: "bob", FirstName: "Bob"})
+
+	deleted, err := s.Delete("alice", "af")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !deleted.InTrash() {
+		t.Fatal("deleted contact is not in the trash")
+	}
+	if _, err := s.Find("alice"); err == nil {
+		t.Error("found a contact in the trash")
+	}
+	if _, err := s.Delete("alice", "af"); err == nil {
+		t.Error("deleted a contact already in the trash")
+	}
+	if got := len(s.List()); got != 1 {
+		t.Errorf("listed %d contacts, want 1", got)
+	}
+	if trash := s.Trash(); len(trash) != 1 || trash[0].ID != "alice" {
+		t.Errorf("trash holds %+v, want alice", trash)
+	}
+
+	//undo brings the contact back as it was
+	if _, err := s.Undelete("bob", "af"); err == nil {
+		t.Error("undeleted a contact that is not in the trash")
+	}
+	restored, err := s.Undelete("alice", "af")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.InTrash() || restored.FirstName != "Alice" {
+		t.Errorf("undeleted %+v", restored)
+	}
+	if _, err := s.Find("alice"); err != nil {
+		t.Error(err)
+	}
+	if len(s.Trash()) != 0 {
+		t.Error("trash is not empty after undo")
+	}
+	if err := s.Purge("alice"); err == nil {
+		t.Error("purged a contact that is not in the trash")
+	}
+}
+
+func TestPurgeExpired(t *testing.T) {
+	now := time.Now()
+	s := newTestStore(t,
+		Contact{ID: "old", FirstName: "Old", DeletedAt: now.Add(-40 * 24 * time.Hour)},
+		Contact{ID: "recent", FirstName: "Recent", DeletedAt: now.Add(-2 * 24 * time.Hour)},
+		Contact{ID: "kept", FirstName: "Kept"},
+	)
+	if err := s.history.Record(nil, &Contact{ID: "old", FirstName: "Old"}, "af", "create"); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := s.PurgeExpired(30 * 24 * time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("purged %d contacts, want 1", n)
+	}
+	if _, err := s.storage.Get("old"); err == nil {
+		t.Error("purged contact is still in storage")
+	}
+	if len(s.History("old")) != 0 {
+		t.Error("purged contact still has history")
+	}
+	if trash := s.Trash(); len(trash) != 1 || trash[0].ID != "recent" {
+		t.Errorf("trash holds %+v, want recent", trash)
+	}
+	if _, err := s.Find("kept"); err != nil {
+		t.Error(err)
+	}
+	if n, _ := s.PurgeExpired(30 * 24 * time.Hour); n != 0 {
+		t.Errorf("purged %d contacts again, want 0", n)
+	}
+}
+
+func TestPhotoLifecycle(t *testing.T) {
+	defer func(p *PhotoStore) { photos = p }(photos)
+	photos = NewPhotoStore("")
+	var picture bytes.Buffer
+	if err := png.Encode(&picture, image.NewRGBA(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+	upload := func() *PhotoUpload {
+		t.Helper()
+		u, err := photos.Prepare(picture.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+	withPhoto := func(u *PhotoUpload) ContactUpdate {
+		return ContactUpdate{Contact: Contact{Photo: u.ID}, Fields: []string{"Photo"}, Upload: u}
+	}
+
+	s := newTestStore(t)
+	first := upload()
+	alice, err := s.New(Contact{FirstName: "Alice", Photo: first.ID}, first, "af")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !photos.Has(first.ID) {
+		t.Fatal("photo of a new contact was not saved")
+	}
+
+	//a stale edit leaves its upload unsaved
+	stale := upload()
+	if _, err := s.Update(alice.ID, alice.Version+1, withPhoto(stale), "af"); err == nil {
+		t.Fatal("stale edit was accepted")
+	}
+	if photos.Has(stale.ID) {
+		t.Error("photo of a stale edit was saved")
+	}
+
+	//a new photo replaces the old one
+	second := upload()
+	if _, err := s.Update(alice.ID, alice.Version, withPhoto(second), "af"); err != nil {
+		t.Fatal(err)
+	}
+	if photos.Has(first.ID) || !photos.Has(second.ID) {
+		t.Errorf("after a new photo old kept %v, new saved %v", photos.Has(first.ID), photos.Has(second.ID))
+	}
+
+	//purging the contact deletes its photo
+	if _, err := s.Delete(alice.ID, "af"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Purge(alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if photos.Has(second.ID) {
+		t.Error("photo of a purged contact was kept")
+	}
+}
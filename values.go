@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// labels an email address or phone number can carry
+var valueLabels = []string{"work", "home", "mobile", "other"}
+
+// ContactValue is one labeled email address or phone number
+type ContactValue struct {
+	Label   string
+	Value   string
+	Primary bool `json:",omitempty"`
+}
+
+// String shows a value as "a@b.com (work, primary)", the form it takes in
+// history
+func (v ContactValue) String() string {
+	if v.Primary {
+		return fmt.Sprintf("%s (%s, primary)", v.Value, v.Label)
+	}
+	return fmt.Sprintf("%s (%s)", v.Value, v.Label)
+}
+
+func formatValues(values []ContactValue) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = v.String()
+	}
+	return strings.Join(parts, "; ")
+}
+
+// encodeValues writes values in the form the merge modal submits them
+func encodeValues(values []ContactValue) string {
+	if len(values) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func decodeValues(text string) ([]ContactValue, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+	var values []ContactValue
+	if err := json.Unmarshal([]byte(text), &values); err != nil {
+		return nil, fmt.Errorf("invalid values: %w", err)
+	}
+	return normalizeValues(values), nil
+}
+
+// normalizeValues trims values, drops empty ones, maps unknown labels to
+// "other" and leaves exactly one primary, the first marked or else the first
+func normalizeValues(values []ContactValue) []ContactValue {
+	var out []ContactValue
+	primary := false
+	for _, v := range values {
+		v.Value = strings.TrimSpace(v.Value)
+		if v.Value == "" {
+			continue
+		}
+		v.Label = strings.ToLower(strings.TrimSpace(v.Label))
+		if !validLabel(v.Label) {
+			v.Label = "other"
+		}
+		if v.Primary && primary {
+			v.Primary = false
+		}
+		primary = primary || v.Primary
+		out = append(out, v)
+	}
+	if !primary && len(out) > 0 {
+		out[0].Primary = true
+	}
+	return out
+}
+
+func validLabel(label string) bool {
+	for _, l := range valueLabels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+// primaryValue returns the primary value of a list, "" when it is empty
+func primaryValue(values []ContactValue) string {
+	for _, v := range values {
+		if v.Primary {
+			return v.Value
+		}
+	}
+	if len(values) > 0 {
+		return values[0].Value
+	}
+	return ""
+}
+
+func (c Contact) PrimaryEmail() string {
+	return primaryValue(c.Emails)
+}
+
+func (c Contact) PrimaryPhone() string {
+	return primaryValue(c.Phones)
+}
+
+// mobilePhone is the number for messaging apps: the primary phone if it is
+// a mobile, else the first mobile. "" when there is no mobile number
+func (c Contact) MobilePhone() string {
+	var mobiles []ContactValue
+	for _, v := range c.Phones {
+		if v.Label == "mobile" {
+			mobiles = append(mobiles, v)
+		}
+	}
+	return primaryValue(mobiles)
+}
+
+// valuesFromForm reads the email or phone rows of the add and edit modals,
+// kind being "Email" or "Phone". each row sends kind+"Row", kind+"Label"
+// and kind+"Value", and the primary radio sends the row it picked. an
+// encoded kind+"s" field, as sent by the merge modal, or a single kind
+// field, as sent by older clients, are read too
+func valuesFromForm(r *http.Request, kind string) ([]ContactValue, error) {
+	if text, ok := r.Form[kind+"s"]; ok {
+		return decodeValues(strings.Join(text, ""))
+	}
+	rows, labels, values := r.Form[kind+"Row"], r.Form[kind+"Label"], r.Form[kind+"Value"]
+	if len(values) == 0 {
+		if single := r.FormValue(kind); single != "" {
+			label := "other"
+			if kind == "Phone" {
+				label = "mobile"
+			}
+			return normalizeValues([]ContactValue{{Label: label, Value: single, Primary: true}}), nil
+		}
+		return nil, nil
+	}
+	primary := r.FormValue(kind + "Primary")
+	var out []ContactValue
+	for i, value := range values {
+		v := ContactValue{Value: value}
+		if i < len(labels) {
+			v.Label = labels[i]
+		}
+		if i < len(rows) && rows[i] == primary {
+			v.Primary = true
+		}
+		out = append(out, v)
+	}
+	return normalizeValues(out), nil
+}
+
+// validateValues checks a contact has at least one email and phone, and
+// that every email looks like one
+func validateValues(emails, phones []ContactValue) error {
+	if len(emails) == 0 || len(phones) == 0 {
+		return fmt.Errorf("At least one email and one phone are required")
+	}
+	for _, v := range emails {
+		if !emailRegex.MatchString(v.Value) {
+			return fmt.Errorf("Invalid email address format: %s", v.Value)
+		}
+	}
+	return nil
+}
+
+// valueRow is one email or phone row in a modal
+type valueRow struct {
+	Kind string // Email or Phone
+	Row  string // pairs the row with the primary radio
+	ContactValue
+}
+
+var rowSeq atomic.Int64
+
+// newValueRow gives a value a row token unique to this server run
+func newValueRow(kind string, v ContactValue) valueRow {
+	return valueRow{Kind: kind, Row: fmt.Sprintf("r%d", rowSeq.Add(1)), ContactValue: v}
+}
+
+var valueRowHTML = `{{define "value-row"}}
+<div class="value-row flex items-center space-x-2 mb-2">
+    <input type="hidden" name="{{.Kind}}Row" value="{{.Row}}">
+    <select name="{{.Kind}}Label" class="shadow border rounded py-2 px-2 text-gray-700 text-sm focus:outline-none focus:shadow-outline">
+        {{range labels}}<option value="{{.}}" {{if eq . $.Label}}selected{{end}}>{{.}}</option>{{end}}
+    </select>
+    <input class="shadow appearance-none border rounded w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:shadow-outline" name="{{.Kind}}Value" type="{{if eq .Kind "Email"}}email{{else}}tel{{end}}" value="{{.Value}}" placeholder="{{.Kind}}" required>
+    <label class="flex items-center text-xs text-gray-500" title="Primary">
+        <input type="radio" name="{{.Kind}}Primary" value="{{.Row}}" class="mr-1" {{if .Primary}}checked{{end}}>primary
+    </label>
+    <button type="button" hx-get="/modal/close" hx-target="closest .value-row" hx-swap="outerHTML" class="text-gray-400 hover:text-red-600" title="Remove">&times;</button>
+</div>
+{{end}}
+{{define "value-rows"}}
+<div id="{{.Kind | lower}}-rows">
+    {{range .Values}}{{template "value-row" (row $.Kind .)}}{{end}}
+</div>
+<button type="button" class="text-sm text-blue-600 hover:underline"
+    hx-get="/modal/row/{{.Kind | lower}}"
+    hx-target="#{{.Kind | lower}}-rows"
+    hx-swap="beforeend">
+    + Add {{.Kind | lower}}
+</button>
+{{end}}`
+
+var rowFuncs = template.FuncMap{
+	"labels": func() []string { return valueLabels },
+	"lower":  strings.ToLower,
+	"row":    newValueRow,
+	"rows": func(kind string, values []ContactValue) map[string]any {
+		return map[string]any{"Kind": kind, "Values": values}
+	},
+}
+
+var valueRowTemplate = template.Must(template.New("rows").Funcs(rowFuncs).Funcs(addressFuncs).Funcs(orgFuncs).Funcs(tagFuncs).Funcs(typeFuncs).Funcs(fieldFuncs).Funcs(avatarFuncs).Funcs(groupFuncs).Funcs(handleFuncs).Parse(valueRowHTML + addressRowHTML + orgFieldHTML + dateRowHTML + tagInputHTML + typeSelectHTML + customFieldsHTML + avatarHTML + groupChecksHTML + handleRowHTML))
+
+// modalTemplate parses a modal that lays out email, phone, address and
+// date rows and the organization picker
+func modalTemplate(name, html string) *template.Template {
+	return template.Must(template.Must(valueRowTemplate.Clone()).New(name).Parse(html))
+}
+
+// valueRowView returns an empty row for the add and edit modals
+func valueRowView(w http.ResponseWriter, r *http.Request) {
+	kind, label := "Email", "home"
+	if strings.HasSuffix(r.URL.Path, "/phone") {
+		kind, label = "Phone", "mobile"
+	}
+	w.Header().Set("Content-Type", "text/html")
+	if err := valueRowTemplate.ExecuteTemplate(w, "value-row", newValueRow(kind, ContactValue{Label: label})); err != nil {
+		fmt.Printf("Error rendering %s row: %v\n", kind, err)
+	}
+}
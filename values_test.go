@@ -0,0 +1,87 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValuesRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []ContactValue
+		want   []ContactValue
+	}{
+		{name: "none"},
+		{
+			name:   "semicolons kept",
+			values: []ContactValue{{Label: "mobile", Value: "+1 555 1234;ext=2", Primary: true}, {Label: "work", Value: "a; b"}},
+			want:   []ContactValue{{Label: "mobile", Value: "+1 555 1234;ext=2", Primary: true}, {Label: "work", Value: "a; b"}},
+		},
+		{
+			name:   "normalized",
+			values: []ContactValue{{Label: " Work ", Value: " a@example.com "}, {Label: "pager", Value: "b@example.com", Primary: true}, {Label: "home", Value: " "}},
+			want:   []ContactValue{{Label: "work", Value: "a@example.com"}, {Label: "other", Value: "b@example.com", Primary: true}},
+		},
+		{
+			name:   "one primary",
+			values: []ContactValue{{Label: "home", Value: "a", Primary: true}, {Label: "home", Value: "b", Primary: true}},
+			want:   []ContactValue{{Label: "home", Value: "a", Primary: true}, {Label: "home", Value: "b"}},
+		},
+		{
+			name:   "first made primary",
+			values: []ContactValue{{Label: "home", Value: "a"}, {Label: "home", Value: "b"}},
+			want:   []ContactValue{{Label: "home", Value: "a", Primary: true}, {Label: "home", Value: "b"}},
+		},
+	}
+	for _, tt := range tests {
+		got, err := decodeValues(encodeValues(tt.values))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: round trip gave %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+	if _, err := decodeValues("a@example.com; b@example.com"); err == nil {
+		t.Error("decodeValues read a list that is not JSON")
+	}
+}
+
+func TestUpdateKeepsSemicolons(t *testing.T) {
+	s := newTestStore(t, Contact{ID: "alice", Version: 1, FirstName: "Alice",
+		Phones: []ContactValue{{Label: "mobile", Value: "+1 555 1234", Primary: true}},
+	})
+	phones := []ContactValue{{Label: "work", Value: "+1 555 1234;ext=2", Primary: true}, {Label: "home", Value: "a; b"}}
+	update := ContactUpdate{Contact: Contact{Phones: phones}, Fields: []string{"Phones"}}
+	saved, err := s.Update("alice", 1, update, "af")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(saved.Phones, phones) {
+		t.Errorf("saved phones %+v, want %+v", saved.Phones, phones)
+	}
+	if saved.FirstName != "Alice" {
+		t.Errorf("a field left out of the update changed to %q", saved.FirstName)
+	}
+}
+
+func TestValidateValues(t *testing.T) {
+	email := []ContactValue{{Label: "work", Value: "a@example.com", Primary: true}}
+	phone := []ContactValue{{Label: "mobile", Value: "+1 555 1234", Primary: true}}
+	tests := []struct {
+		name          string
+		emails, phone []ContactValue
+		err           bool
+	}{
+		{name: "valid", emails: email, phone: phone},
+		{name: "no email", phone: phone, err: true},
+		{name: "no phone", emails: email, err: true},
+		{name: "bad email", emails: append(email, ContactValue{Label: "home", Value: "not an email"}), phone: phone, err: true},
+	}
+	for _, tt := range tests {
+		if err := validateValues(tt.emails, tt.phone); (err != nil) != tt.err {
+			t.Errorf("%s: validateValues returned %v, want an error: %v", tt.name, err, tt.err)
+		}
+	}
+}